@@ -0,0 +1,176 @@
+// Package client is a Go client for the CloudBoxIO HTTP API.
+//
+//	c := client.New("http://127.0.0.1:3000")
+//	if _, err := c.Login(ctx, "admin", "secret"); err != nil {
+//		return err
+//	}
+//	page, err := c.ListFiles(ctx, client.ListOptions{Shared: true})
+//
+// Responses use the types of the models package, so the client and server always agree
+// on the shape of the data.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Client talks to one CloudBoxIO server. It is safe for concurrent use once logged in.
+type Client struct {
+	// BaseURL is the server root, e.g. "http://127.0.0.1:3000"
+	BaseURL string
+	// Token is the JWT sent with every request, set by Login
+	Token string
+	// HTTPClient sends the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// TrustCertFile makes the client accept server certificates signed by the PEM certificates in
+// path, in addition to the system roots. Use it for servers with a self-signed certificate.
+func (c *Client) TrustCertFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no PEM certificate in %s", path)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	c.HTTPClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// APIError is returned when the server answers with an error status.
+type APIError struct {
+	StatusCode int
+	// Code is the machine readable error code, such as "not_found"
+	Code    string
+	Message string
+	// RequestID identifies the request in the server log
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server responded with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s (status %d)", e.Message, e.StatusCode)
+}
+
+// IsStatus reports whether err is an APIError with the given status code.
+func IsStatus(err error, code int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
+
+// ProgressFunc is called as a transfer advances. total is -1 when the size is unknown.
+type ProgressFunc func(done, total int64)
+
+// newRequest builds an authenticated request for an API path such as "/files".
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := c.BaseURL + "/api/v2" + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+// do sends req and turns error statuses into an APIError. The caller closes the body.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		apiErr := &APIError{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+		var body struct {
+			Error struct {
+				Code      string `json:"code"`
+				Message   string `json:"message"`
+				RequestID string `json:"request_id"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body) == nil {
+			apiErr.Code = body.Error.Code
+			apiErr.Message = body.Error.Message
+			if body.Error.RequestID != "" {
+				apiErr.RequestID = body.Error.RequestID
+			}
+		}
+		return nil, apiErr
+	}
+
+	return resp, nil
+}
+
+// doJSON sends in as a JSON body, when not nil, and decodes the response into out, when not nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out any) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,183 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/client"
+	"github.com/AumSahayata/cloudboxio/client/clienttest"
+	"github.com/AumSahayata/cloudboxio/models"
+)
+
+func TestClientFilesRoundTrip(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	ctx := context.Background()
+
+	c := client.New(srv.URL)
+	if _, err := c.Login(ctx, clienttest.AdminUsername, "wrong"); !client.IsStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("expected 401 for bad credentials, got %v", err)
+	}
+	if _, err := c.Login(ctx, clienttest.AdminUsername, clienttest.AdminPassword); err != nil {
+		t.Fatal("login failed:", err)
+	}
+
+	// Upload reports progress up to the full size
+	content := strings.Repeat("cloudbox ", 10000)
+	var lastDone, lastTotal int64
+	err := c.Upload(ctx, "notes.txt", strings.NewReader(content), int64(len(content)), client.UploadOptions{
+		Progress: func(done, total int64) { lastDone, lastTotal = done, total },
+	})
+	if err != nil {
+		t.Fatal("upload failed:", err)
+	}
+	if lastDone != int64(len(content)) || lastTotal != int64(len(content)) {
+		t.Fatalf("unexpected progress %d/%d", lastDone, lastTotal)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	os.WriteFile(path, []byte("a,b\n1,2\n"), 0644)
+	if err := c.UploadFile(ctx, path, client.UploadOptions{Shared: true}); err != nil {
+		t.Fatal("upload of file failed:", err)
+	}
+
+	page, err := c.ListFiles(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal("list failed:", err)
+	}
+	if page.Total != 1 || len(page.Files) != 1 || page.Files[0].Filename != "notes.txt" || page.Files[0].Size != int64(len(content)) {
+		t.Fatalf("unexpected personal listing: %+v", page)
+	}
+	notes := page.Files[0]
+
+	shared, err := c.ListFiles(ctx, client.ListOptions{Shared: true})
+	if err != nil || len(shared.Files) != 1 || shared.Files[0].Filename != "report.csv" {
+		t.Fatalf("unexpected shared listing: %+v %v", shared, err)
+	}
+
+	// Download to a writer and into a directory
+	var buf bytes.Buffer
+	name, err := c.Download(ctx, notes.FileID, &buf, nil)
+	if err != nil || name != "notes.txt" || buf.String() != content {
+		t.Fatalf("download mismatch: %q %v", name, err)
+	}
+
+	dir := t.TempDir()
+	dest, err := c.DownloadFile(ctx, shared.Files[0].FileID, dir, nil)
+	if err != nil || dest != filepath.Join(dir, "report.csv") {
+		t.Fatalf("download to dir failed: %s %v", dest, err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "a,b\n1,2\n" {
+		t.Fatalf("unexpected downloaded contents %q", data)
+	}
+
+	// Share moves the file to the shared space
+	results, err := c.Share(ctx, true, notes.FileID)
+	if err != nil || len(results) != 1 || !results[0].OK {
+		t.Fatalf("share failed: %+v %v", results, err)
+	}
+	if shared, _ = c.ListFiles(ctx, client.ListOptions{Shared: true}); shared.Total != 2 {
+		t.Fatalf("expected 2 shared files, got %d", shared.Total)
+	}
+
+	// Metadata and the change journal
+	description := "weekly notes"
+	meta, err := c.UpdateMeta(ctx, notes.FileID, models.MetaUpdate{Description: &description, Tags: &[]string{"Work"}})
+	if err != nil || meta.Description != description || len(meta.Tags) != 1 || meta.Tags[0] != "work" {
+		t.Fatalf("unexpected meta update: %+v %v", meta, err)
+	}
+
+	changes, err := c.Changes(ctx, "", 0)
+	if err != nil || len(changes.Changes) < 3 {
+		t.Fatalf("unexpected changes: %+v %v", changes, err)
+	}
+
+	if err := c.Delete(ctx, notes.FileID); err != nil {
+		t.Fatal("delete failed:", err)
+	}
+	_, err = c.Download(ctx, notes.FileID, &buf, nil)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "not_found" || apiErr.RequestID == "" {
+		t.Fatalf("expected a not_found error after delete, got %+v", err)
+	}
+}
+
+func TestClientUserAdmin(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	ctx := context.Background()
+
+	admin := client.New(srv.URL)
+	if _, err := admin.Login(ctx, clienttest.AdminUsername, clienttest.AdminPassword); err != nil {
+		t.Fatal("login failed:", err)
+	}
+
+	if err := admin.CreateUser(ctx, models.SignUp{Username: "alice", Password: "alice-password"}); err != nil {
+		t.Fatal("create user failed:", err)
+	}
+	if err := admin.CreateUser(ctx, models.SignUp{Username: "bob"}); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Fatalf("expected a user without password to fail, got %v", err)
+	}
+
+	users, err := admin.Users(ctx)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("unexpected users: %+v %v", users, err)
+	}
+
+	alice := client.New(srv.URL)
+	if _, err := alice.Login(ctx, "alice", "alice-password"); err != nil {
+		t.Fatal("user login failed:", err)
+	}
+	info, err := alice.UserInfo(ctx)
+	if err != nil || info.Username != "alice" || info.IsAdmin {
+		t.Fatalf("unexpected user info: %+v %v", info, err)
+	}
+	if _, err := alice.Users(ctx); !client.IsStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected 403 for non-admin, got %v", err)
+	}
+
+	// A failed login shows in the history, which only the user and admins can read
+	if _, err := client.New(srv.URL).Login(ctx, "alice", "wrong"); !client.IsStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("expected 401 for a wrong password, got %v", err)
+	}
+	attempts, err := alice.LoginHistory(ctx, info.ID, 10)
+	if err != nil || len(attempts) != 2 || attempts[0].Result != "failure" || attempts[1].Result != "success" {
+		t.Fatalf("unexpected login history: %+v %v", attempts, err)
+	}
+	if err := alice.UnlockUser(ctx, info.ID); !client.IsStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected 403 for non-admin unlock, got %v", err)
+	}
+	if err := admin.UnlockUser(ctx, info.ID); err != nil {
+		t.Fatal("unlock failed:", err)
+	}
+
+	// Users edit their own profile, admins also the role and state
+	name := "Alice"
+	if updated, err := alice.UpdateUser(ctx, info.ID, models.UserUpdate{DisplayName: &name}); err != nil || updated.DisplayName != name {
+		t.Fatalf("unexpected profile update: %+v %v", updated, err)
+	}
+	disabled := true
+	if _, err := alice.UpdateUser(ctx, info.ID, models.UserUpdate{Disabled: &disabled}); !client.IsStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected 403 for a non-admin disabling, got %v", err)
+	}
+	if updated, err := admin.UpdateUser(ctx, info.ID, models.UserUpdate{Disabled: &disabled}); err != nil || !updated.Disabled || updated.DisplayName != name {
+		t.Fatalf("unexpected admin update: %+v %v", updated, err)
+	}
+	if _, err := alice.UserInfo(ctx); !client.IsStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected 403 for a disabled user, got %v", err)
+	}
+
+	if err := admin.DeleteUserWithFiles(ctx, info.ID, models.UserFilesTransfer, ""); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Fatalf("expected 400 for a transfer without a receiver, got %v", err)
+	}
+	if err := admin.DeleteUser(ctx, info.ID); err != nil {
+		t.Fatal("delete user failed:", err)
+	}
+	if users, _ = admin.Users(ctx); len(users) != 1 {
+		t.Fatalf("expected 1 user after delete, got %d", len(users))
+	}
+}
@@ -0,0 +1,110 @@
+// Package clienttest runs an in-process CloudBoxIO server for testing API clients.
+package clienttest
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/handlers"
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/tests"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credentials of the admin user every test server starts with.
+const (
+	AdminUsername = "admin"
+	AdminPassword = "admin-password"
+)
+
+// Server is a running test server with the full API mounted under /api/v2 and /api.
+type Server struct {
+	// URL is the server root to pass to client.New
+	URL      string
+	DB       *sql.DB
+	FilesDir string
+}
+
+// NewServer starts a server on a random local port backed by an in-memory database and
+// a temporary files directory. It is shut down when the test ends.
+func NewServer(t *testing.T) *Server {
+	t.Helper()
+	return newServer(t, nil)
+}
+
+// NewTLSServer is like NewServer but serves HTTPS with tlsConfig.
+func NewTLSServer(t *testing.T, tlsConfig *tls.Config) *Server {
+	t.Helper()
+	return newServer(t, tlsConfig)
+}
+
+func newServer(t *testing.T, tlsConfig *tls.Config) *Server {
+	t.Helper()
+
+	database := tests.SetupTestDB(t)
+	// The in-memory database only exists on one connection
+	database.SetMaxOpenConns(1)
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(AdminPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal("failed to hash password:", err)
+	}
+	if _, err := database.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, ?, ?)`, "admin-id", AdminUsername, hashed, true); err != nil {
+		t.Fatal("failed to create admin user:", err)
+	}
+	tests.SetAdminSetupFlag(database, true)
+
+	// The test schema has no default upload time, which the listings expect
+	if _, err := database.Exec(`CREATE TRIGGER IF NOT EXISTS test_uploaded_at AFTER INSERT ON metadata WHEN NEW.uploaded_at IS NULL BEGIN
+		UPDATE metadata SET uploaded_at = strftime('%Y-%m-%d %H:%M:%S', 'now') WHERE id = NEW.id;
+	END;`); err != nil {
+		t.Fatal("failed to prepare test schema:", err)
+	}
+
+	filesDir := t.TempDir()
+	cfg := internal.DefaultConfig()
+	cfg.FilesDir = filesDir
+	cfg.SharedDir = "shared"
+	settings, err := internal.LoadSettings(database, cfg)
+	if err != nil {
+		t.Fatal("failed to load settings:", err)
+	}
+
+	discard := log.New(io.Discard, "", 0)
+	internal.FileOps = discard
+	internal.Info = discard
+	internal.Error = discard
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true, ErrorHandler: handlers.ErrorHandler})
+	if err := handlers.RegisterAPI(app, database, cfg, settings, discard, discard); err != nil {
+		t.Fatal("failed to register the API:", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		scheme = "https"
+	}
+	go app.Listener(ln)
+
+	t.Cleanup(func() {
+		app.Shutdown()
+		database.Close()
+	})
+
+	return &Server{
+		URL:      scheme + "://" + ln.Addr().String(),
+		DB:       database,
+		FilesDir: filesDir,
+	}
+}
@@ -0,0 +1,299 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/AumSahayata/cloudboxio/models"
+)
+
+// ListOptions filter and page a file listing. Zero values are left out of the request.
+type ListOptions struct {
+	Shared   bool
+	Keyword  string
+	Ext      []string
+	Type     []string
+	Tags     []string
+	Uploader string
+	Sort     string
+	Order    string
+	Limit    int
+	Cursor   string
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.Shared {
+		q.Set("shared", "true")
+	}
+	set := func(key, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	set("keyword", o.Keyword)
+	set("ext", strings.Join(o.Ext, ","))
+	set("type", strings.Join(o.Type, ","))
+	set("tag", strings.Join(o.Tags, ","))
+	set("uploader", o.Uploader)
+	set("sort", o.Sort)
+	set("order", o.Order)
+	set("cursor", o.Cursor)
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return q
+}
+
+// FilePage is one page of a file listing.
+type FilePage struct {
+	Files []models.File
+	// Total counts every matching file, not just this page
+	Total int
+	// NextCursor fetches the following page, empty on the last one
+	NextCursor string
+}
+
+// ListFiles lists the user's personal files, or the shared space with Shared set.
+func (c *Client) ListFiles(ctx context.Context, opts ListOptions) (*FilePage, error) {
+	page := &FilePage{}
+	resp, err := c.doJSON(ctx, http.MethodGet, "/files", opts.query(), nil, &page.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Total, _ = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	page.NextCursor = resp.Header.Get("X-Next-Cursor")
+	return page, nil
+}
+
+// Search finds files by name and contents.
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]models.SearchHit, error) {
+	q := url.Values{"q": {query}}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var hits []models.SearchHit
+	if _, err := c.doJSON(ctx, http.MethodGet, "/search", q, nil, &hits); err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// UploadOptions control an upload.
+type UploadOptions struct {
+	// Shared stores the file in the shared space instead of the user's own
+	Shared bool
+	// Progress, when set, is called as the request body is sent
+	Progress ProgressFunc
+}
+
+// Upload streams r to the server as a file called filename. size is only used to
+// report progress and may be -1 when unknown.
+func (c *Client) Upload(ctx context.Context, filename string, r io.Reader, size int64, opts UploadOptions) error {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	// The multipart body is produced while it is sent so large files are never buffered
+	go func() {
+		part, err := writer.CreateFormFile("files", filename)
+		if err == nil {
+			_, err = io.Copy(part, &progressReader{r: r, total: size, progress: opts.Progress})
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	q := url.Values{}
+	if opts.Shared {
+		q.Set("shared", "true")
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/upload", q, pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.do(req)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// UploadFile uploads the file at path under its base name.
+func (c *Client) UploadFile(ctx context.Context, path string, opts UploadOptions) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	return c.Upload(ctx, filepath.Base(path), file, info.Size(), opts)
+}
+
+// Download writes the contents of a file to w and returns the filename the server sent.
+func (c *Client) Download(ctx context.Context, fileID string, w io.Writer, progress ProgressFunc) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/file/"+url.PathEscape(fileID), nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	filename := fileID
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		filename = params["filename"]
+	}
+
+	_, err = io.Copy(w, &progressReader{r: resp.Body, total: resp.ContentLength, progress: progress})
+	return filename, err
+}
+
+// DownloadFile saves a file to path, or into path under the server's filename when path
+// is a directory, and returns where it was written.
+func (c *Client) DownloadFile(ctx context.Context, fileID, path string, progress ProgressFunc) (string, error) {
+	intoDir := false
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		intoDir = true
+	}
+
+	// Download next to the destination first so a failed transfer never leaves a partial file behind
+	dir := filepath.Dir(path)
+	if intoDir {
+		dir = path
+	}
+	tmp, err := os.CreateTemp(dir, ".cloudbox-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	filename, err := c.Download(ctx, fileID, tmp, progress)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dest := path
+	if intoDir {
+		dest = filepath.Join(path, filepath.Base(filepath.FromSlash(filename)))
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// Delete removes a single file.
+func (c *Client) Delete(ctx context.Context, fileID string) error {
+	_, err := c.doJSON(ctx, http.MethodDelete, "/file/"+url.PathEscape(fileID), nil, nil, nil)
+	return err
+}
+
+// DeleteFiles removes several files, reporting the outcome for each.
+func (c *Client) DeleteFiles(ctx context.Context, fileIDs ...string) ([]models.BatchResult, error) {
+	return c.batch(ctx, "/files/delete", models.BatchRequest{FileIDs: fileIDs})
+}
+
+// Move moves files into a folder of the space they are in.
+func (c *Client) Move(ctx context.Context, folder string, fileIDs ...string) ([]models.BatchResult, error) {
+	return c.batch(ctx, "/files/move", models.BatchRequest{FileIDs: fileIDs, Folder: folder})
+}
+
+// Share moves files into the shared space, or back to their owner's space when shared is false.
+func (c *Client) Share(ctx context.Context, shared bool, fileIDs ...string) ([]models.BatchResult, error) {
+	return c.batch(ctx, "/files/share", models.BatchRequest{FileIDs: fileIDs, Shared: shared})
+}
+
+func (c *Client) batch(ctx context.Context, path string, req models.BatchRequest) ([]models.BatchResult, error) {
+	var resp struct {
+		Results []models.BatchResult `json:"results"`
+	}
+	if _, err := c.doJSON(ctx, http.MethodPost, path, nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// Meta returns the description, tags and properties of a file.
+func (c *Client) Meta(ctx context.Context, fileID string) (*models.FileMeta, error) {
+	var meta models.FileMeta
+	if _, err := c.doJSON(ctx, http.MethodGet, "/file/"+url.PathEscape(fileID)+"/meta", nil, nil, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// UpdateMeta changes the metadata fields set in update and returns the result.
+func (c *Client) UpdateMeta(ctx context.Context, fileID string, update models.MetaUpdate) (*models.FileMeta, error) {
+	var meta models.FileMeta
+	if _, err := c.doJSON(ctx, http.MethodPatch, "/file/"+url.PathEscape(fileID)+"/meta", nil, update, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Changes returns the change journal after cursor, "" starting from the beginning.
+func (c *Client) Changes(ctx context.Context, cursor string, limit int) (*models.ChangeList, error) {
+	q := url.Values{}
+	if cursor != "" {
+		q.Set("since", cursor)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var list models.ChangeList
+	if _, err := c.doJSON(ctx, http.MethodGet, "/changes", q, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// progressReader reports how much of r has been read.
+type progressReader struct {
+	r        io.Reader
+	total    int64
+	done     int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if p.progress != nil && (n > 0 || err == io.EOF) {
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,148 @@
+package client_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/client"
+	"github.com/AumSahayata/cloudboxio/client/clienttest"
+	"github.com/AumSahayata/cloudboxio/internal"
+)
+
+func TestClientOverTLS(t *testing.T) {
+	internal.Info = log.New(io.Discard, "", 0)
+	internal.Error = log.New(io.Discard, "", 0)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	cfg := internal.DefaultConfig()
+	cfg.TLS.Mode = internal.TLSSelfSigned
+	cfg.TLS.CertFile = filepath.Join(dir, "certs", "cert.pem")
+	cfg.TLS.KeyFile = filepath.Join(dir, "certs", "key.pem")
+	cfg.TLS.Hosts = []string{"files.lan"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is generated on the first start and kept on the next
+	if _, _, err := internal.NewTLSConfig(cfg); err != nil {
+		t.Fatal("failed to set up TLS:", err)
+	}
+	first, err := os.ReadFile(cfg.TLS.CertFile)
+	if err != nil {
+		t.Fatal("self-signed certificate was not written:", err)
+	}
+	if _, _, err := internal.NewTLSConfig(cfg); err != nil {
+		t.Fatal("failed to set up TLS:", err)
+	}
+	if again, _ := os.ReadFile(cfg.TLS.CertFile); string(again) != string(first) {
+		t.Fatal("a valid self-signed certificate was replaced")
+	}
+	leaf := parseCert(t, cfg.TLS.CertFile)
+	if err := leaf.VerifyHostname("files.lan"); err != nil {
+		t.Error("configured host missing from the certificate:", err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error("loopback address missing from the certificate:", err)
+	}
+
+	reloader, err := internal.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Interval = 0
+	srv := clienttest.NewTLSServer(t, &tls.Config{GetCertificate: reloader.GetCertificate})
+
+	// Without trusting the certificate the connection is refused
+	c := client.New(srv.URL)
+	var unknownAuthority x509.UnknownAuthorityError
+	if _, err := c.Login(ctx, clienttest.AdminUsername, clienttest.AdminPassword); !errors.As(err, &unknownAuthority) {
+		t.Fatalf("expected an unknown authority error, got %v", err)
+	}
+
+	oldCert := filepath.Join(dir, "old.pem")
+	os.WriteFile(oldCert, first, 0644)
+	if err := c.TrustCertFile(oldCert); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, clienttest.AdminUsername, clienttest.AdminPassword); err != nil {
+		t.Fatal("login over TLS failed:", err)
+	}
+
+	// A replaced certificate is served without a restart
+	os.Remove(cfg.TLS.CertFile)
+	if err := internal.EnsureSelfSignedCert(cfg.TLS.CertFile, cfg.TLS.KeyFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.TLS.CertFile, later, later)
+
+	c = client.New(srv.URL)
+	if err := c.TrustCertFile(cfg.TLS.CertFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, clienttest.AdminUsername, clienttest.AdminPassword); err != nil {
+		t.Fatal("login with the reloaded certificate failed:", err)
+	}
+
+	// Plain HTTP is redirected to HTTPS, keeping the method and body of a login
+	_, port, _ := net.SplitHostPort(srv.URL[len("https://"):])
+	httpsPort, _ := strconv.Atoi(port)
+	redirect := httptest.NewServer(internal.RedirectToHTTPS(httpsPort))
+	defer redirect.Close()
+
+	plain := client.New(redirect.URL)
+	plain.HTTPClient = c.HTTPClient
+	if _, err := plain.Login(ctx, clienttest.AdminUsername, clienttest.AdminPassword); err != nil {
+		t.Fatal("login through the redirect failed:", err)
+	}
+	if _, err := plain.ListFiles(ctx, client.ListOptions{}); err != nil {
+		t.Fatal("listing through the redirect failed:", err)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	cases := []struct {
+		method, target string
+		port           int
+		status         int
+		location       string
+	}{
+		{"GET", "http://files.lan:8080/api/files?page=2", 8443, http.StatusMovedPermanently, "https://files.lan:8443/api/files?page=2"},
+		{"GET", "http://files.lan/", 443, http.StatusMovedPermanently, "https://files.lan/"},
+		{"POST", "http://10.0.0.2:8080/api/login", 3000, http.StatusPermanentRedirect, "https://10.0.0.2:3000/api/login"},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		internal.RedirectToHTTPS(tc.port).ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+		if rec.Code != tc.status || rec.Header().Get("Location") != tc.location {
+			t.Errorf("%s %s: expected %d to %s, got %d to %s", tc.method, tc.target, tc.status, tc.location, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func parseCert(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(path, filepath.Join(filepath.Dir(path), "key.pem"))
+	if err != nil {
+		t.Fatal("failed to load certificate:", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal("failed to parse certificate:", err)
+	}
+	return leaf
+}
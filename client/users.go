@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/AumSahayata/cloudboxio/models"
+)
+
+// Login exchanges credentials for a token, which the client uses from then on.
+func (c *Client) Login(ctx context.Context, username, password string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if _, err := c.doJSON(ctx, http.MethodPost, "/login", nil, models.Login{Username: username, Password: password}, &resp); err != nil {
+		return "", err
+	}
+	if resp.Token == "" {
+		return "", errors.New("server did not return a token")
+	}
+
+	c.Token = resp.Token
+	return resp.Token, nil
+}
+
+// UserInfo returns the logged in user.
+func (c *Client) UserInfo(ctx context.Context) (*models.UserInfo, error) {
+	var info models.UserInfo
+	if _, err := c.doJSON(ctx, http.MethodGet, "/user-info", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ResetPassword changes the password of the logged in user.
+func (c *Client) ResetPassword(ctx context.Context, currentPassword, newPassword string) error {
+	_, err := c.doJSON(ctx, http.MethodPut, "/reset-password", nil, models.ResetPassword{CurrentPassword: currentPassword, NewPassword: newPassword}, nil)
+	return err
+}
+
+// Users lists every user. Admin only.
+func (c *Client) Users(ctx context.Context) ([]models.UserInfo, error) {
+	var users []models.UserInfo
+	if _, err := c.doJSON(ctx, http.MethodGet, "/users", nil, nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CreateUser registers a new user. Admin only.
+func (c *Client) CreateUser(ctx context.Context, user models.SignUp) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/signup", nil, user, nil)
+	return err
+}
+
+// UpdateUser changes the fields of update that are set and returns the updated user. Users
+// may change their own display name and email, everything else is admin only.
+func (c *Client) UpdateUser(ctx context.Context, userID string, update models.UserUpdate) (*models.UserInfo, error) {
+	var user models.UserInfo
+	if _, err := c.doJSON(ctx, http.MethodPatch, "/users/"+url.PathEscape(userID), nil, update, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteUser removes the user with the given ID and archives the personal files. Admin only.
+func (c *Client) DeleteUser(ctx context.Context, userID string) error {
+	return c.DeleteUserWithFiles(ctx, userID, models.UserFilesArchive, "")
+}
+
+// DeleteUserWithFiles removes the user with the given ID after exporting the personal files,
+// which are then archived, transferred to the user transferTo or purged as files says.
+// Shared files pass to transferTo, or else to the admin. Admin only.
+func (c *Client) DeleteUserWithFiles(ctx context.Context, userID, files, transferTo string) error {
+	query := url.Values{"files": {files}}
+	if transferTo != "" {
+		query.Set("to", transferTo)
+	}
+	_, err := c.doJSON(ctx, http.MethodDelete, "/users/"+url.PathEscape(userID), query, nil, nil)
+	return err
+}
+
+// LoginHistory returns the latest login attempts of a user, newest first. Users may read
+// their own history, admins that of anyone.
+func (c *Client) LoginHistory(ctx context.Context, userID string, limit int) ([]models.LoginAttempt, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var attempts []models.LoginAttempt
+	if _, err := c.doJSON(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/logins", query, nil, &attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// UnlockUser ends the lockout of a user after failed logins. Admin only.
+func (c *Client) UnlockUser(ctx context.Context, userID string) error {
+	_, err := c.doJSON(ctx, http.MethodDelete, "/users/"+url.PathEscape(userID)+"/lockout", nil, nil, nil)
+	return err
+}
+
+// IssueResetToken creates a one-time token the user can set a new password with. Admin only.
+func (c *Client) IssueResetToken(ctx context.Context, userID string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	if _, err := c.doJSON(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/reset-token", nil, nil, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ResetPasswordWithToken sets a new password with a token issued by an admin. It needs no login.
+func (c *Client) ResetPasswordWithToken(ctx context.Context, token, newPassword string) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/reset-password/token", nil, models.TokenPasswordReset{Token: token, NewPassword: newPassword}, nil)
+	return err
+}
+
+// ForcePasswordChange makes a user choose a new password before doing anything else. Admin only.
+func (c *Client) ForcePasswordChange(ctx context.Context, userID string) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/force-password-change", nil, nil, nil)
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// defaultServer is used until a login names another server.
+const defaultServer = "http://127.0.0.1:3000"
+
+// config is stored as JSON in the user's config directory, readable only by them
+// because it holds the session token.
+type config struct {
+	Server   string `json:"server"`
+	Username string `json:"username,omitempty"`
+	Token    string `json:"token,omitempty"`
+	// CACert is a certificate file to trust, for servers with a self-signed certificate
+	CACert string `json:"ca_cert,omitempty"`
+}
+
+// configPath returns $CLOUDBOX_CONFIG, or cloudbox/config.json in the user config directory.
+func configPath() (string, error) {
+	if path := os.Getenv("CLOUDBOX_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cloudbox", "config.json"), nil
+}
+
+// loadConfig reads the stored config. CLOUDBOX_SERVER, CLOUDBOX_TOKEN and CLOUDBOX_CA_CERT override it,
+// which lets scripts run without logging in first.
+func loadConfig() (*config, error) {
+	cfg := &config{Server: defaultServer}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if server := os.Getenv("CLOUDBOX_SERVER"); server != "" {
+		cfg.Server = server
+	}
+	if token := os.Getenv("CLOUDBOX_TOKEN"); token != "" {
+		cfg.Token = token
+	}
+	if caCert := os.Getenv("CLOUDBOX_CA_CERT"); caCert != "" {
+		cfg.CACert = caCert
+	}
+
+	return cfg, nil
+}
+
+func (cfg *config) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,577 @@
+// Command cloudbox is a command line client for a CloudBoxIO server.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AumSahayata/cloudboxio/client"
+	"github.com/AumSahayata/cloudboxio/models"
+)
+
+const usage = `Usage: cloudbox <command> [flags] [args]
+
+Commands:
+  login   Log in to a server and store the session token
+  logout  Forget the stored session token
+  whoami  Show the logged in user
+  ls      List personal files, or shared files with -shared
+  put     Upload files
+  get     Download files by ID
+  rm      Delete files by ID
+  share   Move files into the shared space, or back with -undo
+  users   List users, or manage them with "users add", "users edit", "users rm" and "users reset" (admin only)
+
+Servers with a self-signed certificate need "login -ca-cert FILE" with their certificate.
+Passwords are read from the CLOUDBOX_PASSWORD environment variable or the first line of stdin.
+The session is stored in the file named by CLOUDBOX_CONFIG or in the user config directory.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli carries the streams and config of one invocation.
+type cli struct {
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+	cfg    *config
+	client *client.Client
+}
+
+// run executes a command and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to read config:", err)
+		return 1
+	}
+
+	c := &cli{stdin: bufio.NewReader(stdin), stdout: stdout, stderr: stderr, cfg: cfg}
+	c.client, err = newClient(cfg.Server, cfg.CACert)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to read CA certificate:", err)
+		return 1
+	}
+	c.client.Token = cfg.Token
+
+	commands := map[string]func([]string) error{
+		"login":  c.login,
+		"logout": c.logout,
+		"whoami": c.whoami,
+		"ls":     c.ls,
+		"put":    c.put,
+		"get":    c.get,
+		"rm":     c.rm,
+		"share":  c.share,
+		"users":  c.users,
+	}
+
+	switch args[0] {
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	if err := command(args[1:]); err != nil {
+		var ue usageError
+		if errors.As(err, &ue) || errors.Is(err, flag.ErrHelp) {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(stderr, err)
+			}
+			return 2
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+// newClient returns a client for server that also trusts the certificate in caCert, if given.
+func newClient(server, caCert string) (*client.Client, error) {
+	c := client.New(server)
+	if caCert != "" {
+		if err := c.TrustCertFile(caCert); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// usageError reports a command invoked with the wrong arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return "Usage: cloudbox " + string(e)
+}
+
+// flags returns a flag set that reports errors on the command's stderr.
+func (c *cli) flags(name string) *flag.FlagSet {
+	fset := flag.NewFlagSet(name, flag.ContinueOnError)
+	fset.SetOutput(c.stderr)
+	return fset
+}
+
+// password reads a password from CLOUDBOX_PASSWORD or the next line of stdin.
+func (c *cli) password(prompt string) (string, error) {
+	if password := os.Getenv("CLOUDBOX_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	fmt.Fprint(c.stderr, prompt)
+	line, err := c.stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.New("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *cli) requireLogin() error {
+	if c.client.Token == "" {
+		return errors.New("not logged in, run cloudbox login first")
+	}
+	return nil
+}
+
+func (c *cli) login(args []string) error {
+	fset := c.flags("login")
+	server := fset.String("server", c.cfg.Server, "server URL")
+	caCert := fset.String("ca-cert", c.cfg.CACert, "certificate to trust, for a server with a self-signed certificate")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		return usageError("login [-server URL] [-ca-cert FILE] <username>")
+	}
+
+	password, err := c.password("Password: ")
+	if err != nil {
+		return err
+	}
+
+	if *caCert != "" {
+		// Later commands may run from another directory
+		if abs, err := filepath.Abs(*caCert); err == nil {
+			*caCert = abs
+		}
+	}
+	c.client, err = newClient(*server, *caCert)
+	if err != nil {
+		return err
+	}
+	token, err := c.client.Login(context.Background(), fset.Arg(0), password)
+	if err != nil {
+		return err
+	}
+
+	c.cfg.Server, c.cfg.Username, c.cfg.Token, c.cfg.CACert = c.client.BaseURL, fset.Arg(0), token, *caCert
+	if err := c.cfg.save(); err != nil {
+		return fmt.Errorf("logged in but failed to store the session: %w", err)
+	}
+
+	fmt.Fprintf(c.stdout, "Logged in to %s as %s\n", c.cfg.Server, c.cfg.Username)
+	return nil
+}
+
+func (c *cli) logout(args []string) error {
+	c.cfg.Token, c.cfg.Username = "", ""
+	return c.cfg.save()
+}
+
+func (c *cli) whoami(args []string) error {
+	if err := c.requireLogin(); err != nil {
+		return err
+	}
+
+	info, err := c.client.UserInfo(context.Background())
+	if err != nil {
+		return err
+	}
+
+	role := "user"
+	if info.IsAdmin {
+		role = "admin"
+	}
+	fmt.Fprintf(c.stdout, "%s (%s) on %s\n", info.Username, role, c.cfg.Server)
+	return nil
+}
+
+func (c *cli) ls(args []string) error {
+	fset := c.flags("ls")
+	var opts client.ListOptions
+	fset.BoolVar(&opts.Shared, "shared", false, "list the shared space")
+	fset.StringVar(&opts.Sort, "sort", "", "sort by name, size or date")
+	fset.StringVar(&opts.Order, "order", "", "asc or desc")
+	tag := fset.String("tag", "", "only files with this tag")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() > 1 {
+		return usageError("ls [-shared] [-sort field] [-order asc|desc] [-tag tag] [keyword]")
+	}
+	if err := c.requireLogin(); err != nil {
+		return err
+	}
+
+	opts.Keyword = fset.Arg(0)
+	if *tag != "" {
+		opts.Tags = []string{*tag}
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSIZE\tUPLOADED\tBY\tNAME")
+
+	// Follow the cursor so every matching file is listed
+	for {
+		page, err := c.client.ListFiles(context.Background(), opts)
+		if err != nil {
+			return err
+		}
+		for _, f := range page.Files {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.FileID, formatSize(f.Size), f.UploadedAt, f.UploadedBy, f.Filename)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	return w.Flush()
+}
+
+func (c *cli) put(args []string) error {
+	fset := c.flags("put")
+	shared := fset.Bool("shared", false, "upload into the shared space")
+	quiet := fset.Bool("q", false, "do not report progress")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usageError("put [-shared] [-q] <file>...")
+	}
+	if err := c.requireLogin(); err != nil {
+		return err
+	}
+
+	for _, path := range fset.Args() {
+		opts := client.UploadOptions{Shared: *shared}
+		if !*quiet {
+			opts.Progress = c.progress(path)
+		}
+
+		if err := c.client.UploadFile(context.Background(), path, opts); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if !*quiet {
+			fmt.Fprintln(c.stderr)
+		}
+		fmt.Fprintln(c.stdout, "Uploaded", path)
+	}
+
+	return nil
+}
+
+func (c *cli) get(args []string) error {
+	fset := c.flags("get")
+	output := fset.String("o", ".", "file or directory to write to")
+	quiet := fset.Bool("q", false, "do not report progress")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usageError("get [-o path] [-q] <file-id>...")
+	}
+	if fset.NArg() > 1 {
+		if info, err := os.Stat(*output); err != nil || !info.IsDir() {
+			return errors.New("-o must be an existing directory when downloading several files")
+		}
+	}
+	if err := c.requireLogin(); err != nil {
+		return err
+	}
+
+	for _, id := range fset.Args() {
+		var progress client.ProgressFunc
+		if !*quiet {
+			progress = c.progress(id)
+		}
+
+		dest, err := c.client.DownloadFile(context.Background(), id, *output, progress)
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		if !*quiet {
+			fmt.Fprintln(c.stderr)
+		}
+		fmt.Fprintln(c.stdout, "Downloaded", dest)
+	}
+
+	return nil
+}
+
+func (c *cli) rm(args []string) error {
+	fset := c.flags("rm")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usageError("rm <file-id>...")
+	}
+	if err := c.requireLogin(); err != nil {
+		return err
+	}
+
+	results, err := c.client.DeleteFiles(context.Background(), fset.Args()...)
+	if err != nil {
+		return err
+	}
+	return c.reportBatch(results, "Deleted")
+}
+
+func (c *cli) share(args []string) error {
+	fset := c.flags("share")
+	undo := fset.Bool("undo", false, "move the files back to their owner's space")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usageError("share [-undo] <file-id>...")
+	}
+	if err := c.requireLogin(); err != nil {
+		return err
+	}
+
+	results, err := c.client.Share(context.Background(), !*undo, fset.Args()...)
+	if err != nil {
+		return err
+	}
+
+	verb := "Shared"
+	if *undo {
+		verb = "Unshared"
+	}
+	return c.reportBatch(results, verb)
+}
+
+func (c *cli) users(args []string) error {
+	if err := c.requireLogin(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if len(args) == 0 || args[0] == "ls" {
+		users, err := c.client.Users(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSERNAME\tADMIN\tDISABLED")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%t\n", u.ID, u.Username, u.IsAdmin, u.Disabled)
+		}
+		return w.Flush()
+	}
+
+	switch args[0] {
+	case "add":
+		fset := c.flags("users add")
+		admin := fset.Bool("admin", false, "give the user admin rights")
+		temporary := fset.Bool("temporary", false, "make the user choose a new password after the first login")
+		if err := fset.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fset.NArg() != 1 {
+			return usageError("users add [-admin] [-temporary] <username>")
+		}
+
+		password, err := c.password("Password for " + fset.Arg(0) + ": ")
+		if err != nil {
+			return err
+		}
+		user := models.SignUp{Username: fset.Arg(0), Password: password, IsAdmin: *admin, MustChangePassword: *temporary}
+		if err := c.client.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, "Created user", fset.Arg(0))
+
+	case "edit":
+		fset := c.flags("users edit")
+		username := fset.String("username", "", "rename the user")
+		name := fset.String("name", "", "set the display name, empty removes it")
+		email := fset.String("email", "", "set the email address, empty removes it")
+		admin := fset.Bool("admin", false, "give admin rights, or take them with -admin=false")
+		disabled := fset.Bool("disabled", false, "disable the account, or enable it with -disabled=false")
+		if err := fset.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fset.NArg() != 1 || fset.NFlag() == 0 {
+			return usageError("users edit [-username NAME] [-name NAME] [-email EMAIL] [-admin[=false]] [-disabled[=false]] <username|id>")
+		}
+
+		// Only the flags given are changed
+		var update models.UserUpdate
+		fset.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "username":
+				update.Username = username
+			case "name":
+				update.DisplayName = name
+			case "email":
+				update.Email = email
+			case "admin":
+				update.IsAdmin = admin
+			case "disabled":
+				update.Disabled = disabled
+			}
+		})
+
+		id, err := c.userID(ctx, fset.Arg(0))
+		if err != nil {
+			return err
+		}
+		user, err := c.client.UpdateUser(ctx, id, update)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, "Updated user", user.Username)
+
+	case "rm":
+		fset := c.flags("users rm")
+		files := fset.String("files", models.UserFilesArchive, "archive, transfer or purge the personal files, after a ZIP export on the server")
+		to := fset.String("to", "", "user receiving the files, required with -files transfer")
+		if err := fset.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fset.NArg() != 1 {
+			return usageError("users rm [-files archive|transfer|purge] [-to <username|id>] <username|id>")
+		}
+
+		id, err := c.userID(ctx, fset.Arg(0))
+		if err != nil {
+			return err
+		}
+		transferTo := ""
+		if *to != "" {
+			if transferTo, err = c.userID(ctx, *to); err != nil {
+				return err
+			}
+		}
+		if err := c.client.DeleteUserWithFiles(ctx, id, *files, transferTo); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, "Deleted user", fset.Arg(0))
+
+	case "reset":
+		fset := c.flags("users reset")
+		force := fset.Bool("force", false, "instead of a token, make the user choose a new password before continuing")
+		if err := fset.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fset.NArg() != 1 {
+			return usageError("users reset [-force] <username|id>")
+		}
+
+		id, err := c.userID(ctx, fset.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *force {
+			if err := c.client.ForcePasswordChange(ctx, id); err != nil {
+				return err
+			}
+			fmt.Fprintln(c.stdout, "User", fset.Arg(0), "has to choose a new password before continuing")
+			return nil
+		}
+
+		token, err := c.client.IssueResetToken(ctx, id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "Reset token for %s, valid once until %s:\n%s\n", fset.Arg(0), token.ExpiresAt, token.Token)
+
+	default:
+		return usageError("users [ls | add [-admin] [-temporary] <username> | edit [flags] <username|id> | rm [flags] <username|id> | reset [-force] <username|id>]")
+	}
+
+	return nil
+}
+
+// userID returns the ID of the user named by a username or an ID.
+func (c *cli) userID(ctx context.Context, user string) (string, error) {
+	users, err := c.client.Users(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, u := range users {
+		if u.Username == user {
+			return u.ID, nil
+		}
+	}
+	return user, nil
+}
+
+// reportBatch prints the outcome of a batch operation and fails if any file failed.
+func (c *cli) reportBatch(results []models.BatchResult, verb string) error {
+	failed := 0
+	for _, r := range results {
+		if r.OK {
+			fmt.Fprintln(c.stdout, verb, r.FileID)
+		} else {
+			failed++
+			fmt.Fprintf(c.stderr, "%s: %s\n", r.FileID, r.Error)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed", failed, len(results))
+	}
+	return nil
+}
+
+// progress returns a ProgressFunc that redraws a percentage line on stderr.
+func (c *cli) progress(name string) client.ProgressFunc {
+	return func(done, total int64) {
+		if total > 0 {
+			fmt.Fprintf(c.stderr, "\r%s %3d%% (%s)", name, done*100/total, formatSize(done))
+		} else {
+			fmt.Fprintf(c.stderr, "\r%s %s", name, formatSize(done))
+		}
+	}
+}
+
+// formatSize renders a byte count in binary units.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
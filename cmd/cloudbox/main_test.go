@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/client/clienttest"
+)
+
+// cloudbox runs the CLI and returns its exit code and output.
+func cloudbox(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI(t *testing.T) {
+	srv := clienttest.NewServer(t)
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("CLOUDBOX_CONFIG", configFile)
+	t.Setenv("CLOUDBOX_SERVER", "")
+	t.Setenv("CLOUDBOX_TOKEN", "")
+	t.Setenv("CLOUDBOX_PASSWORD", "")
+
+	if code, _, stderr := cloudbox(t, "", "ls"); code != 1 || !strings.Contains(stderr, "not logged in") {
+		t.Fatalf("expected ls to require a login, got %d %q", code, stderr)
+	}
+
+	if code, _, _ := cloudbox(t, "wrong\n", "login", "-server", srv.URL, clienttest.AdminUsername); code != 1 {
+		t.Fatalf("expected login with a wrong password to fail, got %d", code)
+	}
+
+	code, stdout, stderr := cloudbox(t, clienttest.AdminPassword+"\n", "login", "-server", srv.URL, clienttest.AdminUsername)
+	if code != 0 || !strings.Contains(stdout, "Logged in") {
+		t.Fatalf("login failed: %d %q %q", code, stdout, stderr)
+	}
+
+	// The session is stored privately and reused by later commands
+	info, err := os.Stat(configFile)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private config file, got %v %v", info, err)
+	}
+	if code, stdout, _ := cloudbox(t, "", "whoami"); code != 0 || !strings.Contains(stdout, "admin (admin)") {
+		t.Fatalf("unexpected whoami: %d %q", code, stdout)
+	}
+
+	local := filepath.Join(t.TempDir(), "hello.txt")
+	os.WriteFile(local, []byte("hello from the cli"), 0644)
+	if code, stdout, stderr := cloudbox(t, "", "put", local); code != 0 || !strings.Contains(stdout, "Uploaded") || !strings.Contains(stderr, "100%") {
+		t.Fatalf("put failed: %d %q %q", code, stdout, stderr)
+	}
+
+	code, stdout, _ = cloudbox(t, "", "ls")
+	match := regexp.MustCompile(`(?m)^(\d+)\s+18 B\s.*hello\.txt$`).FindStringSubmatch(stdout)
+	if code != 0 || match == nil {
+		t.Fatalf("uploaded file not listed: %d %q", code, stdout)
+	}
+	id := match[1]
+
+	outDir := t.TempDir()
+	if code, _, stderr := cloudbox(t, "", "get", "-q", "-o", outDir, id); code != 0 {
+		t.Fatalf("get failed: %d %q", code, stderr)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outDir, "hello.txt")); string(data) != "hello from the cli" {
+		t.Fatalf("unexpected downloaded contents %q", data)
+	}
+
+	if code, stdout, _ := cloudbox(t, "", "share", id); code != 0 || !strings.Contains(stdout, "Shared "+id) {
+		t.Fatalf("share failed: %d %q", code, stdout)
+	}
+	if _, stdout, _ := cloudbox(t, "", "ls", "-shared"); !strings.Contains(stdout, "hello.txt") {
+		t.Fatalf("shared file not listed: %q", stdout)
+	}
+
+	if code, _, stderr := cloudbox(t, "", "rm", id, "999"); code != 1 || !strings.Contains(stderr, "1 of 2") {
+		t.Fatalf("expected a partial failure from rm, got %d %q", code, stderr)
+	}
+	if _, stdout, _ := cloudbox(t, "", "ls", "-shared"); strings.Contains(stdout, "hello.txt") {
+		t.Fatalf("deleted file still listed: %q", stdout)
+	}
+
+	// User administration
+	if code, stdout, stderr := cloudbox(t, "carol-password\n", "users", "add", "carol"); code != 0 || !strings.Contains(stdout, "Created user carol") {
+		t.Fatalf("users add failed: %d %q %q", code, stdout, stderr)
+	}
+	if _, stdout, _ := cloudbox(t, "", "users"); !strings.Contains(stdout, "carol") {
+		t.Fatalf("new user not listed: %q", stdout)
+	}
+	if code, _, stderr := cloudbox(t, "", "users", "rm", "carol"); code != 0 {
+		t.Fatalf("users rm failed: %d %q", code, stderr)
+	}
+	if _, stdout, _ := cloudbox(t, "", "users"); strings.Contains(stdout, "carol") {
+		t.Fatalf("deleted user still listed: %q", stdout)
+	}
+
+	if code, _, _ := cloudbox(t, "", "logout"); code != 0 {
+		t.Fatal("logout failed")
+	}
+	if code, _, _ := cloudbox(t, "", "whoami"); code != 1 {
+		t.Fatal("expected whoami to fail after logout")
+	}
+
+	if code, _, _ := cloudbox(t, "", "frobnicate"); code != 2 {
+		t.Fatal("expected unknown commands to exit with 2")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/db"
+	"github.com/AumSahayata/cloudboxio/internal"
+)
+
+const commandUsage = `Usage: cloudboxio [command] [flags]
+
+Without a command the server is started, run cloudboxio -h for its flags.
+Every command accepts -config <file> to read the server configuration from a YAML file.
+
+Commands:
+  backup    Write a backup archive of the database and all files
+  restore   Verify a backup archive and rebuild the server from it
+  import    Register the files of a local directory tree into a user's space or the shared space
+  fsck      Check the database against the files on disk and optionally repair it
+  version   Print the version
+`
+
+// runCommand executes a maintenance subcommand and returns the process exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	case "fsck":
+		return fsckCommand(args[1:])
+	case "version", "-v", "--version":
+		fmt.Println("CloudBoxIO", Version)
+		return 0
+	case "help", "-h", "--help":
+		fmt.Print(commandUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], commandUsage)
+		return 2
+	}
+}
+
+// commandConfig loads the server configuration for a command, reporting problems on stderr.
+func commandConfig(path string) (*internal.Config, bool) {
+	var args []string
+	if path != "" {
+		args = []string{"-config", path}
+	}
+
+	internal.LoadDotEnv()
+	cfg, err := internal.LoadConfig(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+	return cfg, true
+}
+
+func backupCommand(args []string) int {
+	fset := flag.NewFlagSet("backup", flag.ContinueOnError)
+	configPath := fset.String("config", "", "YAML configuration file")
+	output := fset.String("o", "", "archive path (default cloudboxio-backup-<timestamp>.tar.gz)")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, ok := commandConfig(*configPath)
+	if !ok {
+		return 2
+	}
+
+	if *output == "" {
+		*output = fmt.Sprintf("cloudboxio-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	}
+
+	database, err := db.InitDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open database:", err)
+		return 1
+	}
+	defer db.CloseDB(database)
+
+	file, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create archive:", err)
+		return 1
+	}
+
+	if err := internal.CreateBackup(database, cfg.FilesDir, file); err != nil {
+		file.Close()
+		os.Remove(*output)
+		fmt.Fprintln(os.Stderr, "Backup failed:", err)
+		return 1
+	}
+
+	if err := file.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write archive:", err)
+		return 1
+	}
+
+	fmt.Println("Backup written to", *output)
+	return 0
+}
+
+func restoreCommand(args []string) int {
+	fset := flag.NewFlagSet("restore", flag.ContinueOnError)
+	configPath := fset.String("config", "", "YAML configuration file")
+	force := fset.Bool("force", false, "overwrite an existing database and files")
+	verifyOnly := fset.Bool("verify", false, "only verify the archive, do not restore it")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+
+	if fset.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: cloudboxio restore [-force] [-verify] <archive>")
+		return 2
+	}
+
+	cfg, ok := commandConfig(*configPath)
+	if !ok {
+		return 2
+	}
+
+	file, err := os.Open(fset.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open archive:", err)
+		return 1
+	}
+	defer file.Close()
+
+	if *verifyOnly {
+		manifest, err := internal.VerifyBackup(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Verification failed:", err)
+			return 1
+		}
+		fmt.Printf("Archive OK: database and %d files from %s\n", len(manifest.Files), manifest.CreatedAt.Format(time.RFC3339))
+		return 0
+	}
+
+	// Refuse to clobber an existing installation by accident
+	if _, err := os.Stat(db.DBPath); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s already exists, stop the server and rerun with -force to overwrite it\n", db.DBPath)
+		return 1
+	}
+
+	manifest, err := internal.RestoreBackup(file, db.DBPath, cfg.FilesDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Restore failed:", err)
+		return 1
+	}
+
+	fmt.Printf("Restored database and %d files from %s\n", len(manifest.Files), manifest.CreatedAt.Format(time.RFC3339))
+	return 0
+}
+
+func fsckCommand(args []string) int {
+	fset := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	configPath := fset.String("config", "", "YAML configuration file")
+	var opts internal.FsckOptions
+	fset.StringVar(&opts.Orphans, "orphans", "", `repair orphan files: "import" or "quarantine"`)
+	fset.StringVar(&opts.Dangling, "dangling", "", `repair rows without a file: "remove"`)
+	fset.StringVar(&opts.Mismatch, "mismatch", "", `repair size mismatches: "update" or "quarantine"`)
+	output := fset.String("o", "", "write the JSON report to this file instead of stdout")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := opts.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	cfg, ok := commandConfig(*configPath)
+	if !ok {
+		return 2
+	}
+
+	database, err := db.InitDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open database:", err)
+		return 1
+	}
+	defer db.CloseDB(database)
+
+	report, err := internal.RunFsck(database, cfg.FilesDir, cfg.SharedDir, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fsck failed:", err)
+		return 1
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to encode report:", err)
+		return 1
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, data, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to write report:", err)
+			return 1
+		}
+	} else {
+		fmt.Println(string(data))
+	}
+
+	// Non-zero exit when unrepaired issues remain so the command can be scripted
+	for _, issue := range report.Issues {
+		if issue.Action == "" {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func importCommand(args []string) int {
+	fset := flag.NewFlagSet("import", flag.ContinueOnError)
+	configPath := fset.String("config", "", "YAML configuration file")
+	var opts internal.ImportOptions
+	fset.StringVar(&opts.Username, "user", "", "owner of the imported files (required)")
+	fset.BoolVar(&opts.Shared, "shared", false, "import into the shared space")
+	fset.BoolVar(&opts.Move, "move", false, "move files instead of copying them")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+
+	if fset.NArg() != 1 || opts.Username == "" {
+		fmt.Fprintln(os.Stderr, "Usage: cloudboxio import -user <username> [-shared] [-move] <dir>")
+		return 2
+	}
+	opts.Source = fset.Arg(0)
+
+	cfg, ok := commandConfig(*configPath)
+	if !ok {
+		return 2
+	}
+	internal.InitLogger(cfg.Log)
+
+	database, err := db.InitDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open database:", err)
+		return 1
+	}
+	defer db.CloseDB(database)
+
+	result, err := internal.ImportTree(database, cfg.FilesDir, cfg.SharedDir, opts)
+	if result != nil {
+		fmt.Printf("Imported %d, skipped %d (already imported), failed %d\n", result.Imported, result.Skipped, result.Failed)
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", e.Path, e.Error)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Import failed:", err)
+		return 1
+	}
+
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -13,9 +13,13 @@ import (
 	_ "modernc.org/sqlite"
 )
 
+// DBPath is the location of the SQLite database file.
+const DBPath = "data.db"
+
 // InitDb initializes the database.
 func InitDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite", "file:data.db?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	// modernc.org/sqlite takes pragmas as _pragma=name(value), other parameters are ignored
+	db, err := sql.Open("sqlite", "file:"+DBPath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
 	if err != nil {
 		log.Fatalln("Failed to open database:", err)
 	}
@@ -68,6 +72,10 @@ func InitDB() (*sql.DB, error) {
 		log.Println("Failed to setup initial settings:", err)
 	}
 
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+
 	checkAndCreateAdmin(db)
 
 	return db, nil
@@ -0,0 +1,257 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations extend the base schema created by InitDB.
+// Every statement must be safe to run on each start.
+var migrations = []string{
+	// import_log remembers which source files were already imported so an interrupted import can resume.
+	`CREATE TABLE IF NOT EXISTS import_log (
+		source TEXT NOT NULL,
+		rel_path TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		is_shared BOOLEAN NOT NULL,
+		file_id INTEGER,
+		imported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (source, rel_path, user_id, is_shared)
+	);`,
+
+	// file_index is the full-text index of file names and contents, keyed by metadata id.
+	`CREATE VIRTUAL TABLE IF NOT EXISTS file_index USING fts5(
+		filename,
+		content,
+		tokenize = 'porter unicode61'
+	);`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_index_delete AFTER DELETE ON metadata BEGIN
+		DELETE FROM file_index WHERE rowid = OLD.id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_index_rename AFTER UPDATE OF filename ON metadata BEGIN
+		UPDATE file_index SET filename = NEW.filename WHERE rowid = NEW.id;
+	END;`,
+
+	// file_tags and file_properties hold user supplied labels and key/value pairs.
+	`CREATE TABLE IF NOT EXISTS file_tags (
+		file_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (file_id, tag)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_file_tags_tag ON file_tags (tag);`,
+	`CREATE TABLE IF NOT EXISTS file_properties (
+		file_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (file_id, key)
+	);`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_meta_delete AFTER DELETE ON metadata BEGIN
+		DELETE FROM file_tags WHERE file_id = OLD.id;
+		DELETE FROM file_properties WHERE file_id = OLD.id;
+	END;`,
+
+	// favorites are the files a user starred, recent_files the last time each file was used by a user.
+	`CREATE TABLE IF NOT EXISTS favorites (
+		user_id TEXT NOT NULL,
+		file_id INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, file_id)
+	);`,
+	`CREATE TABLE IF NOT EXISTS recent_files (
+		user_id TEXT NOT NULL,
+		file_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		accessed_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, file_id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_recent_files_user ON recent_files (user_id, accessed_at);`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_quick_access_delete AFTER DELETE ON metadata BEGIN
+		DELETE FROM favorites WHERE file_id = OLD.id;
+		DELETE FROM recent_files WHERE file_id = OLD.id;
+	END;`,
+
+	// webhooks are admin registered receivers of events, webhook_deliveries the log of every attempt to reach them.
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		include_personal BOOLEAN NOT NULL DEFAULT FALSE,
+		created_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`,
+	`CREATE TRIGGER IF NOT EXISTS webhooks_delete AFTER DELETE ON webhooks BEGIN
+		DELETE FROM webhook_deliveries WHERE webhook_id = OLD.id;
+	END;`,
+
+	// changes is the journal read by sync clients. The triggers record every metadata mutation
+	// with a monotonic sequence number, whichever code path made it.
+	`CREATE TABLE IF NOT EXISTS changes (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		user_id TEXT,
+		filename TEXT,
+		is_shared BOOLEAN,
+		was_shared BOOLEAN,
+		size INTEGER,
+		sha256 TEXT,
+		modified_at TEXT,
+		changed_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+	);`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_changes_insert AFTER INSERT ON metadata BEGIN
+		INSERT INTO changes (file_id, action, user_id, filename, is_shared, was_shared, size, sha256, modified_at)
+		VALUES (NEW.id, 'create', NEW.user_id, NEW.filename, NEW.is_shared, NEW.is_shared, NEW.size, NEW.sha256, COALESCE(NULLIF(NEW.modified_at, ''), NEW.uploaded_at));
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_changes_update AFTER UPDATE ON metadata
+	WHEN OLD.user_id IS NOT NEW.user_id OR OLD.filename IS NOT NEW.filename OR OLD.path IS NOT NEW.path
+		OR OLD.is_shared IS NOT NEW.is_shared OR OLD.size IS NOT NEW.size OR OLD.sha256 IS NOT NEW.sha256
+		OR OLD.modified_at IS NOT NEW.modified_at OR OLD.description IS NOT NEW.description
+	BEGIN
+		INSERT INTO changes (file_id, action, user_id, filename, is_shared, was_shared, size, sha256, modified_at)
+		VALUES (NEW.id, 'update', NEW.user_id, NEW.filename, NEW.is_shared, OLD.is_shared, NEW.size, NEW.sha256, COALESCE(NULLIF(NEW.modified_at, ''), NEW.uploaded_at));
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_changes_delete AFTER DELETE ON metadata BEGIN
+		INSERT INTO changes (file_id, action, user_id, filename, is_shared, was_shared, size, sha256, modified_at)
+		VALUES (OLD.id, 'delete', OLD.user_id, OLD.filename, OLD.is_shared, OLD.is_shared, OLD.size, OLD.sha256, COALESCE(NULLIF(OLD.modified_at, ''), OLD.uploaded_at));
+	END;`,
+
+	// rate_limits counts requests per client or user in fixed windows, so limits survive a restart.
+	`CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		hits INTEGER NOT NULL,
+		reset_at INTEGER NOT NULL
+	);`,
+
+	// login_failures counts failed logins per account and per client IP, login_history keeps
+	// every login attempt of a user for the account's owner and the admins. Foreign keys are
+	// not enforced, deleting a user removes its rows here and in password_reset_tokens.
+	`CREATE TABLE IF NOT EXISTS login_failures (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_failure INTEGER NOT NULL,
+		blocked_until INTEGER NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS login_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		result TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS login_history_user ON login_history (user_id, id);`,
+
+	// password_reset_tokens are the one-time tokens admins issue for users who forgot their
+	// password. Only their SHA-256 digest is stored.
+	`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+}
+
+// columnMigrations add columns to tables that already exist in deployed databases.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	// mime_type is the content type sniffed from the file when it was stored.
+	{"metadata", "mime_type", "TEXT NOT NULL DEFAULT ''"},
+	// description is a free-form note about the file.
+	{"metadata", "description", "TEXT NOT NULL DEFAULT ''"},
+	// sha256 is the hex digest of the file contents, used by sync clients to compare files.
+	{"metadata", "sha256", "TEXT NOT NULL DEFAULT ''"},
+	// modified_at is set when the contents change after upload, empty means uploaded_at.
+	{"metadata", "modified_at", "TEXT NOT NULL DEFAULT ''"},
+	// must_change_password keeps a user from anything but changing the password after login.
+	{"users", "must_change_password", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	// disabled keeps a user from logging in and from using tokens issued before.
+	{"users", "disabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	// display_name and email are optional profile details.
+	{"users", "display_name", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "email", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// backfills populate new tables from existing rows. They run after the column migrations
+// and, like migrations, must be safe to run on each start.
+var backfills = []string{
+	// Files stored before the change journal existed start it off as creates
+	`INSERT INTO changes (file_id, action, user_id, filename, is_shared, was_shared, size, sha256, modified_at)
+	SELECT id, 'create', user_id, filename, is_shared, is_shared, size, sha256, COALESCE(NULLIF(modified_at, ''), uploaded_at)
+	FROM metadata WHERE NOT EXISTS (SELECT 1 FROM changes) ORDER BY id;`,
+}
+
+// Migrate applies the schema additions on top of the base tables.
+func Migrate(db *sql.DB) error {
+	for _, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+
+	for _, m := range columnMigrations {
+		exists, err := columnExists(db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	for _, stmt := range backfills {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("backfill failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// columnExists reports whether table already has column.
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
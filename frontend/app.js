@@ -24,6 +24,84 @@ function formatDate(timestamp) {
     return new Date(timestamp).toLocaleString();
 }
 
+// Extensions the server can render thumbnails and previews for
+const THUMBNAIL_EXTENSIONS = ['jpg', 'jpeg', 'png', 'gif', 'webp'];
+const PREVIEW_EXTENSIONS = ['txt', 'md', 'csv', 'log', 'json', 'yaml', 'yml', 'xml', 'html', 'css', 'js', 'go', 'py', 'sh', 'sql'];
+
+// Helper function to get the lowercase extension of a filename
+function fileExtension(filename) {
+    const dot = (filename || '').lastIndexOf('.');
+    return dot < 0 ? '' : filename.substring(dot + 1).toLowerCase();
+}
+
+// Load a thumbnail with the auth header, retrying while the server is still generating it
+async function loadThumbnail(img, fileId, attempt = 0) {
+    try {
+        const response = await fetch(`${API_URL}/file/${fileId}/thumbnail?size=64`, {
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            },
+        });
+        if (response.status === 202 && attempt < 10) {
+            setTimeout(() => loadThumbnail(img, fileId, attempt + 1), 1000);
+            return;
+        }
+        if (!response.ok) {
+            return;
+        }
+        const blob = await response.blob();
+        img.src = window.URL.createObjectURL(blob);
+        img.onload = () => window.URL.revokeObjectURL(img.src);
+        img.classList.remove('d-none');
+    } catch (error) {
+        console.error('Failed to load thumbnail:', error);
+    }
+}
+
+// Toggle the first lines of a text file below its list item
+async function togglePreview(fileId, button) {
+    const item = button.closest('.list-group-item');
+    const existing = item.querySelector('.file-preview');
+    if (existing) {
+        existing.remove();
+        return;
+    }
+
+    try {
+        const response = await fetch(`${API_URL}/file/${fileId}/preview?lines=20`, {
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            },
+        });
+        handleApiResponse(response);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || 'Preview failed');
+        }
+
+        const pre = document.createElement('pre');
+        pre.className = 'file-preview';
+        pre.textContent = data.lines.join('\n') + (data.truncated ? '\n…' : '');
+        item.appendChild(pre);
+    } catch (error) {
+        alert(`Error loading preview: ${error.message || error}`);
+    }
+}
+
+// Helper function to escape text before inserting it as HTML
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text || '';
+    return div.innerHTML;
+}
+
+// Helper function to render a search snippet, keeping only the <mark> highlights
+function formatSnippet(snippet) {
+    return escapeHtml(snippet)
+        .replace(/&lt;mark&gt;/g, '<mark>')
+        .replace(/&lt;\/mark&gt;/g, '</mark>');
+}
+
 // Display files in the list
 function displayFiles(files, container, sectionTitle) {
     if (!container) return;
@@ -58,8 +136,13 @@ function createFileListItem(file) {
         return item;
     }
     
+    const ext = fileExtension(filename);
+    const hasThumbnail = THUMBNAIL_EXTENSIONS.includes(ext);
+    const hasPreview = PREVIEW_EXTENSIONS.includes(ext);
+
     item.innerHTML = `
         <div class="d-flex">
+            ${hasThumbnail ? '<img class="file-thumbnail d-none" alt="">' : ''}
             <div class="file-name">
                 <strong data-bs-toggle="tooltip" data-bs-placement="top" title="${filename}">
                     <span class="desktop-filename">${filename}</span>
@@ -67,11 +150,24 @@ function createFileListItem(file) {
                 </strong>
                 <small class="text-muted d-block">
                     ${formatFileSize(file.size)} • ${formatDate(file.uploaded_at)}
+                    ${file.mime_type ? ` • ${escapeHtml(file.mime_type)}` : ''}
                     ${isPublic ? ' • Public' : ''}
-                    ${file.uploaded_by ? ` • Uploaded by: ${file.uploaded_by}` : ''}
+                    ${file.uploaded_by ? ` • Uploaded by: ${escapeHtml(file.uploaded_by)}` : ''}
                 </small>
+                ${file.description ? `<small class="d-block text-muted">${escapeHtml(file.description)}</small>` : ''}
+                ${(file.tags || []).map(tag => `<span class="badge bg-secondary file-tag" data-tag="${escapeHtml(tag).replace(/"/g, '&quot;')}" onclick="filterByTag(this.dataset.tag)">${escapeHtml(tag)}</span>`).join(' ')}
+                ${file.snippet ? `<small class="d-block search-snippet">${formatSnippet(file.snippet)}</small>` : ''}
             </div>
             <div class="btn-group">
+                <button class="btn btn-sm btn-outline-warning" title="${file.starred ? 'Unstar' : 'Star'}" onclick="toggleFavorite('${fileId}', ${!file.starred})">
+                    <i class="bi ${file.starred ? 'bi-star-fill' : 'bi-star'}"></i>
+                </button>
+                ${hasPreview ? `<button class="btn btn-sm btn-outline-secondary" onclick="togglePreview('${fileId}', this)">
+                    <i class="bi bi-eye"></i> Preview
+                </button>` : ''}
+                <button class="btn btn-sm btn-outline-secondary" onclick="editTags('${fileId}')">
+                    <i class="bi bi-tags"></i> Tags
+                </button>
                 <button class="btn btn-sm btn-primary" onclick="downloadFile('${fileId}', '${filename.replace(/'/g, "\\'")}')">
                     <i class="bi bi-download"></i> Download
                 </button>
@@ -98,6 +194,10 @@ function createFileListItem(file) {
     // Initialize tooltips for this item
     const tooltipTriggerList = item.querySelectorAll('[data-bs-toggle="tooltip"]');
     [...tooltipTriggerList].map(tooltipTriggerEl => new bootstrap.Tooltip(tooltipTriggerEl));
+
+    if (hasThumbnail) {
+        loadThumbnail(item.querySelector('.file-thumbnail'), fileId);
+    }
     
     return item;
 }
@@ -191,39 +291,73 @@ function getAuthTokenOrRedirect() {
     return token;
 }
 
+// Number of files requested per page
+const PAGE_SIZE = 50;
+
+// Fetch one page of files into a list, adding a "Load more" button while more pages exist
+async function loadFilePage(container, params, cursor = '') {
+    const query = new URLSearchParams(params);
+    query.set('limit', PAGE_SIZE);
+    if (cursor) query.set('cursor', cursor);
+
+    const response = await fetch(`${API_URL}/files?${query}`, {
+        headers: {
+            'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+        },
+    });
+    handleApiResponse(response);
+    const files = await response.json();
+    if (!response.ok) {
+        throw new Error(files.error || 'Failed to fetch files');
+    }
+
+    // Drop the previous "Load more" button before appending the next page
+    const oldButton = container.querySelector('.load-more-item');
+    if (oldButton) oldButton.remove();
+
+    if (!cursor) {
+        displayFiles(files, container);
+    } else {
+        files.forEach(file => container.appendChild(createFileListItem(file)));
+    }
+
+    const nextCursor = response.headers.get('X-Next-Cursor');
+    if (nextCursor) {
+        const total = response.headers.get('X-Total-Count');
+        const loadMore = document.createElement('button');
+        loadMore.type = 'button';
+        loadMore.className = 'list-group-item list-group-item-action text-center text-primary load-more-item';
+        loadMore.textContent = total ? `Load more (${container.querySelectorAll('.file-name').length} of ${total})` : 'Load more';
+        loadMore.addEventListener('click', async () => {
+            loadMore.disabled = true;
+            try {
+                await loadFilePage(container, params, nextCursor);
+            } catch (error) {
+                alert(error.message || 'Error loading files');
+                loadMore.disabled = false;
+            }
+        });
+        container.appendChild(loadMore);
+    }
+}
+
 // Global loadFiles function
 async function loadFiles() {
     showLoading('Loading files...');
     try {
         // Load my files
-        const myFilesResponse = await fetch(`${API_URL}/files`, {
-            headers: {
-                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
-            },
-        });
-        const myFilesData = await myFilesResponse.json();
-        if (!myFilesResponse.ok) {
-            throw new Error(myFilesData.error || 'Failed to fetch my files');
-        }
         const myFilesList = document.getElementById('myFilesList');
         if (myFilesList) {
-            displayFiles(myFilesData, myFilesList, 'My Files');
+            await loadFilePage(myFilesList, { sort: 'date', order: 'desc' });
         }
 
         // Load shared files
-        const sharedFilesResponse = await fetch(`${API_URL}/files?shared=true`, {
-            headers: {
-                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
-            },
-        });
-        const sharedFilesData = await sharedFilesResponse.json();
-        if (!sharedFilesResponse.ok) {
-            throw new Error(sharedFilesData.error || 'Failed to fetch shared files');
-        }
         const sharedFilesList = document.getElementById('sharedFilesList');
         if (sharedFilesList) {
-            displayFiles(sharedFilesData, sharedFilesList, 'Public Files');
+            await loadFilePage(sharedFilesList, { shared: 'true', sort: 'date', order: 'desc' });
         }
+
+        await loadQuickAccess();
     } catch (error) {
         console.error('Error loading files:', error);
         alert(error.message || 'Error loading files');
@@ -232,16 +366,108 @@ async function loadFiles() {
     }
 }
 
+// Show only the files carrying a tag in both lists
+async function filterByTag(tag) {
+    showLoading(`Loading files tagged ${tag}...`);
+    try {
+        await loadFilePage(document.getElementById('myFilesList'), { tag, sort: 'date', order: 'desc' });
+        await loadFilePage(document.getElementById('sharedFilesList'), { shared: 'true', tag, sort: 'date', order: 'desc' });
+    } catch (error) {
+        alert(error.message || 'Error loading files');
+    } finally {
+        hideLoading();
+    }
+}
+
+// Replace the tags of a file with a comma separated list
+async function editTags(fileId) {
+    try {
+        const headers = {
+            'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            'Content-Type': 'application/json',
+        };
+
+        const current = await fetch(`${API_URL}/file/${fileId}/meta`, { headers });
+        handleApiResponse(current);
+        const meta = await current.json();
+        if (!current.ok) {
+            throw new Error(meta.error || 'Failed to load tags');
+        }
+
+        const input = prompt('Tags (comma separated):', meta.tags.join(', '));
+        if (input === null) return;
+
+        const response = await fetch(`${API_URL}/file/${fileId}/meta`, {
+            method: 'PATCH',
+            headers,
+            body: JSON.stringify({ tags: input.split(',') }),
+        });
+        handleApiResponse(response);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || 'Failed to save tags');
+        }
+        await loadFiles();
+    } catch (error) {
+        alert(`Error updating tags: ${error.message || error}`);
+    }
+}
+
+// Load the favorites and recent files quick access lists
+async function loadQuickAccess() {
+    const headers = {
+        'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+    };
+
+    const [favorites, recent] = await Promise.all(['favorites', 'recent'].map(async endpoint => {
+        const response = await fetch(`${API_URL}/${endpoint}`, { headers });
+        handleApiResponse(response);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || `Failed to fetch ${endpoint}`);
+        }
+        return data;
+    }));
+
+    displayFiles(favorites, document.getElementById('favoritesList'));
+    displayFiles(recent, document.getElementById('recentList'));
+}
+
+// Star or unstar a file and refresh the lists showing it
+async function toggleFavorite(fileId, starred) {
+    try {
+        const response = await fetch(`${API_URL}/file/${fileId}/favorite`, {
+            method: starred ? 'PUT' : 'DELETE',
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            },
+        });
+        handleApiResponse(response);
+        if (!response.ok) {
+            const data = await response.json();
+            throw new Error(data.error || 'Failed to update favorites');
+        }
+        await loadFiles();
+    } catch (error) {
+        alert(`Error updating favorites: ${error.message || error}`);
+    }
+}
+
 // Global logout function
 function logout() {
     // Clear token
     localStorage.removeItem('token');
+    disconnectEvents();
     
     // Clear the file lists
     const myFilesList = document.getElementById('myFilesList');
     const sharedFilesList = document.getElementById('sharedFilesList');
     if (myFilesList) myFilesList.innerHTML = '';
     if (sharedFilesList) sharedFilesList.innerHTML = '';
+    ['favoritesList', 'recentList'].forEach(id => {
+        const list = document.getElementById(id);
+        if (list) list.innerHTML = '';
+    });
     
     // Reset all forms
     const forms = document.querySelectorAll('form');
@@ -390,6 +616,12 @@ async function fetchUserDetails() {
         }
         const userData = await response.json();
         displayUserDetails(userData);
+
+        // An admin may require a new password while the user is logged in
+        if (userData.must_change_password) {
+            new bootstrap.Modal(document.getElementById('resetPasswordModal')).show();
+            alert('Please choose a new password before continuing');
+        }
     } catch (error) {
         console.error('Error fetching user details:', error);
         alert('Failed to load user details');
@@ -412,6 +644,7 @@ function displayUserDetails(userData) {
             // Show users panel nav item
             const showUsersPanelNavItem = document.getElementById('showUsersPanelNavItem');
             if (showUsersPanelNavItem) showUsersPanelNavItem.style.display = 'block';
+            document.getElementById('settingsNavItem').style.display = 'block';
         } else {
             // Hide admin-only options
             document.getElementById('createUserNavItem').style.display = 'none';
@@ -419,6 +652,7 @@ function displayUserDetails(userData) {
             // Hide users panel nav item
             const showUsersPanelNavItem = document.getElementById('showUsersPanelNavItem');
             if (showUsersPanelNavItem) showUsersPanelNavItem.style.display = 'none';
+            document.getElementById('settingsNavItem').style.display = 'none';
         }
     }
 }
@@ -438,6 +672,39 @@ function showAuthenticatedUI() {
     
     // Load files
     loadFiles();
+
+    // Keep the lists current as files change
+    connectEvents();
+}
+
+// Live updates from the server's event stream
+let eventSource = null;
+let refreshTimer = null;
+
+function connectEvents() {
+    const token = localStorage.getItem('token');
+    if (!token || eventSource) return;
+
+    eventSource = new EventSource(`${API_URL}/events?token=${encodeURIComponent(token)}`);
+
+    // Several changes often arrive together, refresh once they settle
+    const scheduleRefresh = () => {
+        clearTimeout(refreshTimer);
+        refreshTimer = setTimeout(loadFiles, 500);
+    };
+    ['file.created', 'file.deleted', 'file.renamed', 'file.shared', 'file.unshared', 'file.updated']
+        .forEach(type => eventSource.addEventListener(type, scheduleRefresh));
+    ['user.created', 'user.deleted', 'user.updated'].forEach(type => eventSource.addEventListener(type, () => {
+        if (document.getElementById('usersModal')?.classList.contains('show')) fetchAllUsers();
+    }));
+}
+
+function disconnectEvents() {
+    clearTimeout(refreshTimer);
+    if (eventSource) {
+        eventSource.close();
+        eventSource = null;
+    }
 }
 
 // Show unauthenticated UI
@@ -460,9 +727,9 @@ function showLoginModal() {
     }
 }
 
-// Update handleApiResponse to show login modal on 498
+// Update handleApiResponse to show login modal on 401
 function handleApiResponse(response) {
-    if (response.status === 498) {
+    if (response.status === 401) {
         // Token missing or expired
         localStorage.removeItem('token');
         showUnauthenticatedUI();
@@ -481,16 +748,45 @@ function renderUsersPanel(users) {
         const userItem = document.createElement('div');
         userItem.className = 'list-group-item d-flex justify-content-between align-items-center';
         userItem.innerHTML = `
-            <span><strong>${user.username}</strong> ${user.is_admin ? '<span class="badge bg-warning">Admin</span>' : ''}</span>
-            <button class="btn btn-sm btn-danger delete-user-btn" data-user-id="${user.id}"><i class="bi bi-trash"></i> Delete</button>
+            <span>
+                <strong>${escapeHtml(user.username)}</strong> ${user.is_admin ? '<span class="badge bg-warning">Admin</span>' : ''}
+                ${user.disabled ? '<span class="badge bg-secondary">Disabled</span>' : ''}
+                ${user.display_name || user.email ? `<small class="d-block text-muted">${escapeHtml([user.display_name, user.email].filter(Boolean).join(' • '))}</small>` : ''}
+            </span>
+            <span>
+                <button class="btn btn-sm btn-outline-secondary edit-user-btn" data-user-id="${user.id}" title="Rename, change the role or disable"><i class="bi bi-pencil"></i> Edit</button>
+                <button class="btn btn-sm btn-outline-secondary reset-token-btn" data-user-id="${user.id}" title="Issue a one-time password reset token"><i class="bi bi-key"></i> Reset token</button>
+                <button class="btn btn-sm btn-outline-secondary force-change-btn" data-user-id="${user.id}" title="Require a new password before continuing"><i class="bi bi-arrow-repeat"></i> Force change</button>
+                <button class="btn btn-sm btn-outline-secondary unlock-user-btn" data-user-id="${user.id}" title="End a lockout after failed logins"><i class="bi bi-unlock"></i> Unlock</button>
+                <button class="btn btn-sm btn-danger delete-user-btn" data-user-id="${user.id}"><i class="bi bi-trash"></i> Delete</button>
+            </span>
         `;
         usersList.appendChild(userItem);
     });
+    usersList.querySelectorAll('.edit-user-btn').forEach(btn => {
+        btn.addEventListener('click', function() {
+            openEditUser(users.find(u => u.id === this.getAttribute('data-user-id')));
+        });
+    });
+    usersList.querySelectorAll('.unlock-user-btn').forEach(btn => {
+        btn.addEventListener('click', function() {
+            unlockUser(this.getAttribute('data-user-id'), this);
+        });
+    });
+    usersList.querySelectorAll('.reset-token-btn').forEach(btn => {
+        btn.addEventListener('click', function() {
+            issueResetToken(this.getAttribute('data-user-id'), this);
+        });
+    });
+    usersList.querySelectorAll('.force-change-btn').forEach(btn => {
+        btn.addEventListener('click', function() {
+            forcePasswordChange(this.getAttribute('data-user-id'), this);
+        });
+    });
     // Attach event listeners for delete buttons
     usersList.querySelectorAll('.delete-user-btn').forEach(btn => {
         btn.addEventListener('click', function() {
-            const userId = this.getAttribute('data-user-id');
-            deleteUser(userId, this);
+            openDeleteUser(users.find(u => u.id === this.getAttribute('data-user-id')), users);
         });
     });
 }
@@ -518,13 +814,29 @@ async function fetchAllUsers() {
     }
 }
 
-// Update deleteUser to use id in the API call
-async function deleteUser(userId, btn) {
-    if (!confirm('Are you sure you want to delete this user?')) return;
-    btn.disabled = true;
+// Ask what happens to the files of a user before deleting it
+function openDeleteUser(user, users) {
+    document.getElementById('deleteUserId').value = user.id;
+    document.getElementById('deleteUserName').textContent = user.username;
+    document.getElementById('deleteFilesArchive').checked = true;
+    const transferTo = document.getElementById('deleteTransferTo');
+    transferTo.innerHTML = '';
+    users.filter(u => u.id !== user.id).forEach(u => {
+        const option = document.createElement('option');
+        option.value = u.id;
+        option.textContent = u.username;
+        transferTo.appendChild(option);
+    });
+    bootstrap.Modal.getOrCreateInstance(document.getElementById('deleteUserModal')).show();
+}
+
+// Delete a user, archiving, transferring or purging the personal files
+async function deleteUser(userId, files, transferTo) {
     try {
         showLoading('Deleting user...');
-        const response = await fetch(`${API_URL}/users/${encodeURIComponent(userId)}`, {
+        const params = new URLSearchParams({ files });
+        if (files === 'transfer') params.set('to', transferTo);
+        const response = await fetch(`${API_URL}/users/${encodeURIComponent(userId)}?${params}`, {
             method: 'DELETE',
             headers: {
                 'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
@@ -535,12 +847,92 @@ async function deleteUser(userId, btn) {
             const data = await response.json();
             throw new Error(data.error || 'Delete failed');
         }
-        // Remove user from the list
-        btn.closest('.list-group-item').remove();
+        const modal = bootstrap.Modal.getInstance(document.getElementById('deleteUserModal'));
+        if (modal) modal.hide();
+        await fetchAllUsers();
     } catch (error) {
         alert(`Error during delete: ${error.message || error}`);
     } finally {
         hideLoading();
+    }
+}
+
+// Fill the edit user form and show it
+function openEditUser(user) {
+    document.getElementById('editUserId').value = user.id;
+    document.getElementById('editUsername').value = user.username;
+    document.getElementById('editDisplayName').value = user.display_name || '';
+    document.getElementById('editEmail').value = user.email || '';
+    document.getElementById('editIsAdmin').checked = user.is_admin;
+    document.getElementById('editDisabled').checked = user.disabled;
+    bootstrap.Modal.getOrCreateInstance(document.getElementById('editUserModal')).show();
+}
+
+// End the lockout of a user after failed logins
+async function unlockUser(userId, btn) {
+    btn.disabled = true;
+    try {
+        const response = await fetch(`${API_URL}/users/${encodeURIComponent(userId)}/lockout`, {
+            method: 'DELETE',
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            },
+        });
+        handleApiResponse(response);
+        if (!response.ok) {
+            const data = await response.json();
+            throw new Error(data.error || 'Unlock failed');
+        }
+        alert('User unlocked');
+    } catch (error) {
+        alert(`Error during unlock: ${error.message || error}`);
+    } finally {
+        btn.disabled = false;
+    }
+}
+
+// Issue a one-time password reset token and show it to the admin to pass on
+async function issueResetToken(userId, btn) {
+    btn.disabled = true;
+    try {
+        const response = await fetch(`${API_URL}/users/${encodeURIComponent(userId)}/reset-token`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            },
+        });
+        handleApiResponse(response);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || 'Issuing the token failed');
+        }
+        prompt(`Pass this token on to the user, it works once until ${new Date(data.expires_at).toLocaleString()}`, data.token);
+    } catch (error) {
+        alert(`Error issuing reset token: ${error.message || error}`);
+    } finally {
+        btn.disabled = false;
+    }
+}
+
+// Require a user to choose a new password before doing anything else
+async function forcePasswordChange(userId, btn) {
+    btn.disabled = true;
+    try {
+        const response = await fetch(`${API_URL}/users/${encodeURIComponent(userId)}/force-password-change`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            },
+        });
+        handleApiResponse(response);
+        if (!response.ok) {
+            const data = await response.json();
+            throw new Error(data.error || 'Request failed');
+        }
+        alert('The user has to choose a new password before continuing');
+    } catch (error) {
+        alert(`Error forcing password change: ${error.message || error}`);
+    } finally {
         btn.disabled = false;
     }
 }
@@ -553,6 +945,107 @@ if (usersModal) {
     });
 }
 
+// The settings last loaded into the form
+let loadedSettings = {};
+
+// Fill the settings form from the server (admin only)
+async function fetchSettings() {
+    try {
+        showLoading('Loading settings...');
+        const response = await fetch(`${API_URL}/admin/settings`, {
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+            },
+        });
+        handleApiResponse(response);
+        if (!response.ok) {
+            throw new Error('Failed to fetch settings');
+        }
+        const settings = await response.json();
+        document.getElementById('settingMaxUpload').value = settings.max_upload_size_mb;
+        document.getElementById('settingQuota').value = settings.default_quota_mb;
+        document.getElementById('settingAllowedTypes').value = settings.allowed_mime_types.join(', ');
+        document.getElementById('settingBlockedTypes').value = settings.blocked_mime_types.join(', ');
+        document.getElementById('settingRateLimit').checked = settings.rate_limit.enabled;
+        document.getElementById('settingRateLimitMax').value = settings.rate_limit.max;
+        document.getElementById('settingRateLimitWindow').value = settings.rate_limit.expiration_seconds;
+        loadedSettings = settings;
+        document.getElementById('settingLoginLimit').value = settings.login_rate_limit.max;
+        document.getElementById('settingUserLimit').value = settings.user_rate_limit.max;
+        document.getElementById('settingDownloadKBps').value = settings.bandwidth.download_kbps;
+        document.getElementById('settingUploadKBps').value = settings.bandwidth.upload_kbps;
+        document.getElementById('settingRegistration').checked = settings.registration === 'open';
+    } catch (error) {
+        console.error('Error fetching settings:', error);
+        alert(error.message || 'Failed to load settings');
+    } finally {
+        hideLoading();
+    }
+}
+
+// Save the settings form
+async function saveSettings() {
+    const list = (id) => document.getElementById(id).value.split(',').map(s => s.trim()).filter(Boolean);
+    const settings = {
+        max_upload_size_mb: parseInt(document.getElementById('settingMaxUpload').value, 10),
+        default_quota_mb: parseInt(document.getElementById('settingQuota').value, 10),
+        allowed_mime_types: list('settingAllowedTypes'),
+        blocked_mime_types: list('settingBlockedTypes'),
+        rate_limit: {
+            enabled: document.getElementById('settingRateLimit').checked,
+            max: parseInt(document.getElementById('settingRateLimitMax').value, 10),
+            expiration_seconds: parseInt(document.getElementById('settingRateLimitWindow').value, 10),
+        },
+        registration: document.getElementById('settingRegistration').checked ? 'open' : 'closed',
+        bandwidth: {
+            download_kbps: parseInt(document.getElementById('settingDownloadKBps').value, 10),
+            upload_kbps: parseInt(document.getElementById('settingUploadKBps').value, 10),
+        },
+    };
+    // Only the maximum of these limits is edited here, their window and switch are kept
+    settings.login_rate_limit = {
+        ...loadedSettings.login_rate_limit,
+        max: parseInt(document.getElementById('settingLoginLimit').value, 10),
+    };
+    settings.user_rate_limit = {
+        ...loadedSettings.user_rate_limit,
+        max: parseInt(document.getElementById('settingUserLimit').value, 10),
+    };
+    try {
+        showLoading('Saving settings...');
+        const response = await fetch(`${API_URL}/admin/settings`, {
+            method: 'PATCH',
+            headers: {
+                'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify(settings),
+        });
+        handleApiResponse(response);
+        if (!response.ok) {
+            const data = await response.json();
+            throw new Error(data.error || 'Saving settings failed');
+        }
+        bootstrap.Modal.getInstance(document.getElementById('settingsModal'))?.hide();
+    } catch (error) {
+        alert(`Error saving settings: ${error.message || error}`);
+    } finally {
+        hideLoading();
+    }
+}
+
+// Fetch settings when the settingsModal is shown
+const settingsModal = document.getElementById('settingsModal');
+if (settingsModal) {
+    settingsModal.addEventListener('show.bs.modal', () => {
+        fetchSettings();
+    });
+    document.getElementById('settingsForm').addEventListener('submit', (e) => {
+        e.preventDefault();
+        saveSettings();
+    });
+}
+
 // Initialize the application
 document.addEventListener('DOMContentLoaded', () => {
     // Initialize password visibility toggles
@@ -587,10 +1080,18 @@ document.addEventListener('DOMContentLoaded', () => {
                     const modal = bootstrap.Modal.getInstance(document.getElementById('loginModal'));
                     if (modal) modal.hide();
                     loginForm.reset();
-                    
+                    hideLoading();
+
+                    // The token only allows choosing a new password until that is done
+                    if (data.must_change_password) {
+                        document.getElementById('currentPassword').value = password;
+                        new bootstrap.Modal(document.getElementById('resetPasswordModal')).show();
+                        alert('Please choose a new password before continuing');
+                        return;
+                    }
+
                     // Update UI using the new function
                     showAuthenticatedUI();
-                    hideLoading();
                 } else {
                     throw new Error(data.error || 'Login failed');
                 }
@@ -668,12 +1169,6 @@ document.addEventListener('DOMContentLoaded', () => {
             const currentPassword = document.getElementById('currentPassword').value;
             const newPassword = document.getElementById('newPassword').value;
 
-            // Validate new password length
-            if (newPassword.length < 8) {
-                alert('New password must be at least 8 characters long');
-                return;
-            }
-
             showLoading('Resetting password...');
             try {
                 const response = await fetch(`${API_URL}/reset-password`, {
@@ -690,6 +1185,11 @@ document.addEventListener('DOMContentLoaded', () => {
                     const modal = bootstrap.Modal.getInstance(document.getElementById('resetPasswordModal'));
                     if (modal) modal.hide();
                     resetPasswordForm.reset();
+                    // A forced change hands out a regular token
+                    if (data.token) {
+                        localStorage.setItem('token', data.token);
+                        showAuthenticatedUI();
+                    }
                     showLoading('Password reset successful!');
                     setTimeout(() => {
                         hideLoading();
@@ -705,6 +1205,40 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     }
 
+    // Handle reset with a token issued by an admin
+    const tokenResetForm = document.getElementById('tokenResetForm');
+    if (tokenResetForm) {
+        tokenResetForm.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            const token = document.getElementById('resetToken').value.trim();
+            const newPassword = document.getElementById('tokenNewPassword').value;
+
+            showLoading('Resetting password...');
+            try {
+                const response = await fetch(`${API_URL}/reset-password/token`, {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({ token, new_password: newPassword })
+                });
+
+                const data = await response.json();
+                if (!response.ok) {
+                    throw new Error(data.error || 'Password reset failed');
+                }
+                bootstrap.Modal.getInstance(document.getElementById('tokenResetModal'))?.hide();
+                tokenResetForm.reset();
+                hideLoading();
+                alert('Password changed, you can log in now');
+                showLoginModal();
+            } catch (error) {
+                alert(error.message || 'Error during password reset');
+                hideLoading();
+            }
+        });
+    }
+
     // Handle create user
     const createUserForm = document.getElementById('createUserForm');
     if (createUserForm) {
@@ -713,12 +1247,7 @@ document.addEventListener('DOMContentLoaded', () => {
             const username = document.getElementById('newUsername').value;
             const password = document.getElementById('newUserPassword').value;
             const isAdmin = document.getElementById('isAdminCheckbox').checked;
-
-            // Validate password length
-            if (password.length < 8) {
-                alert('Password must be at least 8 characters long');
-                return;
-            }
+            const mustChangePassword = document.getElementById('mustChangeCheckbox').checked;
 
             showLoading('Creating user...');
             try {
@@ -728,7 +1257,7 @@ document.addEventListener('DOMContentLoaded', () => {
                         'Content-Type': 'application/json',
                         'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
                     },
-                    body: JSON.stringify({ username, password, is_admin: isAdmin })
+                    body: JSON.stringify({ username, password, is_admin: isAdmin, must_change_password: mustChangePassword })
                 });
 
                 const data = await response.json();
@@ -751,6 +1280,58 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     }
 
+    // Handle delete user form submission
+    const deleteUserForm = document.getElementById('deleteUserForm');
+    if (deleteUserForm) {
+        deleteUserForm.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            const files = deleteUserForm.querySelector('input[name="deleteUserFiles"]:checked').value;
+            if (files === 'purge' && !confirm('The personal files will be deleted for good. Continue?')) return;
+            await deleteUser(document.getElementById('deleteUserId').value, files, document.getElementById('deleteTransferTo').value);
+        });
+    }
+
+    // Handle edit user form submission
+    const editUserForm = document.getElementById('editUserForm');
+    if (editUserForm) {
+        editUserForm.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            const userId = document.getElementById('editUserId').value;
+            const update = {
+                username: document.getElementById('editUsername').value,
+                display_name: document.getElementById('editDisplayName').value,
+                email: document.getElementById('editEmail').value,
+                is_admin: document.getElementById('editIsAdmin').checked,
+                disabled: document.getElementById('editDisabled').checked,
+            };
+
+            showLoading('Saving user...');
+            try {
+                const response = await fetch(`${API_URL}/users/${encodeURIComponent(userId)}`, {
+                    method: 'PATCH',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+                    },
+                    body: JSON.stringify(update)
+                });
+                handleApiResponse(response);
+                const data = await response.json();
+                if (!response.ok) {
+                    throw new Error(data.error || 'Failed to update user');
+                }
+                const modal = bootstrap.Modal.getInstance(document.getElementById('editUserModal'));
+                if (modal) modal.hide();
+                await fetchAllUsers();
+            } catch (error) {
+                console.error('Update user error:', error);
+                alert(error.message || 'Error updating user');
+            } finally {
+                hideLoading();
+            }
+        });
+    }
+
     // Handle file search
     const fileSearchForm = document.getElementById('fileSearchForm');
     const fileSearchInput = document.getElementById('fileSearchInput');
@@ -765,36 +1346,33 @@ document.addEventListener('DOMContentLoaded', () => {
             }
             showLoading('Searching files...');
             try {
-                // Search my files
-                const myFilesResponse = await fetch(`${API_URL}/files?keyword=${encodeURIComponent(keyword)}`, {
-                    headers: {
-                        'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
-                    },
-                });
-                handleApiResponse(myFilesResponse);
-                const myFilesData = await myFilesResponse.json();
-                if (!myFilesResponse.ok) {
-                    throw new Error(myFilesData.error || 'Failed to search my files');
+                // Full-text search returns both spaces at once
+                if (document.getElementById('contentSearchCheckbox')?.checked) {
+                    const response = await fetch(`${API_URL}/search?q=${encodeURIComponent(keyword)}`, {
+                        headers: {
+                            'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
+                        },
+                    });
+                    handleApiResponse(response);
+                    const hits = await response.json();
+                    if (!response.ok) {
+                        throw new Error(hits.error || 'Failed to search file contents');
+                    }
+                    displayFiles(hits.filter(hit => !hit.is_shared), document.getElementById('myFilesList'));
+                    displayFiles(hits.filter(hit => hit.is_shared), document.getElementById('sharedFilesList'));
+                    return;
                 }
+
+                // Search my files
                 const myFilesList = document.getElementById('myFilesList');
                 if (myFilesList) {
-                    displayFiles(myFilesData, myFilesList, 'My Files');
+                    await loadFilePage(myFilesList, { keyword });
                 }
 
                 // Search shared files
-                const sharedFilesResponse = await fetch(`${API_URL}/files?shared=true&keyword=${encodeURIComponent(keyword)}`, {
-                    headers: {
-                        'Authorization': `Bearer ${getAuthTokenOrRedirect()}`,
-                    },
-                });
-                handleApiResponse(sharedFilesResponse);
-                const sharedFilesData = await sharedFilesResponse.json();
-                if (!sharedFilesResponse.ok) {
-                    throw new Error(sharedFilesData.error || 'Failed to search shared files');
-                }
                 const sharedFilesList = document.getElementById('sharedFilesList');
                 if (sharedFilesList) {
-                    displayFiles(sharedFilesData, sharedFilesList, 'Public Files');
+                    await loadFilePage(sharedFilesList, { shared: 'true', keyword });
                 }
             } catch (error) {
                 console.error('Error searching files:', error);
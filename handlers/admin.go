@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AdminHandler struct {
+	DB       *sql.DB
+	Config   *internal.Config
+	Settings *internal.SettingsStore
+	LogINFO  *log.Logger
+	LogError *log.Logger
+}
+
+func NewAdminHandler(db *sql.DB, cfg *internal.Config, settings *internal.SettingsStore, infoLogger, errorLogger *log.Logger) *AdminHandler {
+	return &AdminHandler{
+		DB:       db,
+		Config:   cfg,
+		Settings: settings,
+		LogINFO:  infoLogger,
+		LogError: errorLogger,
+	}
+}
+
+func (h *AdminHandler) Backup(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can create backups")
+	}
+
+	// Build the whole archive before answering, so a failure is reported as an error
+	// instead of ending in a truncated download
+	tmpDir, err := os.MkdirTemp("", "cloudboxio-backup-")
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to prepare backup")
+	}
+
+	archive, err := os.Create(filepath.Join(tmpDir, "backup.tar.gz"))
+	if err == nil {
+		err = internal.CreateBackup(h.DB, h.Config.FilesDir, archive)
+	}
+	if err == nil {
+		_, err = archive.Seek(0, io.SeekStart)
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = archive.Stat()
+	}
+	if err != nil {
+		if archive != nil {
+			archive.Close()
+		}
+		os.RemoveAll(tmpDir)
+		h.LogError.Println("Backup failed:", err)
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to create backup")
+	}
+
+	name := fmt.Sprintf("cloudboxio-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	c.Set(fiber.HeaderContentType, "application/gzip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, name))
+
+	// The temp dir goes once the response has been sent and the stream is closed
+	if err := c.SendStream(&tempFile{File: archive, dir: tmpDir}, int(info.Size())); err != nil {
+		return err
+	}
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+
+	h.LogINFO.Printf("ADMIN user [%s] downloaded a backup (%s)", adminUsername, name)
+
+	return nil
+}
+
+// tempFile is a file that removes its directory when closed.
+type tempFile struct {
+	*os.File
+	dir string
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.dir)
+	return err
+}
+
+func (h *AdminHandler) Fsck(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can check storage consistency")
+	}
+
+	// GET only reports, POST may carry repair options
+	var opts internal.FsckOptions
+	if c.Method() == fiber.MethodPost && len(c.Body()) > 0 {
+		if err := c.BodyParser(&opts); err != nil {
+			return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
+		}
+	}
+
+	if err := opts.Validate(); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+
+	report, err := internal.RunFsck(h.DB, h.Config.FilesDir, h.Config.SharedDir, opts)
+	if err != nil {
+		h.LogError.Println("Fsck failed:", err)
+		return NewAPIError(fiber.StatusInternalServerError, "", "Consistency check failed")
+	}
+
+	if opts != (internal.FsckOptions{}) {
+		adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+		if err != nil {
+			adminUsername = userID
+		}
+		h.LogINFO.Printf("ADMIN user [%s] ran fsck repair on %d issue(s)", adminUsername, len(report.Issues))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(report)
+}
+
+func (h *AdminHandler) Import(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can import files")
+	}
+
+	var req internal.ImportOptions
+	if err := c.BodyParser(&req); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
+	}
+
+	if req.Source == "" || req.Username == "" {
+		return NewAPIError(fiber.StatusBadRequest, "", "Source and username are required")
+	}
+
+	result, err := internal.ImportTree(h.DB, h.Config.FilesDir, h.Config.SharedDir, req)
+	if err != nil {
+		if result == nil {
+			return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+		}
+		h.LogError.Println("Import interrupted:", err)
+		return NewAPIError(fiber.StatusInternalServerError, "", "Import interrupted, run it again to resume").WithDetails(fiber.Map{"result": result})
+	}
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+
+	h.LogINFO.Printf("ADMIN user [%s] imported %d file(s) from %s for (%s)", adminUsername, result.Imported, req.Source, req.Username)
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
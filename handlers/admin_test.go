@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/tests"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	handler := NewAdminHandler(ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/admin/backup", handler.Backup)
+
+	// Create a file to back up
+	fileContent := []byte("backup me")
+	userDir := filepath.Join(ctx.TempDir, "test-id")
+	if err := os.MkdirAll(userDir, os.ModePerm); err != nil {
+		t.Fatal("failed to create user dir:", err)
+	}
+	if err := os.WriteFile(filepath.Join(userDir, "keep.txt"), fileContent, 0644); err != nil {
+		t.Fatal("failed to write temp file:", err)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/backup", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	archive, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+
+	// Restore into a fresh location
+	restoreDir := t.TempDir()
+	dbPath := filepath.Join(restoreDir, "data.db")
+	filesDir := filepath.Join(restoreDir, "uploads")
+
+	// Files the backup does not know about are quarantined, not left as orphans
+	stray := filepath.Join(filesDir, "test-id", "stray.txt")
+	if err := os.MkdirAll(filepath.Dir(stray), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stray, []byte("not in the backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := internal.RestoreBackup(bytes.NewReader(archive), dbPath, filesDir)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+
+	if len(manifest.Files) != 1 {
+		t.Fatalf("expected 1 file in manifest, got %d", len(manifest.Files))
+	}
+
+	restored, err := os.ReadFile(filepath.Join(filesDir, "test-id", "keep.txt"))
+	if err != nil {
+		t.Fatalf("restored file missing: %v", err)
+	}
+	if !bytes.Equal(restored, fileContent) {
+		t.Errorf("expected restored content %q, got %q", fileContent, restored)
+	}
+
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Errorf("expected restored database at %s: %v", dbPath, err)
+	}
+
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("expected the file missing from the backup to be moved away, got %v", err)
+	}
+	quarantined, _ := filepath.Glob(filepath.Join(filesDir, internal.QuarantineDir, "*", "test-id", "stray.txt"))
+	if len(quarantined) != 1 {
+		t.Errorf("expected the file missing from the backup in the quarantine, got %v", quarantined)
+	}
+
+	// A restore failing at the last step puts the previous files back
+	later := filepath.Join(filesDir, "test-id", "later.txt")
+	if err := os.WriteFile(later, []byte("written after the restore"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dbPath+".previous", "blocker"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := internal.RestoreBackup(bytes.NewReader(archive), dbPath, filesDir); err == nil {
+		t.Fatal("expected the restore to fail when the database cannot be replaced")
+	}
+	if _, err := os.Stat(later); err != nil {
+		t.Errorf("expected the files to be put back after a failed restore: %v", err)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Errorf("expected the database to stay after a failed restore: %v", err)
+	}
+	if staged, _ := filepath.Glob(filepath.Join(filesDir, ".restore-*")); len(staged) != 0 {
+		t.Errorf("expected the staged files to be removed, got %v", staged)
+	}
+}
+
+func TestRestoreRejectsTamperedArchive(t *testing.T) {
+	snapshotDir := t.TempDir()
+	snapshot := filepath.Join(snapshotDir, "data.db")
+	if err := os.WriteFile(snapshot, []byte("not really a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filesDir := filepath.Join(snapshotDir, "uploads")
+	if err := os.MkdirAll(filesDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filesDir, "a.txt"), []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := internal.WriteBackup(&archive, snapshot, filesDir); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	if _, err := internal.VerifyBackup(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("expected untouched archive to verify, got %v", err)
+	}
+
+	// Flip the file contents inside the tar stream while keeping the original manifest
+	gr, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = bytes.Replace(raw, []byte("original"), []byte("tampered"), 1)
+
+	var tampered bytes.Buffer
+	gw := gzip.NewWriter(&tampered)
+	if _, err := gw.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	gw.Close()
+
+	if _, err := internal.VerifyBackup(&tampered); err == nil {
+		t.Error("expected tampered archive to fail verification")
+	}
+}
+
+func TestFsckReportAndRepair(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	handler := NewAdminHandler(ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/admin/fsck", handler.Fsck)
+	ctx.App.Post("/admin/fsck", handler.Fsck)
+
+	userDir := filepath.Join(ctx.TempDir, "test-id")
+	if err := os.MkdirAll(userDir, os.ModePerm); err != nil {
+		t.Fatal("failed to create user dir:", err)
+	}
+
+	// Orphan: file without a row
+	if err := os.WriteFile(filepath.Join(userDir, "orphan.txt"), []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nested orphan named like an existing file
+	if err := os.MkdirAll(filepath.Join(userDir, "sub"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(userDir, "sub", "mismatch.txt"), []byte("nested"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Size mismatch: row records the wrong size
+	mismatchPath := filepath.Join(userDir, "mismatch.txt")
+	if err := os.WriteFile(mismatchPath, []byte("twelve bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ctx.DB.Exec(`INSERT INTO metadata (id, user_id, filename, size, path, is_shared, uploaded_at) VALUES (?,?,?,?,?,?,?), (?,?,?,?,?,?,?)`,
+		1, "test-id", "mismatch.txt", 5, mismatchPath, false, "today",
+		2, "test-id", "gone.txt", 10, filepath.Join(userDir, "gone.txt"), false, "today")
+	if err != nil {
+		t.Fatal("failed to insert metadata:", err)
+	}
+
+	report := doFsck(t, ctx, "GET", nil)
+
+	kinds := make(map[string]int)
+	for _, issue := range report.Issues {
+		kinds[issue.Kind]++
+		if issue.Action != "" {
+			t.Errorf("expected report-only run, got action %q", issue.Action)
+		}
+	}
+	if kinds[internal.FsckOrphanFile] != 2 || kinds[internal.FsckMissingBlob] != 1 || kinds[internal.FsckSizeMismatch] != 1 {
+		t.Fatalf("unexpected issues: %+v", report.Issues)
+	}
+
+	doFsck(t, ctx, "POST", []byte(`{"orphans":"import","dangling":"remove","mismatch":"update"}`))
+
+	// A second run must come back clean
+	report = doFsck(t, ctx, "GET", nil)
+	if len(report.Issues) != 0 {
+		t.Fatalf("expected no issues after repair, got %+v", report.Issues)
+	}
+
+	var size int64
+	if err := ctx.DB.QueryRow(`SELECT size FROM metadata WHERE id = 1`).Scan(&size); err != nil || size != 12 {
+		t.Errorf("expected size to be updated to 12, got %d (%v)", size, err)
+	}
+
+	var exists bool
+	if err := ctx.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM metadata WHERE filename = 'orphan.txt' AND user_id = 'test-id')`).Scan(&exists); err != nil || !exists {
+		t.Errorf("expected orphan to be imported (%v)", err)
+	}
+	var nestedPath string
+	if err := ctx.DB.QueryRow(`SELECT path FROM metadata WHERE filename = 'mismatch(1).txt' AND user_id = 'test-id'`).Scan(&nestedPath); err != nil || nestedPath != filepath.Join(userDir, "sub", "mismatch.txt") {
+		t.Errorf("expected the nested orphan to be imported under a free name, got %q (%v)", nestedPath, err)
+	}
+}
+
+func doFsck(t *testing.T, ctx *TestContext, method string, body []byte) internal.FsckReport {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/admin/fsck", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	var report internal.FsckReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	return report
+}
+
+func TestImportTreeResumes(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	handler := NewAdminHandler(ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/admin/import", handler.Import)
+
+	// Build a small tree to import
+	source := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(source, "docs"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "top.txt"), []byte("top"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(source, "docs", "nested.txt")
+	if err := os.WriteFile(nested, []byte("nested"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(nested, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(internal.ImportOptions{Source: source, Username: "testuser"})
+
+	result := doImport(t, ctx, body)
+	if result.Imported != 2 || result.Skipped != 0 {
+		t.Fatalf("expected 2 imported files, got %+v", result)
+	}
+
+	// Running again resumes and skips everything already imported
+	result = doImport(t, ctx, body)
+	if result.Imported != 0 || result.Skipped != 2 {
+		t.Fatalf("expected 2 skipped files on rerun, got %+v", result)
+	}
+
+	var uploadedAt string
+	err := ctx.DB.QueryRow(`SELECT uploaded_at FROM metadata WHERE filename = ? AND user_id = ?`, "docs/nested.txt", "test-id").Scan(&uploadedAt)
+	if err != nil {
+		t.Fatalf("expected metadata for docs/nested.txt: %v", err)
+	}
+	if uploadedAt != "2020-01-02 03:04:05" {
+		t.Errorf("expected modification time to be kept, got %s", uploadedAt)
+	}
+
+	info, err := os.Stat(filepath.Join(ctx.TempDir, "test-id", "docs", "nested.txt"))
+	if err != nil {
+		t.Fatalf("expected imported file on disk: %v", err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("expected mtime %v, got %v", mtime, info.ModTime())
+	}
+
+	// Copy mode leaves the source in place
+	if _, err := os.Stat(nested); err != nil {
+		t.Errorf("expected source to remain after copy import: %v", err)
+	}
+
+	// Files already in storage, such as another user's, cannot be imported
+	for _, src := range []string{ctx.TempDir, filepath.Join(ctx.TempDir, "test-id", "docs")} {
+		if _, err := internal.ImportTree(ctx.DB, ctx.TempDir, "shared", internal.ImportOptions{Source: src, Username: "testuser", Move: true}); err == nil {
+			t.Errorf("expected import from %s to be refused", src)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(ctx.TempDir, "test-id", "docs", "nested.txt")); err != nil {
+		t.Errorf("expected the stored file to stay in place: %v", err)
+	}
+}
+
+func doImport(t *testing.T, ctx *TestContext, body []byte) internal.ImportResult {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/admin/import", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	var result internal.ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+
+	return result
+}
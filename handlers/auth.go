@@ -3,9 +3,12 @@ package handlers
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/AumSahayata/cloudboxio/internal"
 	"github.com/AumSahayata/cloudboxio/models"
@@ -17,106 +20,323 @@ import (
 
 type AuthHandler struct {
 	DB       *sql.DB
-	LogINFO  *log.Logger
-	LogError *log.Logger
+	Settings *internal.SettingsStore
+	Guard    *internal.LoginGuard
+	// Config locates the files of users, which are handled when a user is deleted
+	Config *internal.Config
+	// Passwords is the policy new passwords must satisfy
+	Passwords *internal.PasswordPolicy
+	LogINFO   *log.Logger
+	LogError  *log.Logger
 }
 
-func NewAuthHandler(db *sql.DB, infoLogger, errorLogger *log.Logger) *AuthHandler {
+func NewAuthHandler(db *sql.DB, settings *internal.SettingsStore, infoLogger, errorLogger *log.Logger) *AuthHandler {
+	// Hash the dummy password ahead of the first login of an unknown user
+	go dummyHash()
+
+	// The default policy reads no file, RegisterRoutes replaces it and the default config
+	// with the configured ones
+	passwords, _ := internal.NewPasswordPolicy(internal.DefaultConfig().PasswordPolicy)
+
 	return &AuthHandler{
-		DB:       db,
-		LogINFO:  infoLogger,
-		LogError: errorLogger,
+		DB:        db,
+		Settings:  settings,
+		Config:    internal.DefaultConfig(),
+		Guard:     internal.NewLoginGuard(db, settings),
+		Passwords: passwords,
+		LogINFO:   infoLogger,
+		LogError:  errorLogger,
 	}
 }
 
+// dummyHash is compared with the password of unknown users, so they are refused in the same
+// time as a wrong password and the answer does not tell which usernames exist.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not the password of any user"), 14)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
 func (h *AuthHandler) SignUp(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
 	isAdmin := c.Locals("is_admin").(bool)
 
 	if !isAdmin {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only admin can create users"})
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can create users")
 	}
 	var req models.SignUp
 
 	// Put the data from the request body into req.
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid Input"})
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid Input")
 	}
 
 	// Validate required fields.
 	if req.Username == "" || req.Password == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Username and password are required"})
+		return NewAPIError(fiber.StatusBadRequest, "", "Username and password are required")
+	}
+	if err := h.Passwords.Check(req.Password); err != nil {
+		return weakPassword(err)
 	}
 
-	// Generate the hash for the password.
-	hashedpwd, err := bcrypt.GenerateFromPassword([]byte(req.Password), 14)
+	newID, err := h.createUser(req.Username, req.Password, req.IsAdmin, req.MustChangePassword)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Password hashing failed"})
+		if errors.Is(err, errUsernameTaken) {
+			return NewAPIError(fiber.StatusBadRequest, "", "Username already exists")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to register user")
 	}
 
-	stmt, err := h.DB.Prepare("INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, ?, ?)")
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to prepare statement"})
+		h.LogINFO.Printf("ADMIN user [%s] created user (%s)", userID, req.Username)
 	}
 
-	_, err = stmt.Exec(uuid.NewString(), req.Username, string(hashedpwd), req.IsAdmin)
+	h.LogINFO.Printf("ADMIN user [%s] created user (%s)", adminUsername, req.Username)
+	internal.PublishEvent(internal.Event{Type: internal.EventUserCreated, Username: req.Username, UserID: newID})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "User created"})
+}
+
+// Register lets anyone create a regular account while the registration policy is open.
+func (h *AuthHandler) Register(c *fiber.Ctx) error {
+	if h.Settings.Get().Registration != internal.RegistrationOpen {
+		return NewAPIError(fiber.StatusForbidden, "", "Registration is closed, ask an admin for an account")
+	}
+
+	var req models.Login
+	if err := c.BodyParser(&req); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
+	}
+
+	if req.Username == "" || req.Password == "" {
+		return NewAPIError(fiber.StatusBadRequest, "", "Username and password are required")
+	}
+	if err := h.Passwords.Check(req.Password); err != nil {
+		return weakPassword(err)
+	}
+
+	newID, err := h.createUser(req.Username, req.Password, false, false)
 	if err != nil {
-		// Check for SQLite-specific error to check if the username already exists.
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Username already exists"})
+		if errors.Is(err, errUsernameTaken) {
+			return NewAPIError(fiber.StatusBadRequest, "", "Username already exists")
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to register user"})
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to register user")
 	}
 
-	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	h.LogINFO.Printf("User (%s) registered", req.Username)
+	internal.PublishEvent(internal.Event{Type: internal.EventUserCreated, Username: req.Username, UserID: newID})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "User created"})
+}
+
+var errUsernameTaken = errors.New("username already exists")
+
+// createUser stores a new user and returns its ID. With mustChangePassword the user has to
+// choose a new password after logging in.
+func (h *AuthHandler) createUser(username, password string, isAdmin, mustChangePassword bool) (string, error) {
+	// Generate the hash for the password.
+	hashedpwd, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
-		h.LogINFO.Printf("ADMIN user [%s] created user (%s)", userID, req.Username)
+		return "", fmt.Errorf("password hashing failed: %w", err)
 	}
 
-	h.LogINFO.Printf("ADMIN user [%s] created user (%s)", adminUsername, req.Username)
+	newID := uuid.NewString()
+	_, err = h.DB.Exec("INSERT INTO users (id, username, password, is_admin, must_change_password) VALUES (?, ?, ?, ?, ?)",
+		newID, username, string(hashedpwd), isAdmin, mustChangePassword)
+	if err != nil {
+		// Check for SQLite-specific error to check if the username already exists.
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return "", errUsernameTaken
+		}
+		return "", fmt.Errorf("failed to insert user: %w", err)
+	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "User created"})
+	return newID, nil
 }
 
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	var req models.Login
 	// Put the data from the request body into req
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
 	}
 
 	// Validate required fields
 	if req.Username == "" || req.Password == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Username and password are required"})
+		return NewAPIError(fiber.StatusBadRequest, "", "Username and password are required")
+	}
+
+	// Refuse blocked accounts and clients before spending time on the password
+	ip := c.IP()
+	wait, err := h.Guard.Blocked(req.Username, ip)
+	if err != nil {
+		h.LogError.Println("Failed to check login lockout:", err)
+	}
+	if wait > 0 {
+		h.recordLogin(req.Username, ip, internal.LoginLocked)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())))
+		return NewAPIError(fiber.StatusTooManyRequests, "", "Too many failed logins. Try again later.")
 	}
 
 	// Get user from DB
-	row := h.DB.QueryRow(`SELECT id, password, is_admin FROM users WHERE username = ?`, req.Username)
+	row := h.DB.QueryRow(`SELECT id, password, is_admin, must_change_password, disabled FROM users WHERE username = ?`, req.Username)
 
 	var userID, hashedpwd string
-	var is_admin bool
-	if err := row.Scan(&userID, &hashedpwd, &is_admin); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+	var is_admin, mustChange, disabled bool
+	if err := row.Scan(&userID, &hashedpwd, &is_admin, &mustChange, &disabled); err != nil {
+		hashedpwd = string(dummyHash())
 	}
 
 	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedpwd), []byte(req.Password)); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedpwd), []byte(req.Password)); err != nil || userID == "" {
+		if err := h.Guard.Fail(req.Username, ip); err != nil {
+			h.LogError.Println("Failed to count login failure:", err)
+		}
+		h.recordLogin(req.Username, ip, internal.LoginFailed)
+		return NewAPIError(fiber.StatusUnauthorized, "", "Invalid credentials")
+	}
+
+	if disabled {
+		h.recordLogin(req.Username, ip, internal.LoginFailed)
+		return NewAPIError(fiber.StatusForbidden, "", "Account is disabled")
 	}
 
 	if !internal.IsAdminSetup(h.DB) && !is_admin {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Please login and reset admin password first."})
+		h.recordLogin(req.Username, ip, internal.LoginFailed)
+		return NewAPIError(fiber.StatusUnauthorized, "", "Please login and reset admin password first.")
 	}
 
-	// Generate JWT
-	token, err := internal.GenerateToken(userID, is_admin, 72)
+	// A user told to change the password gets a short token that allows only that
+	var token string
+	if mustChange {
+		token, err = internal.GeneratePasswordChangeToken(userID, is_admin, 1)
+	} else {
+		token, err = internal.GenerateToken(userID, is_admin, 72)
+	}
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to generate token")
 	}
 
+	// Only a login that hands out a token counts as a success
+	if err := h.Guard.Succeed(userID); err != nil {
+		h.LogError.Println("Failed to reset login failures:", err)
+	}
+	h.recordLogin(req.Username, ip, internal.LoginSucceeded)
+
+	if mustChange {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token, "must_change_password": true})
+	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token})
 }
 
+// recordLogin adds a login attempt to the history of the user, if there is one.
+func (h *AuthHandler) recordLogin(username, ip, result string) {
+	if err := h.Guard.Record(username, ip, result); err != nil {
+		h.LogError.Println("Failed to record login:", err)
+	}
+}
+
+// LoginHistory lists the latest login attempts of a user. Users may read their own history,
+// admins that of anyone.
+func (h *AuthHandler) LoginHistory(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	targetID, err := internal.CleanParam(c.Params("id"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "User ID provided is not proper")
+	}
+	if targetID != userID && !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can read the login history of other users")
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, targetID).Scan(&exists); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query user")
+	}
+	if !exists {
+		return NewAPIError(fiber.StatusNotFound, "", "User not found")
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	// Newest first, optionally narrowed to one result such as failure
+	stmt := `SELECT id, ip, result, created_at FROM login_history WHERE user_id = ?`
+	args := []any{targetID}
+	if result := c.Query("result"); result != "" {
+		stmt += ` AND result = ?`
+		args = append(args, result)
+	}
+	stmt += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := h.DB.Query(stmt, args...)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query login history")
+	}
+	defer rows.Close()
+
+	attempts := make([]models.LoginAttempt, 0)
+	for rows.Next() {
+		var a models.LoginAttempt
+		if err := rows.Scan(&a.ID, &a.IP, &a.Result, &a.CreatedAt); err != nil {
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(attempts)
+}
+
+// UnlockUser ends the lockout of an account and forgets its failed logins. With the ip
+// query parameter the lockout of that client IP is ended too.
+func (h *AuthHandler) UnlockUser(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can unlock users")
+	}
+
+	targetID, err := internal.CleanParam(c.Params("id"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "User ID provided is not proper")
+	}
+
+	username, err := internal.GetUsernameByID(targetID, h.DB)
+	if err != nil {
+		return NewAPIError(fiber.StatusNotFound, "", "User not found")
+	}
+
+	if err := h.Guard.Unlock(targetID); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to unlock user")
+	}
+	ip := c.Query("ip")
+	if ip != "" {
+		if err := h.Guard.UnlockIP(ip); err != nil {
+			return NewAPIError(fiber.StatusInternalServerError, "", "Failed to unlock IP")
+		}
+	}
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+	if ip != "" {
+		h.LogINFO.Printf("ADMIN user [%s] unlocked user (%s) and IP %s", adminUsername, username, ip)
+	} else {
+		h.LogINFO.Printf("ADMIN user [%s] unlocked user (%s)", adminUsername, username)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
 func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
 	isAdmin := c.Locals("is_admin").(bool)
@@ -124,45 +344,45 @@ func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
 	var req models.ResetPassword
 	// Put the data from the request body into req
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
 	}
 
 	// Validate required fields
 	if req.CurrentPassword == "" || req.NewPassword == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "All the fields are required"})
+		return NewAPIError(fiber.StatusBadRequest, "", "All the fields are required")
 	}
 
-	if len(req.NewPassword) < 8 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "New password must be at least 8 characters"})
+	if err := h.Passwords.Check(req.NewPassword); err != nil {
+		return weakPassword(err)
 	}
 
 	// Find user
 	row := h.DB.QueryRow("SELECT id, username, password FROM users WHERE id = ?", userID)
 	var user models.User
 	if err := row.Scan(&user.ID, &user.Username, &user.Password); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+		return NewAPIError(fiber.StatusNotFound, "", "User not found")
 	}
 
 	// Verify old password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Incorrect current password"})
+		return NewAPIError(fiber.StatusUnauthorized, "", "Incorrect current password")
 	}
 
 	// Hash new password
 	hashedNew, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), 14)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to hash password")
 	}
 
 	// Update new password
-	if _, err := h.DB.Exec(`UPDATE users SET password = ? WHERE id = ?`, string(hashedNew), userID); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update password"})
+	if _, err := h.DB.Exec(`UPDATE users SET password = ?, must_change_password = FALSE WHERE id = ?`, string(hashedNew), userID); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update password")
 	}
 
 	// Complete admin setup
 	if isAdmin && !internal.IsAdminSetup(h.DB) {
 		if err := internal.ChangeSetting("admin_setup_done", "true", h.DB); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Password changed, but failed to update system state"})
+			return NewAPIError(fiber.StatusInternalServerError, "", "Password changed, but failed to update system state")
 		}
 		// Delete temp_admin_credentials.txt file
 		if err := os.Remove("temp_admin_credentials.txt"); err != nil {
@@ -170,28 +390,27 @@ func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
 		}
 	}
 
+	// A token that only allowed this change is replaced by a regular one
+	if restricted, _ := c.Locals("password_change").(bool); restricted {
+		token, err := internal.GenerateToken(userID, isAdmin, 72)
+		if err != nil {
+			return NewAPIError(fiber.StatusInternalServerError, "", "Password changed, but failed to generate token")
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password reset successful", "token": token})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password reset successful"})
 }
 
 func (h *AuthHandler) GetUserInfo(c *fiber.Ctx) error {
 	var userID = c.Locals("user_id")
 
-	row := h.DB.QueryRow(`SELECT id, username, is_admin FROM users WHERE id = ?`, userID)
-
-	var id string
-	var username string
-	var isAdmin bool
-	if err := row.Scan(&id, &username, &isAdmin); err != nil {
+	userData, err := scanUserInfo(h.DB.QueryRow(`SELECT `+userInfoColumns+` FROM users WHERE id = ?`, userID))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+			return NewAPIError(fiber.StatusNotFound, "", "User not found")
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch user data"})
-	}
-
-	userData := models.UserInfo{
-		ID:       id,
-		Username: username,
-		IsAdmin:  isAdmin,
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch user data")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(userData)
@@ -201,29 +420,23 @@ func (h *AuthHandler) GetUsers(c *fiber.Ctx) error {
 	isAdmin := c.Locals("is_admin").(bool)
 
 	if !isAdmin {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only admin can access users list"})
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can access users list")
 	}
 
-	rows, err := h.DB.Query(`SELECT id, username, is_admin FROM users`)
+	rows, err := h.DB.Query(`SELECT ` + userInfoColumns + ` FROM users`)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Users not found"})
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to fetch users")
 	}
+	defer rows.Close()
 
 	usersList := []models.UserInfo{}
 
 	for rows.Next() {
-
-		var id string
-		var username string
-		var isADM bool
-		if err := rows.Scan(&id, &username, &isADM); err != nil {
+		user, err := scanUserInfo(rows)
+		if err != nil {
 			continue
 		}
-		usersList = append(usersList, models.UserInfo{
-			ID:       id,
-			Username: username,
-			IsAdmin:  isADM,
-		})
+		usersList = append(usersList, user)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(usersList)
@@ -234,18 +447,38 @@ func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
 	isAdmin := c.Locals("is_admin").(bool)
 
 	if !isAdmin {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only admin can delete users"})
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can delete users")
 	}
 
 	delID := c.Params("id")
 	delID, err := internal.CleanParam(delID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to validate user id"})
+		return NewAPIError(fiber.StatusBadRequest, "", "User ID provided is not proper")
 	}
 
 	// Check if self delete
 	if userID == delID {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot delete self"})
+		return NewAPIError(fiber.StatusForbidden, "", "Cannot delete self")
+	}
+
+	// Personal files are archived unless the admin chooses otherwise, shared files pass to
+	// the user receiving the transfer or else to the admin
+	mode := c.Query("files", models.UserFilesArchive)
+	if mode != models.UserFilesArchive && mode != models.UserFilesTransfer && mode != models.UserFilesPurge {
+		return NewAPIError(fiber.StatusBadRequest, "", "Files must be archive, transfer or purge")
+	}
+	newOwner := userID
+	if to := c.Query("to"); to != "" || mode == models.UserFilesTransfer {
+		if to, err = internal.CleanParam(to); err != nil || to == "" {
+			return NewAPIError(fiber.StatusBadRequest, "", "User to transfer the files to is required")
+		}
+		if to == delID {
+			return NewAPIError(fiber.StatusBadRequest, "", "Cannot transfer files to the deleted user")
+		}
+		if _, err := internal.GetUsernameByID(to, h.DB); err != nil {
+			return NewAPIError(fiber.StatusBadRequest, "", "User to transfer the files to not found")
+		}
+		newOwner = to
 	}
 
 	// Check if user to delete is admin
@@ -253,36 +486,34 @@ func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
 	var isTargetAdmin bool
 	row := h.DB.QueryRow(`SELECT is_admin, username FROM users WHERE id = ?`, delID)
 	if err := row.Scan(&isTargetAdmin, &delUsername); err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+		return NewAPIError(fiber.StatusNotFound, "", "User not found")
 	}
 
 	// If deleting an admin, count how many admins are left
 	if isTargetAdmin {
-		var adminCount int
-		err = h.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE is_admin = TRUE`).Scan(&adminCount)
+		adminCount, err := countActiveAdmins(h.DB, delID)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check admin count"})
+			return NewAPIError(fiber.StatusInternalServerError, "", "Failed to check admin count")
 		}
 
-		if adminCount <= 1 {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot delete the only remaining admin user"})
+		if adminCount == 0 {
+			return NewAPIError(fiber.StatusForbidden, "", "Cannot delete the only remaining admin user")
 		}
 	}
 
-	_, err = h.DB.Exec(`DELETE FROM users WHERE id = ?`, delID)
+	deletion, err := h.deleteUserWithFiles(delID, delUsername, mode, newOwner)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete user"})
+		h.LogError.Printf("Failed to delete user (%s): %v", delUsername, err)
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not delete user")
 	}
 
 	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
 	if err != nil {
-		h.LogINFO.Printf("ADMIN user [%s] deleted user (%s)", userID, delUsername)
+		adminUsername = userID
 	}
 
-	h.LogINFO.Printf("ADMIN user [%s] deleted user (%s)", adminUsername, delUsername)
+	h.LogINFO.Printf("ADMIN user [%s] deleted user (%s), %s", adminUsername, delUsername, deletion)
+	internal.PublishEvent(internal.Event{Type: internal.EventUserDeleted, Username: delUsername, UserID: delID})
 
-	return c.Status(fiber.StatusNoContent).JSON(fiber.Map{"message": "User deleted successfully"})
+	return c.SendStatus(fiber.StatusNoContent)
 }
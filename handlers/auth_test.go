@@ -22,8 +22,8 @@ func TestLoginBlockedBeforeAdminSetup(t *testing.T) {
 	tests.SetAdminSetupFlag(db, false)
 	voidLogger := log.New(io.Discard, "", 0)
 
-	app := fiber.New()
-	handler := NewAuthHandler(db, voidLogger, voidLogger)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	handler := NewAuthHandler(db, testSettings(t, db, internal.DefaultConfig()), voidLogger, voidLogger)
 	app.Post("/login", handler.Login)
 
 	payload := map[string]string{"username": "admin", "password": "admin"}
@@ -63,8 +63,8 @@ func TestLoginWrongPassword(t *testing.T) {
 		t.Fatalf("failed to insert test user: %v", err)
 	}
 
-	app := fiber.New()
-	handler := NewAuthHandler(db, voidLogger, voidLogger)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	handler := NewAuthHandler(db, testSettings(t, db, internal.DefaultConfig()), voidLogger, voidLogger)
 	app.Post("/login", handler.Login)
 
 	// Incorrect password
@@ -105,8 +105,8 @@ func TestLoginSuccess(t *testing.T) {
 		t.Fatalf("failed to insert test user: %v", err)
 	}
 
-	app := fiber.New()
-	handler := NewAuthHandler(db, voidLogger, voidLogger)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	handler := NewAuthHandler(db, testSettings(t, db, internal.DefaultConfig()), voidLogger, voidLogger)
 	app.Post("/login", handler.Login)
 
 	payload := map[string]string{"username": username, "password": correctPassword}
@@ -144,8 +144,8 @@ func TestSignupAsAdmin(t *testing.T) {
 	tests.SetAdminSetupFlag(db, true)
 	voidLogger := log.New(io.Discard, "", 0)
 
-	app := fiber.New()
-	handler := NewAuthHandler(db, voidLogger, voidLogger)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	handler := NewAuthHandler(db, testSettings(t, db, internal.DefaultConfig()), voidLogger, voidLogger)
 
 	// Middleware to inject is_admin = true and user_id
 	app.Use(func(c *fiber.Ctx) error {
@@ -196,9 +196,9 @@ func TestSignupAsNonAdmin(t *testing.T) {
 
 	tests.SetAdminSetupFlag(db, true)
 
-	app := fiber.New()
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
 	voidLogger := log.New(io.Discard, "", 0)
-	handler := NewAuthHandler(db, voidLogger, voidLogger)
+	handler := NewAuthHandler(db, testSettings(t, db, internal.DefaultConfig()), voidLogger, voidLogger)
 
 	// Middleware to inject is_admin = false (simulate non-admin user)
 	app.Use(func(c *fiber.Ctx) error {
@@ -238,9 +238,9 @@ func TestProtectedRouteWithValidToken(t *testing.T) {
 
 	tests.SetAdminSetupFlag(ctx.DB, true)
 
-	handler := NewAuthHandler(ctx.DB, ctx.Log, ctx.Log)
+	handler := NewAuthHandler(ctx.DB, ctx.Settings, ctx.Log, ctx.Log)
 
-	ctx.App.Use(internal.JWTProtected())
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Get("/user-info", handler.GetUserInfo)
 
 	// Access protected route with token
@@ -263,9 +263,9 @@ func TestResetPassword(t *testing.T) {
 	ctx := SetupTestContext(t)
 
 	tests.SetAdminSetupFlag(ctx.DB, true)
-	handler := NewAuthHandler(ctx.DB, ctx.Log, ctx.Log)
+	handler := NewAuthHandler(ctx.DB, ctx.Settings, ctx.Log, ctx.Log)
 
-	ctx.App.Use(internal.JWTProtected())
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Put("/reset-password", handler.ResetPassword)
 
 	payload := models.ResetPassword{
@@ -297,9 +297,9 @@ func TestGetUsers(t *testing.T) {
 	ctx := SetupTestContext(t)
 
 	tests.SetAdminSetupFlag(ctx.DB, true)
-	handler := NewAuthHandler(ctx.DB, ctx.Log, ctx.Log)
+	handler := NewAuthHandler(ctx.DB, ctx.Settings, ctx.Log, ctx.Log)
 
-	ctx.App.Use(internal.JWTProtected())
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Get("/users", handler.GetUsers)
 
 	req := httptest.NewRequest("GET", "/users", nil)
@@ -365,9 +365,9 @@ func TestDeleteUsers(t *testing.T) {
 	ctx := SetupTestContext(t)
 
 	tests.SetAdminSetupFlag(ctx.DB, true)
-	handler := NewAuthHandler(ctx.DB, ctx.Log, ctx.Log)
+	handler := NewAuthHandler(ctx.DB, ctx.Settings, ctx.Log, ctx.Log)
 
-	ctx.App.Use(internal.JWTProtected())
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Delete("/users/:id", handler.DeleteUser)
 
 	// Create a test user
@@ -402,9 +402,9 @@ func TestGetUserInfo(t *testing.T) {
 	ctx := SetupTestContext(t)
 
 	tests.SetAdminSetupFlag(ctx.DB, true)
-	handler := NewAuthHandler(ctx.DB, ctx.Log, ctx.Log)
+	handler := NewAuthHandler(ctx.DB, ctx.Settings, ctx.Log, ctx.Log)
 
-	ctx.App.Use(internal.JWTProtected())
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Get("/user-info", handler.GetUserInfo)
 
 	req := httptest.NewRequest("GET", "/user-info", nil)
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxBatchSize caps the number of files handled by a single batch request.
+const maxBatchSize = 1000
+
+func (h *FileHandler) BatchDelete(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	req, err := parseBatchRequest(c)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+
+	results := h.forEachFile(req.FileIDs, userID, func(f *fileRecord) error {
+		if f.UserID != userID && !isAdmin {
+			return fmt.Errorf("only the owner can delete this file")
+		}
+		return h.removeFile(f, userID)
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"results": results})
+}
+
+func (h *FileHandler) BatchMove(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	req, err := parseBatchRequest(c)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+
+	folder, err := cleanFolder(req.Folder)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Folder provided is not proper")
+	}
+
+	results := h.forEachFile(req.FileIDs, userID, func(f *fileRecord) error {
+		// Shared files are visible to everyone, but only the uploader or an admin may move them
+		if f.UserID != userID && !isAdmin {
+			return fmt.Errorf("only the owner can move this file")
+		}
+		return h.relocateFile(f, userID, path.Join(folder, path.Base(f.Filename)), f.IsShared)
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"results": results})
+}
+
+func (h *FileHandler) BatchShare(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	req, err := parseBatchRequest(c)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+
+	results := h.forEachFile(req.FileIDs, userID, func(f *fileRecord) error {
+		// Only the uploader or an admin may change who can see a file
+		if f.UserID != userID && !isAdmin {
+			return fmt.Errorf("only the owner can change sharing")
+		}
+		return h.relocateFile(f, userID, f.Filename, req.Shared)
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"results": results})
+}
+
+func (h *FileHandler) DownloadArchive(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	format := c.Query("format", "zip")
+
+	if format != "zip" && format != "tar.gz" {
+		return NewAPIError(fiber.StatusBadRequest, "", "Format must be zip or tar.gz")
+	}
+
+	var files []*fileRecord
+
+	if ids := c.Query("ids"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			f, err := h.accessibleFile(strings.TrimSpace(id), userID)
+			if err != nil {
+				if errors.Is(err, errFileNotFound) {
+					return NewAPIError(fiber.StatusNotFound, "", fmt.Sprintf("File %s not found", id))
+				}
+				return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+			}
+			files = append(files, f)
+		}
+	} else {
+		folder, err := cleanFolder(c.Query("folder"))
+		if err != nil {
+			return NewAPIError(fiber.StatusBadRequest, "", "Folder provided is not proper")
+		}
+
+		files, err = h.folderFiles(userID, folder, c.QueryBool("shared", false))
+		if err != nil {
+			return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query files")
+		}
+	}
+
+	if len(files) == 0 {
+		return NewAPIError(fiber.StatusNotFound, "", "No files selected")
+	}
+
+	name := fmt.Sprintf("cloudboxio-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	contentType := "application/zip"
+	if format == "tar.gz" {
+		contentType = "application/gzip"
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, name))
+
+	// Build the archive on the fly straight into the response
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		out := h.Bandwidth.Writer(userID, w)
+		var err error
+		if format == "zip" {
+			err = writeZipArchive(out, files)
+		} else {
+			err = writeTarGzArchive(out, files)
+		}
+		if err != nil {
+			internal.FileOps.Println("Error streaming archive:", err)
+			return
+		}
+		w.Flush()
+	})
+
+	internal.FileOps.Printf("User [%s] downloaded %d file(s) as %s", userID, len(files), format)
+
+	return nil
+}
+
+// parseBatchRequest reads and validates the body shared by the batch endpoints.
+func parseBatchRequest(c *fiber.Ctx) (*models.BatchRequest, error) {
+	var req models.BatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid input")
+	}
+
+	if len(req.FileIDs) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "At least one file ID is required")
+	}
+	if len(req.FileIDs) > maxBatchSize {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("At most %d files can be processed at once", maxBatchSize))
+	}
+
+	return &req, nil
+}
+
+// forEachFile runs op on every accessible file and collects a result per requested ID.
+func (h *FileHandler) forEachFile(fileIDs []string, userID string, op func(*fileRecord) error) []models.BatchResult {
+	results := make([]models.BatchResult, 0, len(fileIDs))
+
+	for _, id := range fileIDs {
+		result := models.BatchResult{FileID: id}
+
+		f, err := h.accessibleFile(id, userID)
+		if err == nil {
+			err = op(f)
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.OK = true
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// relocateFile renames a file and moves it between the personal and shared spaces as needed.
+func (h *FileHandler) relocateFile(f *fileRecord, userID, newName string, shared bool) error {
+	if newName == f.Filename && shared == f.IsShared {
+		return nil
+	}
+
+	filename, err := internal.ResolveFileNameConflict(f.UserID, newName, shared, h.DB)
+	if err != nil {
+		return fmt.Errorf("could not resolve filename: %w", err)
+	}
+
+	dirPath := filepath.Join(h.Config.FilesDir, f.UserID)
+	if shared {
+		dirPath = filepath.Join(h.Config.FilesDir, h.Config.SharedDir)
+	}
+
+	newPath := filepath.Join(dirPath, filepath.FromSlash(filename))
+	if err := os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	if err := os.Rename(f.Path, newPath); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+
+	_, err = h.DB.Exec(`UPDATE metadata SET filename = ?, path = ?, is_shared = ? WHERE id = ?`, filename, newPath, shared, f.ID)
+	if err != nil {
+		// Put the file back so the row stays valid
+		if rerr := os.Rename(newPath, f.Path); rerr != nil {
+			internal.FileOps.Println("Error restoring moved file:", rerr)
+		}
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	internal.FileOps.Printf("User [%s] moved %s file %s to %s file %s", userID, spaceName(f.IsShared), f.Filename, spaceName(shared), filename)
+
+	event := internal.Event{Type: internal.EventFileRenamed, FileID: f.ID, Filename: filename, IsShared: shared, OwnerID: f.UserID, WasShared: f.IsShared}
+	if filename != f.Filename {
+		event.OldName = f.Filename
+	}
+	if shared != f.IsShared {
+		event.Type = internal.EventFileUnshared
+		if shared {
+			event.Type = internal.EventFileShared
+		}
+	}
+	internal.PublishEvent(event)
+
+	f.Filename, f.Path, f.IsShared = filename, newPath, shared
+	return nil
+}
+
+// folderFiles lists the files of a folder in the user's space or the shared space.
+// An empty folder selects the whole space.
+func (h *FileHandler) folderFiles(userID, folder string, shared bool) ([]*fileRecord, error) {
+	stmt := `SELECT id, user_id, filename, size, path, is_shared, uploaded_at, mime_type FROM metadata WHERE is_shared = ?`
+	args := []any{shared}
+
+	if !shared {
+		stmt += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+	if folder != "" {
+		stmt += ` AND filename LIKE ? ESCAPE '\'`
+		args = append(args, escapeLike(folder)+"/%")
+	}
+
+	rows, err := h.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*fileRecord
+	for rows.Next() {
+		var f fileRecord
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Filename, &f.Size, &f.Path, &f.IsShared, &f.UploadedAt, &f.MimeType); err != nil {
+			return nil, err
+		}
+		files = append(files, &f)
+	}
+
+	return files, rows.Err()
+}
+
+// cleanFolder validates a folder name and normalizes it to a slash separated relative path.
+func cleanFolder(folder string) (string, error) {
+	folder, err := internal.CleanParam(folder)
+	if err != nil {
+		return "", err
+	}
+
+	folder = strings.Trim(path.Clean("/"+filepath.ToSlash(folder)), "/")
+	return folder, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// archiveNames returns a unique entry name for every file, shared files go under shared/.
+func archiveNames(files []*fileRecord) []string {
+	names := make([]string, len(files))
+	used := make(map[string]bool, len(files))
+
+	for i, f := range files {
+		name := f.Filename
+		if f.IsShared {
+			name = path.Join("shared", name)
+		}
+
+		// Number clashes until the name is free, it may itself be the name of a later file
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for n := 1; used[name]; n++ {
+			name = fmt.Sprintf("%s(%d)%s", base, n, ext)
+		}
+		used[name] = true
+		names[i] = name
+	}
+
+	return names
+}
+
+func writeZipArchive(w io.Writer, files []*fileRecord) error {
+	zw := zip.NewWriter(w)
+	names := archiveNames(files)
+
+	for i, f := range files {
+		if err := addToZip(zw, f.Path, names[i]); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func addToZip(zw *zip.Writer, src, name string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Deflate
+
+	entry, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+func writeTarGzArchive(w io.Writer, files []*fileRecord) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	names := archiveNames(files)
+
+	for i, f := range files {
+		if err := addToTar(tw, f.Path, names[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addToTar(tw *tar.Writer, src, name string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(tw, file, info.Size())
+	return err
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/AumSahayata/cloudboxio/tests"
+	"github.com/gofiber/fiber/v2"
+)
+
+// insertTestFile writes a file for test-id and records its metadata.
+func insertTestFile(t *testing.T, ctx *TestContext, id int, filename, content string, shared bool) string {
+	t.Helper()
+
+	dir := filepath.Join(ctx.TempDir, "test-id")
+	if shared {
+		dir = filepath.Join(ctx.TempDir, "shared")
+	}
+	savePath := filepath.Join(dir, filepath.FromSlash(filename))
+	if err := os.MkdirAll(filepath.Dir(savePath), os.ModePerm); err != nil {
+		t.Fatal("failed to create dir:", err)
+	}
+	if err := os.WriteFile(savePath, []byte(content), 0644); err != nil {
+		t.Fatal("failed to write temp file:", err)
+	}
+
+	_, err := ctx.DB.Exec(`INSERT INTO metadata (id, user_id, filename, size, path, is_shared, uploaded_at) VALUES (?,?,?,?,?,?,?)`,
+		id, "test-id", filename, len(content), savePath, shared, "2025-06-01 10:00:00")
+	if err != nil {
+		t.Fatal("failed to insert temp file record in DB:", err)
+	}
+
+	return savePath
+}
+
+func doBatch(t *testing.T, ctx *TestContext, url string, req models.BatchRequest) []models.BatchResult {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest("POST", url, bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+	resp, err := ctx.App.Test(httpReq, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	var data struct {
+		Results []models.BatchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	return data.Results
+}
+
+func TestBatchDelete(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/files/delete", handler.BatchDelete)
+
+	p1 := insertTestFile(t, ctx, 1, "a.txt", "a", false)
+	p2 := insertTestFile(t, ctx, 2, "b.txt", "b", false)
+
+	results := doBatch(t, ctx, "/files/delete", models.BatchRequest{FileIDs: []string{"1", "2", "99"}})
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if !results[0].OK || !results[1].OK {
+		t.Errorf("expected existing files to be deleted: %+v", results)
+	}
+	if results[2].OK || results[2].Error == "" {
+		t.Errorf("expected missing file to report an error: %+v", results[2])
+	}
+
+	for _, p := range []string{p1, p2} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", p)
+		}
+	}
+
+	// Other users see shared files but cannot delete them
+	shared := insertTestFile(t, ctx, 3, "team.txt", "team", true)
+	if _, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES ('other-id', 'other', 'x', FALSE)`); err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := internal.GenerateToken("other-id", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		Results []models.BatchResult `json:"results"`
+	}
+	requestJSON(t, ctx.App, "POST", "/files/delete", otherToken, models.BatchRequest{FileIDs: []string{"3"}}, &data)
+	if len(data.Results) != 1 || data.Results[0].OK || data.Results[0].Error == "" {
+		t.Fatalf("expected a delete by another user to be refused, got %+v", data.Results)
+	}
+	if _, err := os.Stat(shared); err != nil {
+		t.Errorf("expected %s to stay: %v", shared, err)
+	}
+}
+
+func TestArchiveNamesAreUnique(t *testing.T) {
+	files := []*fileRecord{{Filename: "a.txt"}, {Filename: "a.txt"}, {Filename: "a(1).txt"}, {Filename: "a.txt", IsShared: true}}
+	names := archiveNames(files)
+
+	want := []string{"a.txt", "a(1).txt", "a(1)(1).txt", "shared/a.txt"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+	}
+}
+
+func TestBatchMoveAndShare(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/files/move", handler.BatchMove)
+	ctx.App.Post("/files/share", handler.BatchShare)
+
+	insertTestFile(t, ctx, 1, "report.txt", "report", false)
+
+	results := doBatch(t, ctx, "/files/move", models.BatchRequest{FileIDs: []string{"1"}, Folder: "reports/2025"})
+	if !results[0].OK {
+		t.Fatalf("move failed: %+v", results[0])
+	}
+
+	movedPath := filepath.Join(ctx.TempDir, "test-id", "reports", "2025", "report.txt")
+	if _, err := os.Stat(movedPath); err != nil {
+		t.Fatalf("expected file at %s: %v", movedPath, err)
+	}
+
+	results = doBatch(t, ctx, "/files/share", models.BatchRequest{FileIDs: []string{"1"}, Shared: true})
+	if !results[0].OK {
+		t.Fatalf("share failed: %+v", results[0])
+	}
+
+	var filename string
+	var shared bool
+	if err := ctx.DB.QueryRow(`SELECT filename, is_shared FROM metadata WHERE id = 1`).Scan(&filename, &shared); err != nil {
+		t.Fatal(err)
+	}
+	if filename != "reports/2025/report.txt" || !shared {
+		t.Errorf("unexpected metadata after move and share: %s shared=%v", filename, shared)
+	}
+
+	sharedPath := filepath.Join(ctx.TempDir, "shared", "reports", "2025", "report.txt")
+	if _, err := os.Stat(sharedPath); err != nil {
+		t.Errorf("expected file at %s: %v", sharedPath, err)
+	}
+
+	// Other users see the shared file but cannot move it
+	if _, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES ('other-id', 'other', 'x', FALSE)`); err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := internal.GenerateToken("other-id", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		Results []models.BatchResult `json:"results"`
+	}
+	requestJSON(t, ctx.App, "POST", "/files/move", otherToken, models.BatchRequest{FileIDs: []string{"1"}, Folder: "stolen"}, &data)
+	if len(data.Results) != 1 || data.Results[0].OK {
+		t.Fatalf("expected a move by another user to fail, got %+v", data.Results)
+	}
+	if _, err := os.Stat(sharedPath); err != nil {
+		t.Errorf("expected file to stay at %s: %v", sharedPath, err)
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/files/archive", handler.DownloadArchive)
+
+	insertTestFile(t, ctx, 1, "docs/one.txt", "one", false)
+	insertTestFile(t, ctx, 2, "docs/two.txt", "two", false)
+	insertTestFile(t, ctx, 3, "other.txt", "other", false)
+
+	req := httptest.NewRequest("GET", "/files/archive?folder=docs", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("response is not a zip archive: %v", err)
+	}
+
+	expected := map[string]string{"docs/one.txt": "one", "docs/two.txt": "two"}
+	if len(zr.File) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(zr.File))
+	}
+
+	for _, entry := range zr.File {
+		rc, err := entry.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+
+		if expected[entry.Name] != string(content) {
+			t.Errorf("unexpected entry %s with content %q", entry.Name, content)
+		}
+	}
+}
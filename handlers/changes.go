@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Actions recorded in the change journal.
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeDelete = "delete"
+)
+
+// maxChangesPage is the largest page of changes returned at once.
+const maxChangesPage = 1000
+
+// Changes returns the journal entries after ?since= that concern files the user can see.
+// Clients start with since=0, store the returned cursor and poll with it to stay in sync.
+func (h *FileHandler) Changes(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	since := int64(0)
+	if raw := c.Query("since"); raw != "" {
+		var err error
+		if since, err = strconv.ParseInt(raw, 10, 64); err != nil || since < 0 {
+			return NewAPIError(fiber.StatusBadRequest, "", "Cursor is not valid")
+		}
+	}
+
+	limit := c.QueryInt("limit", 500)
+	if limit <= 0 || limit > maxChangesPage {
+		limit = 500
+	}
+
+	// A shared file concerns everyone, including the change that unshares it
+	stmt := `SELECT seq, action, file_id, user_id, COALESCE(filename, ''), COALESCE(is_shared, FALSE), COALESCE(was_shared, FALSE),
+			COALESCE(size, 0), COALESCE(sha256, ''), modified_at, changed_at
+		FROM changes
+		WHERE seq > ? AND (user_id = ? OR is_shared = TRUE OR was_shared = TRUE)
+		ORDER BY seq
+		LIMIT ?`
+
+	rows, err := h.DB.Query(stmt, since, userID, limit+1)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query changes")
+	}
+	defer rows.Close()
+
+	list := models.ChangeList{Changes: make([]models.Change, 0), Cursor: strconv.FormatInt(since, 10)}
+	for rows.Next() {
+		if len(list.Changes) == limit {
+			list.HasMore = true
+			break
+		}
+
+		var change models.Change
+		var fileID int64
+		var ownerID sql.NullString
+		var wasShared bool
+		var modifiedAt sql.NullString
+		if err := rows.Scan(&change.Seq, &change.Action, &fileID, &ownerID, &change.Filename, &change.IsShared, &wasShared,
+			&change.Size, &change.SHA256, &modifiedAt, &change.ChangedAt); err != nil {
+			return NewAPIError(fiber.StatusInternalServerError, "", "Failed to read changes")
+		}
+
+		change.FileID = strconv.FormatInt(fileID, 10)
+		change.ModifiedAt = formatTimestamp(modifiedAt.String)
+
+		// Other users see a file appear when it is shared and disappear when it is unshared
+		if ownerID.String != userID && change.Action == changeUpdate && change.IsShared != wasShared {
+			if change.IsShared {
+				change.Action = changeCreate
+			} else {
+				change.Action = changeDelete
+			}
+		}
+
+		list.Changes = append(list.Changes, change)
+		list.Cursor = strconv.FormatInt(change.Seq, 10)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+// formatTimestamp converts an SQLite timestamp to RFC 3339, leaving other values untouched.
+func formatTimestamp(value string) string {
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return value
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fetchChanges reads one page of the change journal with the given token.
+func fetchChanges(t *testing.T, ctx *TestContext, token, query string) models.ChangeList {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/changes"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	var list models.ChangeList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal("failed to decode changes:", err)
+	}
+	return list
+}
+
+func TestChangeJournal(t *testing.T) {
+	ctx := SetupTestContext(t)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/upload:shared?", handler.UploadFile)
+	ctx.App.Post("/files/share", handler.BatchShare)
+	ctx.App.Delete("/file/:fileid", handler.DeleteFile)
+	ctx.App.Get("/changes", handler.Changes)
+
+	if _, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, 'x', FALSE)`, "other-id", "other"); err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := internal.GenerateToken("other-id", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload a personal file, its hash is recorded with the create
+	content := "sync me"
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("files", "notes.txt")
+	part.Write([]byte(content))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil || resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	// The test schema has no default upload time
+	if _, err := ctx.DB.Exec(`UPDATE metadata SET uploaded_at = ?`, "2025-06-01 10:00:00"); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	mine := fetchChanges(t, ctx, ctx.Token, "?since=0")
+	if len(mine.Changes) != 1 {
+		t.Fatalf("expected 1 change, got %+v", mine.Changes)
+	}
+	created := mine.Changes[0]
+	if created.Action != changeCreate || created.Filename != "notes.txt" || created.SHA256 != hex.EncodeToString(sum[:]) || mine.Cursor != "1" {
+		t.Fatalf("unexpected create entry: %+v cursor %s", created, mine.Cursor)
+	}
+
+	// Personal files are invisible to other users
+	theirs := fetchChanges(t, ctx, otherToken, "")
+	if len(theirs.Changes) != 0 || theirs.Cursor != "0" {
+		t.Fatalf("expected no visible changes for other user, got %+v", theirs)
+	}
+
+	// Sharing is an update for the owner and a create for everyone else
+	doBatch(t, ctx, "/files/share", models.BatchRequest{FileIDs: []string{created.FileID}, Shared: true})
+	doBatch(t, ctx, "/files/share", models.BatchRequest{FileIDs: []string{created.FileID}, Shared: false})
+
+	mine = fetchChanges(t, ctx, ctx.Token, "?since="+mine.Cursor)
+	if len(mine.Changes) != 2 || mine.Changes[0].Action != changeUpdate || !mine.Changes[0].IsShared || mine.Changes[1].Action != changeUpdate || mine.Changes[1].IsShared {
+		t.Fatalf("unexpected owner changes: %+v", mine.Changes)
+	}
+
+	theirs = fetchChanges(t, ctx, otherToken, "?since="+theirs.Cursor)
+	if len(theirs.Changes) != 2 || theirs.Changes[0].Action != changeCreate || theirs.Changes[1].Action != changeDelete {
+		t.Fatalf("unexpected changes for other user: %+v", theirs.Changes)
+	}
+
+	// Deleting the file records a delete that keeps the last known hash
+	req = httptest.NewRequest("DELETE", "/file/"+created.FileID, nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	if resp, err := ctx.App.Test(req, -1); err != nil || resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	mine = fetchChanges(t, ctx, ctx.Token, "?since="+mine.Cursor)
+	if len(mine.Changes) != 1 || mine.Changes[0].Action != changeDelete || mine.Changes[0].SHA256 != created.SHA256 {
+		t.Fatalf("unexpected delete entry: %+v", mine.Changes)
+	}
+
+	// Pages follow the cursor until the journal is exhausted
+	page := fetchChanges(t, ctx, ctx.Token, "?limit=2")
+	if len(page.Changes) != 2 || !page.HasMore {
+		t.Fatalf("expected a full first page, got %+v", page)
+	}
+	page = fetchChanges(t, ctx, ctx.Token, "?limit=2&since="+page.Cursor)
+	if len(page.Changes) != 2 || page.HasMore || page.Changes[1].Seq != 4 {
+		t.Fatalf("unexpected last page: %+v", page)
+	}
+
+	req = httptest.NewRequest("GET", "/changes?since=abc", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	if resp, err := ctx.App.Test(req, -1); err != nil || resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid cursor")
+	}
+}
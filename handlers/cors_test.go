@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/gofiber/fiber/v2"
+)
+
+// preflight sends a CORS preflight for method from origin and returns the response.
+func preflight(t *testing.T, app *fiber.App, target, origin, method string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest("OPTIONS", target, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	req.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORSPolicies(t *testing.T) {
+	ctx := SetupTestContext(t)
+	ctx.Config.CORS.AllowedOrigins = []string{"http://localhost:*", "https://*.example.com", `regex:https://dash-[0-9]+\.lan`}
+	ctx.Config.CORS.AllowCredentials = true
+	if err := ctx.Config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	corsHandler, err := internal.CORSMiddleware(ctx.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Use(corsHandler)
+	if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://localhost:5173", true},
+		{"https://dash.example.com", true},
+		{"https://DASH.example.com", true},
+		{"https://dash-42.lan", true},
+		{"https://example.com", false},
+		{"https://a.b.example.com", false},
+		{"https://dash.example.com.evil.net", false},
+		{"http://dash.example.com", false},
+		{"https://dash-x.lan", false},
+	}
+	for _, tc := range cases {
+		resp := preflight(t, app, "/api/v2/files", tc.origin, "DELETE")
+		got := resp.Header.Get("Access-Control-Allow-Origin")
+		if tc.allowed {
+			if resp.StatusCode != fiber.StatusNoContent || got != strings.ToLower(tc.origin) {
+				t.Errorf("%s: expected the origin to be allowed, got %d %q", tc.origin, resp.StatusCode, got)
+			}
+			if resp.Header.Get("Access-Control-Allow-Credentials") != "true" || !strings.Contains(resp.Header.Get("Access-Control-Allow-Methods"), "DELETE") {
+				t.Errorf("%s: unexpected preflight headers %v", tc.origin, resp.Header)
+			}
+			if resp.Header.Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("%s: expected the preflight to be cacheable, got %q", tc.origin, resp.Header.Get("Access-Control-Max-Age"))
+			}
+		} else if got != "" {
+			t.Errorf("%s: expected the origin to be refused, got %q", tc.origin, got)
+		}
+	}
+
+	// Actual requests carry the allowed origin and the exposed headers
+	req := httptest.NewRequest("GET", "/api/v2/files", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	resp, _ := app.Test(req, -1)
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "https://dash.example.com" ||
+		!strings.Contains(resp.Header.Get("Access-Control-Expose-Headers"), "X-Request-ID") {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// The public endpoints can be read from anywhere, without credentials
+	resp = preflight(t, app, "/api/v2/openapi.json", "https://unknown.example.net", "GET")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "*" || resp.Header.Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("unexpected public preflight headers %v", resp.Header)
+	}
+	if methods := resp.Header.Get("Access-Control-Allow-Methods"); strings.Contains(methods, "POST") {
+		t.Fatalf("public policy allows %q", methods)
+	}
+	resp = preflight(t, app, "/api/login", "https://unknown.example.net", "POST")
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected the API policy for login, got origin %q", got)
+	}
+}
+
+func TestCORSDefaultsAndValidation(t *testing.T) {
+	cfg := internal.DefaultConfig()
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	corsHandler, err := internal.CORSMiddleware(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Use(corsHandler)
+	app.Get("/api/files", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	// Without configured origins the server's own address is allowed under both names
+	for _, origin := range []string{"http://127.0.0.1:3000", "http://localhost:3000"} {
+		if got := preflight(t, app, "/api/files", origin, "GET").Header.Get("Access-Control-Allow-Origin"); got != origin {
+			t.Errorf("%s: expected the default origin to be allowed, got %q", origin, got)
+		}
+	}
+	if got := preflight(t, app, "/api/files", "http://localhost:8080", "GET").Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected another port to be refused, got %q", got)
+	}
+
+	invalid := []func(cfg *internal.Config){
+		func(cfg *internal.Config) { cfg.CORS.AllowedOrigins = []string{"*"}; cfg.CORS.AllowCredentials = true },
+		func(cfg *internal.Config) { cfg.CORS.AllowedOrigins = []string{"regex:https://(unclosed"} },
+		func(cfg *internal.Config) { cfg.CORS.AllowedOrigins = []string{"dash.example.com"} },
+		func(cfg *internal.Config) { cfg.CORS.AllowedMethods = []string{"get"} },
+		func(cfg *internal.Config) { cfg.PublicCORS.Paths = []string{"share"} },
+	}
+	for i, change := range invalid {
+		cfg := internal.DefaultConfig()
+		change(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("case %d: expected the configuration to be rejected", i)
+		}
+	}
+
+	// Origins that do not compile are reported instead of crashing the server
+	cfg = internal.DefaultConfig()
+	cfg.PublicCORS.AllowedOrigins = []string{"regex:https://(unclosed"}
+	if _, err := internal.CORSMiddleware(cfg); err == nil {
+		t.Error("expected invalid public origins to be refused")
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes of the v2 API. Clients should branch on the code, the message is for people.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRateLimited          = "rate_limited"
+	CodeInternal             = "internal_error"
+	CodeUnavailable          = "unavailable"
+)
+
+// APIError is a typed error handlers can return. ErrorHandler turns it into the response.
+type APIError struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewAPIError returns an error for status. An empty code is derived from the status.
+func NewAPIError(status int, code, message string) *APIError {
+	if code == "" {
+		code = statusCode(status)
+	}
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// WithDetails returns a copy of e carrying extra machine readable information.
+func (e *APIError) WithDetails(details any) *APIError {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// statusCode returns the error code for an HTTP status.
+func statusCode(status int) string {
+	switch status {
+	case fiber.StatusBadRequest:
+		return CodeBadRequest
+	case fiber.StatusUnauthorized:
+		return CodeUnauthorized
+	case fiber.StatusForbidden:
+		return CodeForbidden
+	case fiber.StatusNotFound:
+		return CodeNotFound
+	case fiber.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case fiber.StatusConflict:
+		return CodeConflict
+	case fiber.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case fiber.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case fiber.StatusTooManyRequests:
+		return CodeRateLimited
+	case fiber.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+
+	if status >= 500 {
+		return CodeInternal
+	}
+	if text := http.StatusText(status); text != "" {
+		return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+	}
+	return CodeBadRequest
+}
+
+// toAPIError maps any error returned by a handler to an APIError.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return NewAPIError(fiberErr.Code, "", fiberErr.Message)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, os.ErrNotExist) {
+		return NewAPIError(fiber.StatusNotFound, CodeNotFound, "Not found")
+	}
+
+	// Internal details stay in the log
+	return NewAPIError(fiber.StatusInternalServerError, CodeInternal, "Internal server error")
+}
+
+// isV2 reports whether the request is served by the v2 API. Requests rejected by server
+// wide middleware, such as the per IP rate limit, never reach ErrorEnvelope, so the path decides.
+func isV2(c *fiber.Ctx) bool {
+	if version, _ := c.Locals("api_version").(int); version >= 2 {
+		return true
+	}
+	return c.Path() == "/api/v2" || strings.HasPrefix(c.Path(), "/api/v2/")
+}
+
+// ErrorHandler is the Fiber ErrorHandler of the server. It writes the v2 error envelope for
+// /api/v2 and the original {"error": message} body everywhere else.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	apiErr := toAPIError(err)
+
+	if apiErr.Status >= 500 && internal.Error != nil {
+		requestID, _ := c.Locals("request_id").(string)
+		internal.Error.Printf("Request [%s] %s %s failed: %v", requestID, c.Method(), c.Path(), err)
+	}
+
+	if isV2(c) {
+		return writeAPIError(c, apiErr)
+	}
+
+	// Details given as a map are sent next to the message, the way the original API did
+	body := fiber.Map{}
+	if details, ok := apiErr.Details.(fiber.Map); ok {
+		for key, value := range details {
+			body[key] = value
+		}
+	}
+	body["error"] = apiErr.Message
+	return c.Status(apiErr.Status).JSON(body)
+}
+
+// writeAPIError sends apiErr wrapped in the v2 envelope {"error": {...}}.
+func writeAPIError(c *fiber.Ctx, apiErr *APIError) error {
+	body := *apiErr
+	body.RequestID, _ = c.Locals("request_id").(string)
+	return c.Status(body.Status).JSON(fiber.Map{"error": body})
+}
+
+// ErrorEnvelope marks the routes after it as the v2 API. Errors returned by handlers are
+// written by ErrorHandler in the v2 envelope.
+func ErrorEnvelope() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("api_version", 2)
+
+		if err := c.Next(); err != nil {
+			return ErrorHandler(c, err)
+		}
+		return nil
+	}
+}
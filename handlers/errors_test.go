@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type errorEnvelope struct {
+	Error struct {
+		Code      string         `json:"code"`
+		Message   string         `json:"message"`
+		Details   map[string]any `json:"details"`
+		RequestID string         `json:"request_id"`
+	} `json:"error"`
+}
+
+// decodeEnvelope checks the status of a v2 error response and returns its body.
+func decodeEnvelope(t *testing.T, resp *http.Response, status int) errorEnvelope {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("expected status %d, got %d", status, resp.StatusCode)
+	}
+	var body errorEnvelope
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal("error response is not JSON:", err)
+	}
+	if body.Error.RequestID == "" || body.Error.RequestID != resp.Header.Get("X-Request-ID") {
+		t.Fatalf("expected the request ID %q in the body, got %q", resp.Header.Get("X-Request-ID"), body.Error.RequestID)
+	}
+	return body
+}
+
+func TestV2ErrorEnvelope(t *testing.T) {
+	ctx := SetupTestContext(t)
+	if err := RegisterAPI(ctx.App, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing token is 401 on both versions, /api keeps its original body
+	req := httptest.NewRequest("GET", "/api/v2/files", nil)
+	resp, _ := ctx.App.Test(req, -1)
+	body := decodeEnvelope(t, resp, fiber.StatusUnauthorized)
+	if body.Error.Code != CodeUnauthorized || body.Error.Message != "Missing token" {
+		t.Fatalf("unexpected error %+v", body.Error)
+	}
+
+	req = httptest.NewRequest("GET", "/api/files", nil)
+	resp, _ = ctx.App.Test(req, -1)
+	var legacy map[string]string
+	json.NewDecoder(resp.Body).Decode(&legacy)
+	if resp.StatusCode != fiber.StatusUnauthorized || legacy["error"] != "Missing token" {
+		t.Fatalf("expected the original error on /api, got %d %v", resp.StatusCode, legacy)
+	}
+
+	// Validation errors keep their message and the client's request ID is echoed
+	req = httptest.NewRequest("GET", "/api/v2/files?sort=bogus", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	req.Header.Set("X-Request-ID", "trace-123")
+	resp, _ = ctx.App.Test(req, -1)
+	body = decodeEnvelope(t, resp, fiber.StatusBadRequest)
+	if body.Error.Code != CodeBadRequest || body.Error.RequestID != "trace-123" || body.Error.Message == "" {
+		t.Fatalf("unexpected error %+v", body.Error)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v2/file/999", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	resp, _ = ctx.App.Test(req, -1)
+	if body = decodeEnvelope(t, resp, fiber.StatusNotFound); body.Error.Code != CodeNotFound {
+		t.Fatalf("unexpected error %+v", body.Error)
+	}
+
+	// Successful responses are untouched
+	req = httptest.NewRequest("GET", "/api/v2/files", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	resp, _ = ctx.App.Test(req, -1)
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get("X-Request-ID") == "" {
+		t.Fatalf("unexpected response %d with request ID %q", resp.StatusCode, resp.Header.Get("X-Request-ID"))
+	}
+}
+
+func TestErrorHandlerMapsTypedErrors(t *testing.T) {
+	internal.Error = log.New(io.Discard, "", 0)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	v2 := app.Group("/v2", internal.RequestID(), ErrorEnvelope())
+	routes := map[string]error{
+		"/typed":    NewAPIError(fiber.StatusConflict, "name_taken", "Name is taken").WithDetails(fiber.Map{"name": "a.txt"}),
+		"/fiber":    fiber.NewError(fiber.StatusRequestEntityTooLarge, "Upload is too large"),
+		"/no-rows":  sql.ErrNoRows,
+		"/internal": errors.New("disk on fire"),
+	}
+	for path, err := range routes {
+		err := err
+		v2.Get(path, func(c *fiber.Ctx) error { return err })
+		app.Get(path, func(c *fiber.Ctx) error { return err })
+	}
+
+	cases := []struct {
+		path    string
+		status  int
+		code    string
+		message string
+	}{
+		{"/typed", fiber.StatusConflict, "name_taken", "Name is taken"},
+		{"/fiber", fiber.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Upload is too large"},
+		{"/no-rows", fiber.StatusNotFound, CodeNotFound, "Not found"},
+		{"/internal", fiber.StatusInternalServerError, CodeInternal, "Internal server error"},
+	}
+	for _, tc := range cases {
+		resp, _ := app.Test(httptest.NewRequest("GET", "/v2"+tc.path, nil), -1)
+		body := decodeEnvelope(t, resp, tc.status)
+		if body.Error.Code != tc.code || body.Error.Message != tc.message {
+			t.Errorf("%s: unexpected error %+v", tc.path, body.Error)
+		}
+
+		// Outside v2 the same error keeps the original body
+		resp, _ = app.Test(httptest.NewRequest("GET", tc.path, nil), -1)
+		var legacy map[string]string
+		json.NewDecoder(resp.Body).Decode(&legacy)
+		if resp.StatusCode != tc.status || legacy["error"] != tc.message {
+			t.Errorf("%s: unexpected original error %d %v", tc.path, resp.StatusCode, legacy)
+		}
+	}
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/v2/typed", nil), -1)
+	if body := decodeEnvelope(t, resp, fiber.StatusConflict); body.Error.Details["name"] != "a.txt" {
+		t.Fatalf("expected details to be sent, got %+v", body.Error.Details)
+	}
+
+	// The original API sends them next to the message
+	resp, _ = app.Test(httptest.NewRequest("GET", "/typed", nil), -1)
+	var legacy map[string]string
+	json.NewDecoder(resp.Body).Decode(&legacy)
+	if legacy["name"] != "a.txt" || legacy["error"] != "Name is taken" {
+		t.Fatalf("expected details next to the message, got %v", legacy)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type EventHandler struct {
+	DB *sql.DB
+	// Heartbeat is how often an idle stream sends a comment to detect closed connections.
+	Heartbeat time.Duration
+}
+
+func NewEventHandler(db *sql.DB) *EventHandler {
+	return &EventHandler{DB: db, Heartbeat: 15 * time.Second}
+}
+
+// Stream sends the events the user may see as Server-Sent Events until the client disconnects.
+// The account is checked again on every heartbeat, and the stream ends once the user is
+// deleted, disabled or no longer an admin.
+func (h *EventHandler) Stream(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	events, unsubscribe := internal.SubscribeEvents()
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		ticker := time.NewTicker(h.Heartbeat)
+		defer ticker.Stop()
+
+		// Tell the client the stream is live and how long to wait before reconnecting
+		fmt.Fprint(w, "retry: 3000\n: connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					// The server is shutting down
+					return
+				}
+				if !e.VisibleTo(userID, isAdmin) {
+					continue
+				}
+
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			case <-ticker.C:
+				if !h.stillAllowed(userID, isAdmin) {
+					return
+				}
+				fmt.Fprint(w, ": ping\n\n")
+			}
+
+			// A failed flush means the client went away
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// stillAllowed reports whether the user may keep a stream opened with the given admin role.
+func (h *EventHandler) stillAllowed(userID string, wasAdmin bool) bool {
+	var isAdmin, disabled bool
+	err := h.DB.QueryRow(`SELECT is_admin, disabled FROM users WHERE id = ?`, userID).Scan(&isAdmin, &disabled)
+	if err != nil || disabled {
+		return false
+	}
+	return isAdmin || !wasAdmin
+}
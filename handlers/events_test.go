@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+)
+
+// openEventStream connects to the SSE endpoint and returns a channel of received events.
+func openEventStream(t *testing.T, addr, token string) <-chan internal.Event {
+	t.Helper()
+
+	resp, err := http.Get("http://" + addr + "/events?token=" + token)
+	if err != nil {
+		t.Fatal("failed to open event stream:", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("unexpected stream response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+
+	// The connected comment confirms the subscription is registered
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal("stream closed before it was ready:", err)
+		}
+		if strings.HasPrefix(line, ": connected") {
+			break
+		}
+	}
+
+	events := make(chan internal.Event, 16)
+	go func() {
+		defer close(events)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+				var e internal.Event
+				if json.Unmarshal([]byte(data), &e) == nil {
+					events <- e
+				}
+			}
+		}
+	}()
+
+	return events
+}
+
+// nextEvent waits for the next event on a stream, or returns false after a short timeout.
+func nextEvent(events <-chan internal.Event) (internal.Event, bool) {
+	select {
+	case e, ok := <-events:
+		return e, ok
+	case <-time.After(500 * time.Millisecond):
+		return internal.Event{}, false
+	}
+}
+
+func TestEventStreamFiltersByVisibility(t *testing.T) {
+	ctx := SetupTestContext(t)
+
+	if _, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, 'x', FALSE)`, "other-id", "other"); err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := internal.GenerateToken("other-id", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertTestFile(t, ctx, 1, "private.txt", "mine", false)
+
+	fileHandler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use("/events", internal.TokenFromQuery())
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/events", NewEventHandler(ctx.DB).Stream)
+	ctx.App.Delete("/file/:fileid", fileHandler.DeleteFile)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ctx.App.Listener(ln)
+	// Runs before the context cleanup shuts the app down
+	t.Cleanup(internal.CloseEvents)
+
+	adminEvents := openEventStream(t, ln.Addr().String(), ctx.Token)
+	otherEvents := openEventStream(t, ln.Addr().String(), otherToken)
+
+	// A personal file of the admin is only seen by the admin
+	req, _ := http.NewRequest("DELETE", "http://"+ln.Addr().String()+"/file/1", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("delete failed:", err)
+	}
+	resp.Body.Close()
+
+	if e, ok := nextEvent(adminEvents); !ok || e.Type != internal.EventFileDeleted || e.Filename != "private.txt" {
+		t.Errorf("expected file.deleted for admin, got %+v (%v)", e, ok)
+	}
+
+	// Shared files are seen by everyone, user events only by admins
+	internal.PublishEvent(internal.Event{Type: internal.EventFileCreated, FileID: 2, Filename: "team.txt", IsShared: true, OwnerID: "test-id"})
+	internal.PublishEvent(internal.Event{Type: internal.EventUserCreated, Username: "newbie", UserID: "new-id"})
+
+	if e, ok := nextEvent(otherEvents); !ok || e.Type != internal.EventFileCreated || e.Filename != "team.txt" {
+		t.Errorf("expected the shared file to be the first event for the other user, got %+v (%v)", e, ok)
+	}
+	if e, ok := nextEvent(otherEvents); ok {
+		t.Errorf("other user received an event they may not see: %+v", e)
+	}
+
+	if e, ok := nextEvent(adminEvents); !ok || e.Type != internal.EventFileCreated {
+		t.Errorf("expected file.created for admin, got %+v (%v)", e, ok)
+	}
+	if e, ok := nextEvent(adminEvents); !ok || e.Type != internal.EventUserCreated || e.Username != "newbie" {
+		t.Errorf("expected user.created for admin, got %+v (%v)", e, ok)
+	}
+}
+
+func TestEventStreamEndsWhenAccessIsRevoked(t *testing.T) {
+	ctx := SetupTestContext(t)
+	// The streams query the database in the background
+	ctx.DB.SetMaxOpenConns(1)
+
+	for _, id := range []string{"gone-id", "off-id"} {
+		if _, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, 'x', FALSE)`, id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := NewEventHandler(ctx.DB)
+	handler.Heartbeat = 50 * time.Millisecond
+	ctx.App.Use("/events", internal.TokenFromQuery())
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/events", handler.Stream)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ctx.App.Listener(ln)
+	t.Cleanup(internal.CloseEvents)
+
+	streams := make(map[string]<-chan internal.Event)
+	for _, id := range []string{"test-id", "gone-id", "off-id"} {
+		token, err := internal.GenerateToken(id, id == "test-id", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams[id] = openEventStream(t, ln.Addr().String(), token)
+	}
+
+	for _, stmt := range []string{
+		`UPDATE users SET is_admin = FALSE WHERE id = 'test-id'`,
+		`DELETE FROM users WHERE id = 'gone-id'`,
+		`UPDATE users SET disabled = TRUE WHERE id = 'off-id'`,
+	} {
+		if _, err := ctx.DB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, events := range streams {
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Errorf("%s: expected the stream to end, got an event", id)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s: stream still open after access was revoked", id)
+		}
+	}
+}
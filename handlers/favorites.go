@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Actions recorded in the recents feed.
+const (
+	activityUpload   = "upload"
+	activityDownload = "download"
+	activityView     = "view"
+)
+
+// maxRecentFiles is how many recent files are kept per user.
+const maxRecentFiles = 100
+
+func (h *FileHandler) StarFile(c *fiber.Ctx) error {
+	return h.setFavorite(c, true)
+}
+
+func (h *FileHandler) UnstarFile(c *fiber.Ctx) error {
+	return h.setFavorite(c, false)
+}
+
+func (h *FileHandler) setFavorite(c *fiber.Ctx, starred bool) error {
+	userID := c.Locals("user_id").(string)
+
+	fileID, err := internal.CleanParam(c.Params("fileid"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "File ID provided is not proper")
+	}
+
+	file, err := h.accessibleFile(fileID, userID)
+	if err != nil {
+		if errors.Is(err, errFileNotFound) {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	// Both directions are idempotent so the UI can simply toggle
+	if starred {
+		_, err = h.DB.Exec(`INSERT OR IGNORE INTO favorites (user_id, file_id) VALUES (?, ?)`, userID, file.ID)
+	} else {
+		_, err = h.DB.Exec(`DELETE FROM favorites WHERE user_id = ? AND file_id = ?`, userID, file.ID)
+	}
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update favorites")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"file_id": fileID, "starred": starred})
+}
+
+func (h *FileHandler) ListFavorites(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	// Files that are no longer visible to the user, e.g. unshared by their owner, drop out of the list
+	stmt := `SELECT md.id, md.filename, md.size, md.uploaded_at,
+			CASE WHEN md.is_shared THEN COALESCE(u.username, '') ELSE 'Me' END,
+			md.mime_type, md.description, md.is_shared, f.created_at
+		FROM favorites AS f
+		JOIN metadata AS md ON md.id = f.file_id
+		LEFT JOIN users AS u ON md.user_id = u.id
+		WHERE f.user_id = ? AND (md.is_shared = TRUE OR md.user_id = ?)
+		ORDER BY f.created_at DESC, f.file_id DESC`
+
+	rows, err := h.DB.Query(stmt, userID, userID)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query favorites")
+	}
+	defer rows.Close()
+
+	favorites := make([]models.FavoriteFile, 0)
+	for rows.Next() {
+		var fav models.FavoriteFile
+		if err := rows.Scan(&fav.FileID, &fav.Filename, &fav.Size, &fav.UploadedAt, &fav.UploadedBy, &fav.MimeType, &fav.Description, &fav.IsShared, &fav.StarredAt); err != nil {
+			continue
+		}
+		fav.Starred = true
+		favorites = append(favorites, fav)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(favorites)
+}
+
+func (h *FileHandler) ListRecent(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > maxRecentFiles {
+		limit = 20
+	}
+
+	stmt := `SELECT md.id, md.filename, md.size, md.uploaded_at,
+			CASE WHEN md.is_shared THEN COALESCE(u.username, '') ELSE 'Me' END,
+			md.mime_type, md.description, md.is_shared, r.action, r.accessed_at,
+			EXISTS (SELECT 1 FROM favorites AS f WHERE f.user_id = r.user_id AND f.file_id = md.id)
+		FROM recent_files AS r
+		JOIN metadata AS md ON md.id = r.file_id
+		LEFT JOIN users AS u ON md.user_id = u.id
+		WHERE r.user_id = ? AND (md.is_shared = TRUE OR md.user_id = ?)
+		ORDER BY r.accessed_at DESC
+		LIMIT ?`
+
+	rows, err := h.DB.Query(stmt, userID, userID, limit)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query recent files")
+	}
+	defer rows.Close()
+
+	recent := make([]models.RecentFile, 0)
+	for rows.Next() {
+		var r models.RecentFile
+		if err := rows.Scan(&r.FileID, &r.Filename, &r.Size, &r.UploadedAt, &r.UploadedBy, &r.MimeType, &r.Description, &r.IsShared, &r.Action, &r.AccessedAt, &r.Starred); err != nil {
+			continue
+		}
+		recent = append(recent, r)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(recent)
+}
+
+// recordActivity moves a file to the top of the user's recents feed.
+// Failures only cost a feed entry, so they are logged and never fail the request.
+func (h *FileHandler) recordActivity(userID string, fileID int64, action string) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05.000000")
+
+	_, err := h.DB.Exec(`INSERT INTO recent_files (user_id, file_id, action, accessed_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, file_id) DO UPDATE SET action = excluded.action, accessed_at = excluded.accessed_at`,
+		userID, fileID, action, now)
+	if err == nil {
+		_, err = h.DB.Exec(`DELETE FROM recent_files WHERE user_id = ? AND file_id NOT IN (
+			SELECT file_id FROM recent_files WHERE user_id = ? ORDER BY accessed_at DESC LIMIT ?)`,
+			userID, userID, maxRecentFiles)
+	}
+	if err != nil {
+		internal.FileOps.Println("Error recording recent file:", err)
+	}
+}
+
+// attachFavorites marks the files of a listed page the user has starred.
+func (h *FileHandler) attachFavorites(userID string, files []models.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*models.File, len(files))
+	placeholders := make([]string, 0, len(files))
+	args := []any{userID}
+	for i := range files {
+		byID[files[i].FileID] = &files[i]
+		placeholders = append(placeholders, "?")
+		args = append(args, files[i].FileID)
+	}
+
+	rows, err := h.DB.Query(`SELECT file_id FROM favorites WHERE user_id = ? AND file_id IN (`+strings.Join(placeholders, ",")+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if f, ok := byID[id]; ok {
+			f.Starred = true
+		}
+	}
+
+	return rows.Err()
+}
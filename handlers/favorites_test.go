@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFavoritesAndRecent(t *testing.T) {
+	ctx := SetupTestContext(t)
+
+	insertTestFile(t, ctx, 1, "a.txt", "alpha", false)
+	insertTestFile(t, ctx, 2, "b.txt", "bravo", false)
+	insertTestFile(t, ctx, 3, "c.txt", "charlie", true)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/favorites", handler.ListFavorites)
+	ctx.App.Get("/recent", handler.ListRecent)
+	ctx.App.Get("/files:keyword?:shared?", handler.ListFiles)
+	ctx.App.Get("/file/:fileid", handler.DownloadFile)
+	ctx.App.Get("/file/:fileid/preview", handler.Preview)
+	ctx.App.Put("/file/:fileid/favorite", handler.StarFile)
+	ctx.App.Delete("/file/:fileid/favorite", handler.UnstarFile)
+
+	send := func(method, url string, out any) int {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		defer resp.Body.Close()
+
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatalf("%s %s: failed to decode response: %v", method, url, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	// Starring twice is harmless
+	for _, url := range []string{"/file/1/favorite", "/file/3/favorite", "/file/3/favorite"} {
+		if status := send("PUT", url, nil); status != fiber.StatusOK {
+			t.Fatalf("PUT %s: expected status %d, got %d", url, fiber.StatusOK, status)
+		}
+	}
+	if status := send("PUT", "/file/99/favorite", nil); status != fiber.StatusNotFound {
+		t.Errorf("expected status %d for unknown file, got %d", fiber.StatusNotFound, status)
+	}
+
+	var favorites []models.FavoriteFile
+	send("GET", "/favorites", &favorites)
+	if len(favorites) != 2 {
+		t.Fatalf("expected 2 favorites, got %+v", favorites)
+	}
+
+	var files []models.File
+	send("GET", "/files", &files)
+	for _, f := range files {
+		if f.Starred != (f.Filename == "a.txt") {
+			t.Errorf("unexpected starred flag on %s: %v", f.Filename, f.Starred)
+		}
+	}
+
+	send("DELETE", "/file/1/favorite", nil)
+	favorites = nil
+	send("GET", "/favorites", &favorites)
+	if len(favorites) != 1 || favorites[0].Filename != "c.txt" || !favorites[0].IsShared {
+		t.Errorf("expected only c.txt after unstar, got %+v", favorites)
+	}
+
+	// Downloads and views feed the recents list, newest first and one entry per file
+	send("GET", "/file/1", nil)
+	send("GET", "/file/3/preview", nil)
+	send("GET", "/file/2", nil)
+	send("GET", "/file/1/preview", nil)
+
+	var recent []models.RecentFile
+	send("GET", "/recent", &recent)
+	if len(recent) != 3 {
+		t.Fatalf("expected 3 recent files, got %+v", recent)
+	}
+	if recent[0].Filename != "a.txt" || recent[0].Action != "view" || recent[1].Filename != "b.txt" || recent[1].Action != "download" {
+		t.Errorf("unexpected recent order: %+v", recent)
+	}
+	if !recent[2].Starred {
+		t.Errorf("expected c.txt to be marked as starred in recents")
+	}
+
+	// Deleted files leave both lists
+	if _, err := ctx.DB.Exec(`DELETE FROM metadata WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+	favorites, recent = nil, nil
+	send("GET", "/favorites", &favorites)
+	send("GET", "/recent", &recent)
+	if len(favorites) != 0 || len(recent) != 2 {
+		t.Errorf("expected deleted file to leave quick access, got %d favorites and %d recent", len(favorites), len(recent))
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/AumSahayata/cloudboxio/internal"
 	"github.com/AumSahayata/cloudboxio/models"
@@ -14,27 +19,63 @@ import (
 )
 
 type FileHandler struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Config   *internal.Config
+	Settings *internal.SettingsStore
+	// Bandwidth caps downloads per user, nil leaves them unlimited
+	Bandwidth *internal.BandwidthLimiter
 }
 
-func NewFileHandler(database *sql.DB) *FileHandler {
-	return &FileHandler{DB: database}
+func NewFileHandler(database *sql.DB, cfg *internal.Config, settings *internal.SettingsStore) *FileHandler {
+	return &FileHandler{DB: database, Config: cfg, Settings: settings}
 }
 
 func (h *FileHandler) UploadFile(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
 	isShared := c.QueryBool("shared", false)
 
-	fileDir := os.Getenv("FILES_DIR")
-	sharedDir := os.Getenv("SHARED_DIR")
+	fileDir := h.Config.FilesDir
+	sharedDir := h.Config.SharedDir
 
 	//Get files from form
 	form, err := c.MultipartForm()
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "File is required"})
+		return NewAPIError(fiber.StatusBadRequest, "", "File is required")
 	}
 
 	files := form.File["files"]
+	settings := h.Settings.Get()
+
+	var uploadSize int64
+	for _, file := range files {
+		uploadSize += file.Size
+	}
+	if uploadSize > int64(settings.MaxUploadSizeMB)<<20 {
+		return NewAPIError(fiber.StatusRequestEntityTooLarge, "", fmt.Sprintf("Uploads are limited to %d MB", settings.MaxUploadSizeMB))
+	}
+
+	if settings.DefaultQuotaMB > 0 {
+		var used int64
+		if err := h.DB.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM metadata WHERE user_id = ?`, userID).Scan(&used); err != nil {
+			return NewAPIError(fiber.StatusInternalServerError, "", "Could not check storage quota")
+		}
+		if used+uploadSize > settings.DefaultQuotaMB<<20 {
+			return NewAPIError(fiber.StatusInsufficientStorage, "", fmt.Sprintf("Storage quota of %d MB exceeded", settings.DefaultQuotaMB))
+		}
+	}
+
+	// Detect every content type up front so a refused file rejects the whole upload before anything is stored
+	mimeTypes := make([]string, len(files))
+	for i, file := range files {
+		mimeType, err := sniffUpload(file)
+		if err != nil {
+			return NewAPIError(fiber.StatusBadRequest, "", "Could not read uploaded file")
+		}
+		if !settings.IsAllowedMIMEType(mimeType) {
+			return NewAPIError(fiber.StatusUnsupportedMediaType, "", fmt.Sprintf("File type %s is not allowed: %s", mimeType, file.Filename))
+		}
+		mimeTypes[i] = mimeType
+	}
 
 	// Create shared folder if not exists
 	dirPath := filepath.Join(fileDir, sharedDir)
@@ -50,11 +91,11 @@ func (h *FileHandler) UploadFile(c *fiber.Ctx) error {
 		}
 	}
 
-	for _, file := range files {
+	for i, file := range files {
 
 		filename, err := internal.ResolveFileNameConflict(userID, file.Filename, isShared, h.DB)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not resolve filename"})
+			return NewAPIError(fiber.StatusInternalServerError, "", "Could not resolve filename")
 		}
 
 		// Save file to user-specific directory
@@ -63,11 +104,25 @@ func (h *FileHandler) UploadFile(c *fiber.Ctx) error {
 			return fmt.Errorf("failed to save the file: %w", err)
 		}
 
+		// A missing hash is filled in by the backfill on the next start
+		sum, err := internal.HashFile(savePath)
+		if err != nil {
+			internal.Error.Printf("Failed to hash uploaded file %s: %v", savePath, err)
+		}
+
 		// Insert metadata into SQLite DB
-		stmt := `INSERT INTO metadata (user_id, filename, size, path, is_shared) VALUES (?, ?, ?, ?, ?);`
-		_, err = h.DB.Exec(stmt, userID, filename, file.Size, savePath, isShared)
+		stmt := `INSERT INTO metadata (user_id, filename, size, path, is_shared, mime_type, sha256) VALUES (?, ?, ?, ?, ?, ?, ?);`
+		res, err := h.DB.Exec(stmt, userID, filename, file.Size, savePath, isShared, mimeTypes[i], sum)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save metadata"})
+			return NewAPIError(fiber.StatusInternalServerError, "", "Failed to save metadata")
+		}
+
+		// Index the contents and render thumbnails in the background so uploads are not slowed down
+		if fileID, err := res.LastInsertId(); err == nil {
+			internal.QueueIndex(fileID)
+			internal.QueueDefaultThumbnail(fileDir, fileID, savePath)
+			h.recordActivity(userID, fileID, activityUpload)
+			internal.PublishEvent(internal.Event{Type: internal.EventFileCreated, FileID: fileID, Filename: filename, IsShared: isShared, OwnerID: userID})
 		}
 
 		fileType := "personal"
@@ -86,109 +141,137 @@ func (h *FileHandler) UploadFile(c *fiber.Ctx) error {
 func (h *FileHandler) ListFiles(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
 	isShared := c.QueryBool("shared", false)
-	keyword := c.Query("keyword")
-
-	var (
-		stmt *sql.Stmt
-		rows *sql.Rows
-		err  error
-	)
 
-	keyword, err = internal.CleanParam(keyword)
+	q, err := parseFileListQuery(c, userID, isShared)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Keyword provided is not proper"})
-	}
-
-	keyword = "%" + keyword + "%"
-
-	if keyword == "" {
-		if isShared {
-			stmt, err = h.DB.Prepare(`SELECT md.id, md.filename, md.size, md.uploaded_at, u.username FROM metadata AS md JOIN users AS u ON md.user_id = u.id WHERE md.is_shared = TRUE`)
-		} else {
-			stmt, err = h.DB.Prepare(`SELECT id, filename, size, uploaded_at, "Me" FROM metadata WHERE user_id = ? AND is_shared = FALSE`)
-		}
-	} else {
-		if isShared {
-			stmt, err = h.DB.Prepare(`SELECT md.id, md.filename, md.size, md.uploaded_at, u.username FROM metadata AS md JOIN users AS u ON md.user_id = u.id WHERE md.is_shared = TRUE AND md.filename LIKE ?`)
-		} else {
-			stmt, err = h.DB.Prepare(`SELECT id, filename, size, uploaded_at, "Me" FROM metadata WHERE user_id = ? AND is_shared = FALSE AND filename LIKE ?`)
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			return fe
 		}
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid query")
 	}
 
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to prepare query"})
+	// Total number of matching files regardless of paging
+	var total int
+	stmt, args := q.countSQL()
+	if err := h.DB.QueryRow(stmt, args...).Scan(&total); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query files")
 	}
-	defer stmt.Close()
 
-	// Query the database for the metadata
-	if keyword == "" {
-		if isShared {
-			rows, err = stmt.Query()
-		} else {
-			rows, err = stmt.Query(userID)
-		}
-	} else {
-		if isShared {
-			rows, err = stmt.Query(keyword)
-		} else {
-			rows, err = stmt.Query(userID, keyword)
-		}
+	uploadedBy := `'Me'`
+	if isShared {
+		uploadedBy = `COALESCE(u.username, '')`
 	}
 
+	// Query the database for the metadata
+	stmt, args = q.pageSQL(`md.id, md.filename, md.size, md.uploaded_at, ` + uploadedBy + `, md.mime_type, md.description, md.sha256`)
+	rows, err := h.DB.Query(stmt, args...)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to query files"})
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query files")
 	}
 	defer rows.Close()
 
 	fileList := make([]models.File, 0)
+	var last listCursor
+	hasMore := false
 
 	// Use rows to iterate over the metadata
 	for rows.Next() {
-		var fileID string
-		var filename string
-		var size int64
-		var uploadedAt string
-		var uploadedBy string
+		if q.limit > 0 && len(fileList) == q.limit {
+			hasMore = true
+			break
+		}
 
-		if err := rows.Scan(&fileID, &filename, &size, &uploadedAt, &uploadedBy); err != nil {
+		var file models.File
+		var cursor listCursor
+		if err := rows.Scan(&file.FileID, &file.Filename, &file.Size, &file.UploadedAt, &file.UploadedBy, &file.MimeType, &file.Description, &file.SHA256, &cursor.ID, &cursor.Value); err != nil {
 			continue
 		}
 
-		fileList = append(fileList, models.File{
-			FileID:     fileID,
-			Filename:   filename,
-			Size:       size,
-			UploadedAt: uploadedAt,
-			UploadedBy: uploadedBy,
-		})
+		fileList = append(fileList, file)
+		last = cursor
+	}
+
+	rows.Close()
+
+	if err := h.attachTags(fileList); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query file tags")
+	}
+	if err := h.attachFavorites(userID, fileList); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query favorites")
+	}
+
+	// Paging details travel in headers so the body keeps its array shape
+	c.Set("X-Total-Count", strconv.Itoa(total))
+	if hasMore {
+		c.Set("X-Next-Cursor", encodeListCursor(last))
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fileList)
 }
 
 func (h *FileHandler) DownloadFile(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
 	// Get file name from the endpoint parameters using request context
 	fileID := c.Params("fileid")
 	fileID, err := internal.CleanParam(fileID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "File ID provided is not proper"})
+		return NewAPIError(fiber.StatusBadRequest, "", "File ID provided is not proper")
 	}
 
 	// Find the full file path
-	var path string
-
-	row := h.DB.QueryRow(`SELECT path FROM metadata WHERE id = ? LIMIT 1`, fileID)
-	if err := row.Scan(&path); err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found or access denied"})
+	file, err := h.accessibleFile(fileID, userID)
+	if err != nil {
+		return NewAPIError(fiber.StatusNotFound, "", "File not found or access denied")
 	}
 
 	// Check if file exists
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+	if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+		return NewAPIError(fiber.StatusNotFound, "", "File not found")
+	}
+
+	mimeType := file.MimeType
+	if mimeType == "" {
+		// Stored before content types were recorded and not backfilled yet
+		if mimeType, err = internal.DetectMIMETypeFile(file.Path); err != nil {
+			mimeType = "application/octet-stream"
+		}
+	}
+
+	// Only types that cannot run scripts are shown in the browser, everything else is downloaded
+	disposition, action := "attachment", activityDownload
+	if c.QueryBool("inline", false) && internal.IsInlineSafe(mimeType) {
+		disposition, action = "inline", activityView
+	}
+
+	if h.Bandwidth.DownloadLimited() {
+		// Stream the file through the limiter instead of letting fasthttp send it directly
+		f, err := os.Open(file.Path)
+		if err != nil {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return NewAPIError(fiber.StatusInternalServerError, "", "Could not read file")
+		}
+		c.Status(fiber.StatusOK).Response().SetBodyStream(h.Bandwidth.Reader(userID, f), int(info.Size()))
+	} else if err := c.SendFile(file.Path); err != nil {
+		return err
 	}
 
-	// Send the file as a response
-	return c.Status(fiber.StatusOK).Download(path)
+	contentType := mimeType
+	if strings.HasPrefix(mimeType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": filepath.Base(file.Filename)}))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+
+	h.recordActivity(userID, file.ID, action)
+
+	return nil
 }
 
 func (h *FileHandler) DeleteFile(c *fiber.Ctx) error {
@@ -198,44 +281,97 @@ func (h *FileHandler) DeleteFile(c *fiber.Ctx) error {
 	fileID := c.Params("fileid")
 	fileID, err := internal.CleanParam(fileID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "File ID provided is not proper"})
+		return NewAPIError(fiber.StatusBadRequest, "", "File ID provided is not proper")
 	}
 
 	// Find the full file path and share status
-	var shared bool
-	var path string
-	var filename string
+	file, err := h.accessibleFile(fileID, userID)
+	if err != nil {
+		if errors.Is(err, errFileNotFound) {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	if err := h.removeFile(file, userID); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to delete file")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
 
-	row := h.DB.QueryRow(`SELECT filename, is_shared, path FROM metadata WHERE id = ? LIMIT 1`, fileID)
-	if err = row.Scan(&filename, &shared, &path); err != nil {
+var errFileNotFound = errors.New("file not found")
+
+// fileRecord is a row of the metadata table.
+type fileRecord struct {
+	ID         int64
+	UserID     string
+	Filename   string
+	Size       int64
+	Path       string
+	IsShared   bool
+	UploadedAt string
+	MimeType   string
+}
+
+// accessibleFile loads a file the user may act on: their own personal files and every shared file.
+func (h *FileHandler) accessibleFile(fileID, userID string) (*fileRecord, error) {
+	var f fileRecord
+
+	row := h.DB.QueryRow(`SELECT id, user_id, filename, size, path, is_shared, uploaded_at, mime_type FROM metadata WHERE id = ? AND (is_shared = TRUE OR user_id = ?) LIMIT 1`, fileID, userID)
+	if err := row.Scan(&f.ID, &f.UserID, &f.Filename, &f.Size, &f.Path, &f.IsShared, &f.UploadedAt, &f.MimeType); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+			return nil, errFileNotFound
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch file metadata"})
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// removeFile deletes the metadata row and then the file on disk.
+// Dropping the row first means a crash in between leaves an orphan for fsck instead of a dangling row.
+func (h *FileHandler) removeFile(f *fileRecord, userID string) error {
+	if _, err := h.DB.Exec(`DELETE FROM metadata WHERE id = ?`, f.ID); err != nil {
+		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 
 	// Deletes the file from the disk
-	if err = os.Remove(path); err != nil {
+	if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
 		internal.FileOps.Println("Error deleting file:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete file"})
+		return fmt.Errorf("failed to delete file: %w", err)
 	}
 
-	// Deletes the metadata of the file
-	if shared {
-		_, err = h.DB.Exec(`DELETE FROM metadata WHERE id = ? AND is_shared = ?`, fileID, true)
-	} else {
-		_, err = h.DB.Exec(`DELETE FROM metadata WHERE id = ? AND user_id = ? AND is_shared = ?`, fileID, userID, false)
+	// Cached thumbnails are only an optimisation, a failure here is not worth reporting
+	internal.RemoveThumbnails(h.Config.FilesDir, f.ID)
+
+	internal.FileOps.Printf("User [%s] deleted %s file: %s", userID, spaceName(f.IsShared), f.Filename)
+	internal.PublishEvent(internal.Event{Type: internal.EventFileDeleted, FileID: f.ID, Filename: f.Filename, IsShared: f.IsShared, OwnerID: f.UserID})
+
+	return nil
+}
+
+// spaceName returns the label used in file operation logs.
+func spaceName(isShared bool) string {
+	if isShared {
+		return "shared"
 	}
+	return "personal"
+}
+
+// sniffUpload detects the content type of an uploaded file from its first bytes.
+func sniffUpload(file *multipart.FileHeader) (string, error) {
+	f, err := file.Open()
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete metadata"})
+		return "", err
 	}
+	defer f.Close()
 
-	fileType := "personal"
-	if shared {
-		fileType = "shared"
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
 	}
 
-	internal.FileOps.Printf("User [%s] deleted %s file: %s", userID, fileType, filename)
-
-	return c.Status(fiber.StatusNoContent).JSON(fiber.Map{"message": "File deleted successfully"})
+	return internal.DetectMIMEType(file.Filename, head[:n]), nil
 }
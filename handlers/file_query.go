@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPageSize caps the limit query parameter of ListFiles.
+const maxPageSize = 1000
+
+// sortColumns maps the sort query parameter to the expression used for ordering and paging.
+var sortColumns = map[string]string{
+	"":      "md.id",
+	"name":  "COALESCE(md.filename, '')",
+	"size":  "COALESCE(md.size, 0)",
+	"date":  "COALESCE(md.uploaded_at, '')",
+	"owner": "COALESCE(u.username, '')",
+}
+
+// numericSorts lists the sort expressions compared as integers.
+var numericSorts = map[string]bool{
+	"md.id":                true,
+	"COALESCE(md.size, 0)": true,
+}
+
+// listCursor marks the last row of a page. Value holds the sort column of that row.
+type listCursor struct {
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// fileListQuery is the SQL built from the ListFiles query parameters.
+type fileListQuery struct {
+	where   []string
+	args    []any
+	sortCol string
+	desc    bool
+	limit   int
+	cursor  *listCursor
+}
+
+// parseFileListQuery turns the ListFiles query parameters into a filtered query.
+func parseFileListQuery(c *fiber.Ctx, userID string, isShared bool) (*fileListQuery, error) {
+	q := &fileListQuery{}
+
+	if isShared {
+		q.add("md.is_shared = TRUE")
+	} else {
+		q.add("md.user_id = ? AND md.is_shared = FALSE", userID)
+	}
+
+	if keyword := c.Query("keyword"); keyword != "" {
+		keyword, err := internal.CleanParam(keyword)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Keyword provided is not proper")
+		}
+		q.add("md.filename LIKE ?", "%"+keyword+"%")
+	}
+
+	// Extension filter, e.g. ext=pdf,docx
+	if exts := splitList(c.Query("ext")); len(exts) > 0 {
+		q.addExtensions(exts)
+	}
+
+	// MIME type filter on the detected content type, e.g. type=image or type=application/pdf,text/*
+	if types := splitList(c.Query("type")); len(types) > 0 {
+		if err := q.addMIMETypes(types); err != nil {
+			return nil, err
+		}
+	}
+
+	// Tag filter, files must carry every tag, e.g. tag=invoices,2025
+	if v := c.Query("tag"); v != "" {
+		tags, err := normalizeTags(splitList(v))
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			q.add("md.id IN (SELECT file_id FROM file_tags WHERE tag = ?)", tag)
+		}
+	}
+
+	if v := c.Query("min_size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "min_size must be a non-negative number of bytes")
+		}
+		q.add("md.size >= ?", size)
+	}
+
+	if v := c.Query("max_size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "max_size must be a non-negative number of bytes")
+		}
+		q.add("md.size <= ?", size)
+	}
+
+	if v := c.Query("from"); v != "" {
+		from, err := parseListDate(v, false)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+		}
+		q.add("md.uploaded_at >= ?", from)
+	}
+
+	if v := c.Query("to"); v != "" {
+		to, err := parseListDate(v, true)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+		}
+		q.add("md.uploaded_at <= ?", to)
+	}
+
+	if uploader := c.Query("uploader"); uploader != "" {
+		q.add("u.username = ?", uploader)
+	}
+
+	sortCol, ok := sortColumns[c.Query("sort")]
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "sort must be one of name, size, date or owner")
+	}
+	q.sortCol = sortCol
+
+	switch c.Query("order", "asc") {
+	case "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, fiber.NewError(fiber.StatusBadRequest, "order must be asc or desc")
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		}
+		q.limit = limit
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := decodeListCursor(v)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Cursor provided is not proper")
+		}
+		q.cursor = cursor
+	}
+
+	return q, nil
+}
+
+func (q *fileListQuery) add(cond string, args ...any) {
+	q.where = append(q.where, cond)
+	q.args = append(q.args, args...)
+}
+
+func (q *fileListQuery) addExtensions(exts []string) {
+	conds := make([]string, 0, len(exts))
+	args := make([]any, 0, len(exts))
+	for _, ext := range exts {
+		conds = append(conds, "LOWER(md.filename) LIKE ? ESCAPE '\\'")
+		args = append(args, "%."+escapeLike(strings.ToLower(strings.TrimPrefix(ext, "."))))
+	}
+	q.add("("+strings.Join(conds, " OR ")+")", args...)
+}
+
+func (q *fileListQuery) addMIMETypes(types []string) error {
+	conds := make([]string, 0, len(types))
+	args := make([]any, 0, len(types))
+	for _, t := range types {
+		t = strings.ToLower(t)
+		major, minor, hasMinor := strings.Cut(t, "/")
+		if major == "" || strings.ContainsAny(t, "%_\\") || (hasMinor && minor == "") {
+			return fiber.NewError(fiber.StatusBadRequest, "Unknown file type")
+		}
+
+		// A bare family such as "image" or a wildcard such as "image/*" matches every subtype
+		if !hasMinor || minor == "*" {
+			conds = append(conds, "md.mime_type LIKE ?")
+			args = append(args, major+"/%")
+			continue
+		}
+		conds = append(conds, "md.mime_type = ?")
+		args = append(args, t)
+	}
+	q.add("("+strings.Join(conds, " OR ")+")", args...)
+	return nil
+}
+
+// from returns the FROM clause shared by the count and the page query.
+func (q *fileListQuery) from() string {
+	return ` FROM metadata AS md LEFT JOIN users AS u ON md.user_id = u.id WHERE ` + strings.Join(q.where, " AND ")
+}
+
+// countSQL returns the statement counting every row matching the filters.
+func (q *fileListQuery) countSQL() (string, []any) {
+	return `SELECT COUNT(*)` + q.from(), q.args
+}
+
+// pageSQL returns the statement for one page, starting after the cursor.
+func (q *fileListQuery) pageSQL(columns string) (string, []any) {
+	stmt := `SELECT ` + columns + `, md.id, ` + q.sortCol + q.from()
+	args := append([]any{}, q.args...)
+
+	dir, cmp := "ASC", ">"
+	if q.desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if q.cursor != nil {
+		value := any(q.cursor.Value)
+		if numericSorts[q.sortCol] {
+			n, _ := strconv.ParseInt(q.cursor.Value, 10, 64)
+			value = n
+		}
+		stmt += fmt.Sprintf(` AND (%[1]s %[2]s ? OR (%[1]s = ? AND md.id %[2]s ?))`, q.sortCol, cmp)
+		args = append(args, value, value, q.cursor.ID)
+	}
+
+	stmt += fmt.Sprintf(` ORDER BY %s %s, md.id %s`, q.sortCol, dir, dir)
+
+	if q.limit > 0 {
+		// Fetch one extra row to know whether another page exists
+		stmt += ` LIMIT ?`
+		args = append(args, q.limit+1)
+	}
+
+	return stmt, args
+}
+
+func encodeListCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// parseListDate accepts a date or an RFC 3339 timestamp and formats it like uploaded_at.
+// A bare date used as an upper bound covers the whole day.
+func parseListDate(v string, endOfDay bool) (string, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Format("2006-01-02 15:04:05"), nil
+	}
+
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return "", err
+	}
+	if endOfDay {
+		return t.Format("2006-01-02") + " 23:59:59", nil
+	}
+	return t.Format("2006-01-02 15:04:05"), nil
+}
+
+// splitList splits a comma separated query parameter, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -21,8 +21,8 @@ func TestUploadFiles(t *testing.T) {
 	ctx := SetupTestContext(t)
 	tests.SetAdminSetupFlag(ctx.DB, true)
 
-	handler := NewFileHandler(ctx.DB)
-	ctx.App.Use(internal.JWTProtected())
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Post("/upload:shared?", handler.UploadFile)
 
 	// Create multipart body
@@ -96,8 +96,8 @@ func TestSharedUploadFiles(t *testing.T) {
 	ctx := SetupTestContext(t)
 	tests.SetAdminSetupFlag(ctx.DB, true)
 
-	handler := NewFileHandler(ctx.DB)
-	ctx.App.Use(internal.JWTProtected())
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Post("/upload:shared?", handler.UploadFile)
 
 	// Create multipart body
@@ -196,8 +196,8 @@ func TestListMyFiles(t *testing.T) {
 	}
 
 	// Register handler
-	handler := NewFileHandler(ctx.DB)
-	ctx.App.Use(internal.JWTProtected())
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Get("/files:shared?", handler.ListFiles)
 
 	// Make request
@@ -273,8 +273,8 @@ func TestListSharedFiles(t *testing.T) {
 	}
 
 	// Register handler
-	handler := NewFileHandler(ctx.DB)
-	ctx.App.Use(internal.JWTProtected())
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Get("/files:shared?", handler.ListFiles)
 
 	// Make request
@@ -321,14 +321,103 @@ func TestListSharedFiles(t *testing.T) {
 	}
 }
 
+func TestListFilesPaginated(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	files := []struct {
+		id       int
+		filename string
+		size     int
+	}{
+		{1, "a.txt", 300},
+		{2, "b.pdf", 100},
+		{3, "c.txt", 500},
+		{4, "d.txt", 200},
+		{5, "e.txt", 400},
+	}
+
+	for _, f := range files {
+		_, err := ctx.DB.Exec(`
+			INSERT INTO metadata (id, user_id, filename, size, path, is_shared, uploaded_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			f.id, "test-id", f.filename, f.size, "/path/"+f.filename, false, "2025-06-01 10:00:00")
+		if err != nil {
+			t.Fatalf("failed to insert file %s: %v", f.filename, err)
+		}
+	}
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/files", handler.ListFiles)
+
+	fetch := func(query string) ([]models.File, string, string) {
+		req := httptest.NewRequest("GET", "/files?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+
+		var result []models.File
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+
+		return result, resp.Header.Get("X-Total-Count"), resp.Header.Get("X-Next-Cursor")
+	}
+
+	// Walk all .txt files by size descending, two per page
+	var names []string
+	query := "ext=txt&sort=size&order=desc&limit=2"
+	for page := 0; ; page++ {
+		result, total, next := fetch(query)
+		if total != "4" {
+			t.Fatalf("expected total count 4, got %q", total)
+		}
+		for _, f := range result {
+			names = append(names, f.Filename)
+		}
+		if next == "" {
+			break
+		}
+		if page > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		query = "ext=txt&sort=size&order=desc&limit=2&cursor=" + next
+	}
+
+	expected := []string{"c.txt", "e.txt", "a.txt", "d.txt"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	}
+
+	// Size range filter
+	result, total, _ := fetch("min_size=150&max_size=350")
+	if total != "2" || len(result) != 2 {
+		t.Errorf("expected 2 files between 150 and 350 bytes, got %d (total %s)", len(result), total)
+	}
+}
+
 // Does not exits the test_storage (temp dir for testing) hence throws an error
 
 // func TestDownloadFile(t *testing.T) {
 // 	ctx := SetupTestContext(t)
 // 	tests.SetAdminSetupFlag(ctx.DB, true)
 
-// 	handler := NewFileHandler(ctx.DB)
-// 	ctx.App.Use(internal.JWTProtected())
+// 	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+// 	ctx.App.Use(internal.JWTProtected(ctx.DB))
 // 	ctx.App.Get("/file/:fileid", handler.DownloadFile)
 
 // 	// Create a dummy file
@@ -380,8 +469,8 @@ func TestDeleteFile(t *testing.T) {
 	ctx := SetupTestContext(t)
 	tests.SetAdminSetupFlag(ctx.DB, true)
 
-	handler := NewFileHandler(ctx.DB)
-	ctx.App.Use(internal.JWTProtected())
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
 	ctx.App.Delete("/file/:fileid", handler.DeleteFile)
 
 	// Create a dummy file
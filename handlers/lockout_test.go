@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/AumSahayata/cloudboxio/tests"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginLockout(t *testing.T) {
+	ctx := SetupTestContext(t)
+	if _, err := ctx.Settings.Update([]byte(`{
+		"login_rate_limit": {"enabled": false, "max": 10, "expiration_seconds": 60},
+		"lockout": {"enabled": true, "free_attempts": 1, "delay_seconds": 1, "max_failures": 3, "lockout_seconds": 60}
+	}`)); err != nil {
+		t.Fatal("failed to update settings:", err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(username, password string) (*http.Response, time.Duration) {
+		body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		req := httptest.NewRequest("POST", "/api/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		start := time.Now()
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		return resp, time.Since(start)
+	}
+	expect := func(resp *http.Response, status int, step string) {
+		t.Helper()
+		if resp.StatusCode != status {
+			t.Fatalf("%s: expected status %d, got %d", step, status, resp.StatusCode)
+		}
+	}
+	expireBlocks := func() {
+		if _, err := ctx.DB.Exec(`UPDATE login_failures SET blocked_until = 0`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first failure is free, the second blocks even the right password for a second
+	resp, wrongTime := login("testuser", "wrong")
+	expect(resp, fiber.StatusUnauthorized, "first failure")
+	resp, _ = login("testuser", "wrong")
+	expect(resp, fiber.StatusUnauthorized, "second failure")
+	resp, _ = login("testuser", "securepass")
+	expect(resp, fiber.StatusTooManyRequests, "login while delayed")
+	if resp.Header.Get(fiber.HeaderRetryAfter) != "1" {
+		t.Errorf("expected Retry-After 1, got %q", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+
+	expireBlocks()
+	resp, _ = login("testuser", "securepass")
+	expect(resp, fiber.StatusOK, "login after the delay")
+
+	// Unknown usernames are counted and answered like existing ones, in about the same time
+	if _, err := ctx.DB.Exec(`DELETE FROM login_failures`); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		resp, unknownTime := login("ghost", "wrong")
+		expect(resp, fiber.StatusUnauthorized, "unknown user")
+		if i == 0 && unknownTime < wrongTime/2 {
+			t.Errorf("unknown user refused in %v, a wrong password in %v", unknownTime, wrongTime)
+		}
+		if i < 2 {
+			expireBlocks()
+		}
+	}
+	resp, _ = login("ghost", "wrong")
+	expect(resp, fiber.StatusTooManyRequests, "unknown user locked out")
+	if resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	// The client IP failed as often, so it is locked out for every account
+	resp, _ = login("testuser", "securepass")
+	expect(resp, fiber.StatusTooManyRequests, "login from a locked out IP")
+
+	// Admins can end the lockout of an account and of an IP
+	var ip string
+	if err := ctx.DB.QueryRow(`SELECT substr(key, 4) FROM login_failures WHERE key LIKE 'ip:%'`).Scan(&ip); err != nil {
+		t.Fatal("IP failures were not counted:", err)
+	}
+	if _, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, 'x', FALSE)`, "user-id", "regular"); err != nil {
+		t.Fatal(err)
+	}
+	userToken, err := internal.GenerateToken("user-id", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock := func(token, target string) int {
+		req := httptest.NewRequest("DELETE", target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := unlock(userToken, "/api/users/test-id/lockout?ip="+ip); status != fiber.StatusForbidden {
+		t.Fatalf("expected status %d for a regular user, got %d", fiber.StatusForbidden, status)
+	}
+	if status := unlock(ctx.Token, "/api/users/missing/lockout"); status != fiber.StatusNotFound {
+		t.Fatalf("expected status %d for an unknown user, got %d", fiber.StatusNotFound, status)
+	}
+	if status := unlock(ctx.Token, "/api/users/test-id/lockout?ip="+ip); status != fiber.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", fiber.StatusNoContent, status)
+	}
+	resp, _ = login("testuser", "securepass")
+	expect(resp, fiber.StatusOK, "login after unlock")
+
+	// The history lists every attempt of the account, newest first
+	history := func(token, target string) ([]models.LoginAttempt, int) {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		defer resp.Body.Close()
+		var attempts []models.LoginAttempt
+		json.NewDecoder(resp.Body).Decode(&attempts)
+		return attempts, resp.StatusCode
+	}
+	attempts, status := history(ctx.Token, "/api/v2/users/test-id/logins")
+	want := []string{internal.LoginSucceeded, internal.LoginLocked, internal.LoginSucceeded, internal.LoginLocked, internal.LoginFailed, internal.LoginFailed}
+	// The login of the test setup comes first
+	if status != fiber.StatusOK || len(attempts) < len(want) {
+		t.Fatalf("unexpected history %d %+v", status, attempts)
+	}
+	for i, result := range want {
+		if attempts[i].Result != result || attempts[i].IP != ip || attempts[i].CreatedAt == "" {
+			t.Errorf("attempt %d: expected %s from %s, got %+v", i, result, ip, attempts[i])
+		}
+	}
+	if attempts, _ := history(ctx.Token, "/api/users/test-id/logins?result=failure&limit=1"); len(attempts) != 1 || attempts[0].Result != internal.LoginFailed {
+		t.Errorf("expected the latest failure, got %+v", attempts)
+	}
+	if _, status := history(userToken, "/api/users/test-id/logins"); status != fiber.StatusForbidden {
+		t.Errorf("expected status %d for the history of another user, got %d", fiber.StatusForbidden, status)
+	}
+	// A right password refused because the admin is not set up yet neither counts as a
+	// success nor resets the failures
+	hash, _ := bcrypt.GenerateFromPassword([]byte("regularpass"), bcrypt.MinCost)
+	if _, err := ctx.DB.Exec(`UPDATE users SET password = ? WHERE id = 'user-id'`, string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	tests.SetAdminSetupFlag(ctx.DB, false)
+	accountFailures := func() int {
+		var n int
+		ctx.DB.QueryRow(`SELECT COUNT(*) FROM login_failures WHERE key LIKE 'account:%'`).Scan(&n)
+		return n
+	}
+	before := accountFailures()
+	resp, _ = login("regular", "wrong")
+	expect(resp, fiber.StatusUnauthorized, "wrong password before setup")
+	resp, _ = login("regular", "regularpass")
+	expect(resp, fiber.StatusUnauthorized, "login before setup")
+
+	if after := accountFailures(); after != before+1 {
+		t.Errorf("expected the failure of the refused account to be kept, got %d account rows instead of %d", after, before+1)
+	}
+	attempts, _ = history(ctx.Token, "/api/users/user-id/logins")
+	for _, attempt := range attempts {
+		if attempt.Result == internal.LoginSucceeded {
+			t.Errorf("refused login recorded as a success: %+v", attempts)
+		}
+	}
+
+	// The lockout belongs to the account, renaming it does not end the lockout
+	tests.SetAdminSetupFlag(ctx.DB, true)
+	resp, _ = login("regular", "wrong")
+	expect(resp, fiber.StatusUnauthorized, "second wrong password")
+	if _, err := ctx.DB.Exec(`UPDATE login_failures SET blocked_until = blocked_until + 60 WHERE key = ?`, internal.AccountFailureKey("user-id")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.DB.Exec(`DELETE FROM login_failures WHERE key LIKE 'ip:%'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.DB.Exec(`UPDATE users SET username = 'renamed' WHERE id = 'user-id'`); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = login("renamed", "regularpass")
+	expect(resp, fiber.StatusTooManyRequests, "login after a rename")
+}
+
+func TestLockoutDelay(t *testing.T) {
+	cfg := internal.LockoutConfig{Enabled: true, FreeAttempts: 3, DelaySeconds: 2, MaxFailures: 8, LockoutSeconds: 30}
+	for failures, want := range []int{0, 0, 0, 0, 2, 4, 8, 16, 30, 30} {
+		if got := cfg.Delay(failures); got != time.Duration(want)*time.Second {
+			t.Errorf("%d failures: expected %ds, got %v", failures, want, got)
+		}
+	}
+	if got := (internal.LockoutConfig{FreeAttempts: 0, DelaySeconds: 3600, MaxFailures: 1000, LockoutSeconds: 86400}).Delay(999); got != 24*time.Hour {
+		t.Errorf("expected the delay to stop at the lockout, got %v", got)
+	}
+
+	invalid := []internal.LockoutConfig{
+		{Enabled: true, FreeAttempts: -1, DelaySeconds: 1, MaxFailures: 3, LockoutSeconds: 60},
+		{Enabled: true, FreeAttempts: 3, DelaySeconds: 1, MaxFailures: 3, LockoutSeconds: 60},
+		{Enabled: true, FreeAttempts: 1, DelaySeconds: 0, MaxFailures: 3, LockoutSeconds: 60},
+		{Enabled: true, FreeAttempts: 1, DelaySeconds: 120, MaxFailures: 3, LockoutSeconds: 60},
+	}
+	for i, cfg := range invalid {
+		if cfg.Validate() == nil {
+			t.Errorf("case %d: expected %+v to be rejected", i, cfg)
+		}
+	}
+	if (internal.LockoutConfig{}).Validate() != nil {
+		t.Error("expected disabled settings to be accepted")
+	}
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on user supplied metadata.
+const (
+	maxTagsPerFile       = 50
+	maxTagLength         = 50
+	maxPropertiesPerFile = 50
+	maxPropertyKeyLength = 64
+	maxPropertyValueLen  = 1024
+	maxDescriptionLength = 4096
+)
+
+func (h *FileHandler) GetMeta(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	fileID, err := internal.CleanParam(c.Params("fileid"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "File ID provided is not proper")
+	}
+
+	file, err := h.accessibleFile(fileID, userID)
+	if err != nil {
+		if errors.Is(err, errFileNotFound) {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	meta, err := h.fileMeta(file.ID)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(meta)
+}
+
+func (h *FileHandler) UpdateMeta(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	fileID, err := internal.CleanParam(c.Params("fileid"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "File ID provided is not proper")
+	}
+
+	var req models.MetaUpdate
+	if err := c.BodyParser(&req); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
+	}
+
+	if req.Description != nil && utf8.RuneCountInString(*req.Description) > maxDescriptionLength {
+		return NewAPIError(fiber.StatusBadRequest, "", fmt.Sprintf("Description must be at most %d characters", maxDescriptionLength))
+	}
+
+	var tags []string
+	if req.Tags != nil {
+		if tags, err = normalizeTags(*req.Tags); err != nil {
+			return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+		}
+	}
+
+	for key, value := range req.Properties {
+		if err := validateProperty(key, value); err != nil {
+			return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+		}
+	}
+
+	file, err := h.accessibleFile(fileID, userID)
+	if err != nil {
+		if errors.Is(err, errFileNotFound) {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	if err := h.applyMetaUpdate(file.ID, req.Description, tags, req.Tags != nil, req.Properties); err != nil {
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			return fe
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update file metadata")
+	}
+
+	internal.FileOps.Printf("User [%s] updated metadata of %s file: %s", userID, spaceName(file.IsShared), file.Filename)
+	internal.PublishEvent(internal.Event{Type: internal.EventFileUpdated, FileID: file.ID, Filename: file.Filename, IsShared: file.IsShared, OwnerID: file.UserID})
+
+	meta, err := h.fileMeta(file.ID)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(meta)
+}
+
+func (h *FileHandler) BatchTag(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	req, err := parseBatchRequest(c)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+
+	add, err := normalizeTags(req.Tags)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+	remove, err := normalizeTags(req.RemoveTags)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return NewAPIError(fiber.StatusBadRequest, "", "At least one tag to add or remove is required")
+	}
+
+	results := h.forEachFile(req.FileIDs, userID, func(f *fileRecord) error {
+		if err := h.retagFile(f.ID, add, remove); err != nil {
+			return err
+		}
+		internal.PublishEvent(internal.Event{Type: internal.EventFileUpdated, FileID: f.ID, Filename: f.Filename, IsShared: f.IsShared, OwnerID: f.UserID})
+		return nil
+	})
+
+	internal.FileOps.Printf("User [%s] tagged %d file(s)", userID, len(req.FileIDs))
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"results": results})
+}
+
+// fileMeta loads the description, tags and properties of a file.
+func (h *FileHandler) fileMeta(fileID int64) (*models.FileMeta, error) {
+	meta := &models.FileMeta{
+		FileID:     fmt.Sprint(fileID),
+		Tags:       make([]string, 0),
+		Properties: make(map[string]string),
+	}
+
+	if err := h.DB.QueryRow(`SELECT description FROM metadata WHERE id = ?`, fileID).Scan(&meta.Description); err != nil {
+		return nil, err
+	}
+
+	rows, err := h.DB.Query(`SELECT tag FROM file_tags WHERE file_id = ? ORDER BY tag`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		meta.Tags = append(meta.Tags, tag)
+	}
+	rows.Close()
+
+	rows, err = h.DB.Query(`SELECT key, value FROM file_properties WHERE file_id = ?`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		meta.Properties[key] = value
+	}
+
+	return meta, rows.Err()
+}
+
+// applyMetaUpdate writes a metadata update in one transaction.
+func (h *FileHandler) applyMetaUpdate(fileID int64, description *string, tags []string, replaceTags bool, properties map[string]*string) error {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if description != nil {
+		if _, err := tx.Exec(`UPDATE metadata SET description = ? WHERE id = ?`, strings.TrimSpace(*description), fileID); err != nil {
+			return err
+		}
+	}
+
+	if replaceTags {
+		if _, err := tx.Exec(`DELETE FROM file_tags WHERE file_id = ?`, fileID); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if _, err := tx.Exec(`INSERT INTO file_tags (file_id, tag) VALUES (?, ?)`, fileID, tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key, value := range properties {
+		if value == nil {
+			_, err = tx.Exec(`DELETE FROM file_properties WHERE file_id = ? AND key = ?`, fileID, key)
+		} else {
+			_, err = tx.Exec(`INSERT INTO file_properties (file_id, key, value) VALUES (?, ?, ?)
+				ON CONFLICT (file_id, key) DO UPDATE SET value = excluded.value`, fileID, key, *value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM file_properties WHERE file_id = ?`, fileID).Scan(&count); err != nil {
+		return err
+	}
+	if count > maxPropertiesPerFile {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("A file can have at most %d properties", maxPropertiesPerFile))
+	}
+
+	return tx.Commit()
+}
+
+// retagFile adds and removes tags on one file, keeping it within the tag limit.
+func (h *FileHandler) retagFile(fileID int64, add, remove []string) error {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, tag := range remove {
+		if _, err := tx.Exec(`DELETE FROM file_tags WHERE file_id = ? AND tag = ?`, fileID, tag); err != nil {
+			return err
+		}
+	}
+	for _, tag := range add {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO file_tags (file_id, tag) VALUES (?, ?)`, fileID, tag); err != nil {
+			return err
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM file_tags WHERE file_id = ?`, fileID).Scan(&count); err != nil {
+		return err
+	}
+	if count > maxTagsPerFile {
+		return fmt.Errorf("a file can have at most %d tags", maxTagsPerFile)
+	}
+
+	return tx.Commit()
+}
+
+// attachTags fills in the tags of a page of listed files with a single query.
+func (h *FileHandler) attachTags(files []models.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*models.File, len(files))
+	placeholders := make([]string, 0, len(files))
+	args := make([]any, 0, len(files))
+	for i := range files {
+		byID[files[i].FileID] = &files[i]
+		placeholders = append(placeholders, "?")
+		args = append(args, files[i].FileID)
+	}
+
+	rows, err := h.DB.Query(`SELECT file_id, tag FROM file_tags WHERE file_id IN (`+strings.Join(placeholders, ",")+`) ORDER BY tag`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		if f, ok := byID[id]; ok {
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+
+	return rows.Err()
+}
+
+// normalizeTags trims, lowercases and deduplicates tags and enforces the tag limits.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength || strings.ContainsAny(tag, ",\n\r\t") {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Tag %q is not valid, tags are at most %d characters without commas", tag, maxTagLength))
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	if len(result) > maxTagsPerFile {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("A file can have at most %d tags", maxTagsPerFile))
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// validateProperty checks a property key and its new value, nil meaning removal.
+func validateProperty(key string, value *string) error {
+	if strings.TrimSpace(key) == "" || utf8.RuneCountInString(key) > maxPropertyKeyLength {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Property keys must be 1 to %d characters", maxPropertyKeyLength))
+	}
+	if value != nil && utf8.RuneCountInString(*value) > maxPropertyValueLen {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Property %q must be at most %d characters", key, maxPropertyValueLen))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFileMetaAndTags(t *testing.T) {
+	ctx := SetupTestContext(t)
+
+	insertTestFile(t, ctx, 1, "q1.pdf", "report", true)
+	insertTestFile(t, ctx, 2, "q2.pdf", "report", true)
+	insertTestFile(t, ctx, 3, "lunch.txt", "menu", true)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/file/:fileid/meta", handler.GetMeta)
+	ctx.App.Patch("/file/:fileid/meta", handler.UpdateMeta)
+	ctx.App.Post("/files/tag", handler.BatchTag)
+	ctx.App.Get("/files:keyword?:shared?", handler.ListFiles)
+
+	send := func(method, url, body string) (int, []byte) {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		defer resp.Body.Close()
+
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		return resp.StatusCode, buf.Bytes()
+	}
+
+	status, body := send("PATCH", "/file/1/meta", `{"description": "First quarter", "tags": ["Finance", " reports ", "finance"], "properties": {"owner": "accounting", "year": "2025"}}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", fiber.StatusOK, status, body)
+	}
+
+	var meta models.FileMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		t.Fatalf("failed to decode meta: %v", err)
+	}
+	if meta.Description != "First quarter" || strings.Join(meta.Tags, ",") != "finance,reports" || meta.Properties["owner"] != "accounting" {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+
+	// Properties are merged and null removes a key, other fields stay untouched
+	send("PATCH", "/file/1/meta", `{"properties": {"year": null, "status": "final"}}`)
+	_, body = send("GET", "/file/1/meta", "")
+	meta = models.FileMeta{}
+	json.Unmarshal(body, &meta)
+	if _, ok := meta.Properties["year"]; ok || meta.Properties["status"] != "final" || len(meta.Tags) != 2 {
+		t.Errorf("unexpected metadata after merge: %+v", meta)
+	}
+
+	if status, _ := send("PATCH", "/file/1/meta", `{"tags": ["a,b"]}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected status %d for invalid tag, got %d", fiber.StatusBadRequest, status)
+	}
+
+	// Bulk tagging adds to the existing tags
+	status, body = send("POST", "/files/tag", `{"file_ids": ["2", "99"], "tags": ["finance"]}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", fiber.StatusOK, status, body)
+	}
+	var batch struct {
+		Results []models.BatchResult `json:"results"`
+	}
+	json.Unmarshal(body, &batch)
+	if len(batch.Results) != 2 || !batch.Results[0].OK || batch.Results[1].OK {
+		t.Errorf("unexpected batch results: %+v", batch.Results)
+	}
+
+	// The shared space can be filtered by tag
+	status, body = send("GET", "/files?shared=true&tag=finance", "")
+	if status != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", fiber.StatusOK, status, body)
+	}
+	var files []models.File
+	json.Unmarshal(body, &files)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files tagged finance, got %+v", files)
+	}
+	for _, f := range files {
+		if f.Filename == "q1.pdf" && (f.Description != "First quarter" || strings.Join(f.Tags, ",") != "finance,reports") {
+			t.Errorf("listing is missing metadata: %+v", f)
+		}
+	}
+
+	_, body = send("GET", "/files?shared=true&tag=finance,reports", "")
+	files = nil
+	json.Unmarshal(body, &files)
+	if len(files) != 1 || files[0].Filename != "q1.pdf" {
+		t.Errorf("expected only q1.pdf with both tags, got %+v", files)
+	}
+
+	// Removing tags in bulk
+	send("POST", "/files/tag", `{"file_ids": ["1", "2"], "remove_tags": ["finance"]}`)
+	_, body = send("GET", "/files?shared=true&tag=finance", "")
+	files = nil
+	json.Unmarshal(body, &files)
+	if len(files) != 0 {
+		t.Errorf("expected no files tagged finance after removal, got %+v", files)
+	}
+
+	// Tags go away with the file
+	if _, err := ctx.DB.Exec(`DELETE FROM metadata WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	ctx.DB.QueryRow(`SELECT COUNT(*) FROM file_tags WHERE file_id = 1`).Scan(&count)
+	if count != 0 {
+		t.Errorf("expected tags of deleted file to be removed, found %d", count)
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// pngHeader is enough of a PNG file for content sniffing.
+const pngHeader = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+func TestUploadDetectsMIMEType(t *testing.T) {
+	ctx := SetupTestContext(t)
+	if _, err := ctx.Settings.Update([]byte(`{"blocked_mime_types": ["application/x-executable", "video/*"]}`)); err != nil {
+		t.Fatal("failed to update settings:", err)
+	}
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/upload:shared?", handler.UploadFile)
+	ctx.App.Get("/files:keyword?:shared?", handler.ListFiles)
+
+	upload := func(files map[string]string) int {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for name, content := range files {
+			part, err := writer.CreateFormFile("files", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write([]byte(content))
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The content wins over a misleading extension
+	if status := upload(map[string]string{"photo.dat": pngHeader, "notes.md": "# Notes"}); status != fiber.StatusCreated {
+		t.Fatalf("expected status %d, got %d", fiber.StatusCreated, status)
+	}
+
+	// One blocked file rejects the whole upload
+	status := upload(map[string]string{"tool": "\x7fELF\x02\x01\x01", "ok.txt": "fine"})
+	if status != fiber.StatusUnsupportedMediaType {
+		t.Fatalf("expected status %d for executable, got %d", fiber.StatusUnsupportedMediaType, status)
+	}
+	if _, err := os.Stat(filepath.Join(ctx.TempDir, "test-id", "ok.txt")); !os.IsNotExist(err) {
+		t.Error("files of a rejected upload were stored")
+	}
+
+	// The test schema has no default upload time
+	if _, err := ctx.DB.Exec(`UPDATE metadata SET uploaded_at = '2025-06-01 10:00:00'`); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/files", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	var files []models.File
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	types := make(map[string]string)
+	for _, f := range files {
+		types[f.Filename] = f.MimeType
+	}
+	if types["photo.dat"] != "image/png" {
+		t.Errorf("expected photo.dat to be image/png, got %q", types["photo.dat"])
+	}
+	if types["notes.md"] != "text/markdown" {
+		t.Errorf("expected notes.md to be text/markdown, got %q", types["notes.md"])
+	}
+
+	// The type filter uses the detected type rather than the extension
+	req = httptest.NewRequest("GET", "/files?type=image", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	resp, err = ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	files = nil
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "photo.dat" {
+		t.Errorf("expected only photo.dat for type=image, got %+v", files)
+	}
+}
+
+func TestDownloadContentHeaders(t *testing.T) {
+	ctx := SetupTestContext(t)
+
+	insertTestFile(t, ctx, 1, "photo.png", pngHeader, false)
+	insertTestFile(t, ctx, 2, "page.html", "<html><script>alert(1)</script></html>", false)
+	insertTestFile(t, ctx, 3, "readme.txt", "hello", true)
+
+	// Simulate rows stored before content types were recorded
+	internal.BackfillMIMETypes(ctx.DB)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/file/:fileid", handler.DownloadFile)
+
+	cases := []struct {
+		url         string
+		contentType string
+		disposition string
+	}{
+		{"/file/1", "image/png", `attachment; filename=photo.png`},
+		{"/file/1?inline=true", "image/png", `inline; filename=photo.png`},
+		{"/file/2?inline=true", "text/html; charset=utf-8", `attachment; filename=page.html`},
+		{"/file/3?inline=true", "text/plain; charset=utf-8", `inline; filename=readme.txt`},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", tc.url, fiber.StatusOK, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tc.url, tc.contentType, got)
+		}
+		if got := resp.Header.Get("Content-Disposition"); !strings.EqualFold(got, tc.disposition) {
+			t.Errorf("%s: expected Content-Disposition %q, got %q", tc.url, tc.disposition, got)
+		}
+		if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: expected nosniff header", tc.url)
+		}
+	}
+}
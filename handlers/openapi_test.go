@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/AumSahayata/cloudboxio/tests"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPISchema struct {
+	Ref        string                   `json:"$ref"`
+	AllOf      []openAPISchema          `json:"allOf"`
+	Properties map[string]openAPISchema `json:"properties"`
+}
+
+// schemaTypes maps the component schemas to the Go types the handlers encode or decode.
+// Schemas without a Go type, such as Message, are built from fiber.Map and left out.
+var schemaTypes = map[string]any{
+	"APIError":           APIError{},
+	"File":               models.File{},
+	"BatchRequest":       models.BatchRequest{},
+	"BatchResult":        models.BatchResult{},
+	"SearchHit":          models.SearchHit{},
+	"FilePreview":        models.FilePreview{},
+	"FileMeta":           models.FileMeta{},
+	"MetaUpdate":         models.MetaUpdate{},
+	"FavoriteFile":       models.FavoriteFile{},
+	"RecentFile":         models.RecentFile{},
+	"Change":             models.Change{},
+	"ChangeList":         models.ChangeList{},
+	"UserInfo":           models.UserInfo{},
+	"UserUpdate":         models.UserUpdate{},
+	"SignUp":             models.SignUp{},
+	"Login":              models.Login{},
+	"ResetPassword":      models.ResetPassword{},
+	"LoginAttempt":       models.LoginAttempt{},
+	"PasswordResetToken": models.PasswordResetToken{},
+	"TokenPasswordReset": models.TokenPasswordReset{},
+	"Webhook":            models.Webhook{},
+	"CreateWebhook":      models.CreateWebhook{},
+	"WebhookDelivery":    models.WebhookDelivery{},
+	"Event":              internal.Event{},
+	"FsckOptions":        internal.FsckOptions{},
+	"FsckIssue":          internal.FsckIssue{},
+	"FsckReport":         internal.FsckReport{},
+	"ImportOptions":      internal.ImportOptions{},
+	"ImportError":        internal.ImportError{},
+	"ImportResult":       internal.ImportResult{},
+	"Settings":           internal.Settings{},
+	"RateLimitSettings":  internal.RateLimitConfig{},
+	"BandwidthSettings":  internal.BandwidthConfig{},
+	"LockoutSettings":    internal.LockoutConfig{},
+}
+
+func loadOpenAPI(t *testing.T) openAPIDoc {
+	t.Helper()
+
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal("openapi.json is not valid JSON:", err)
+	}
+	return doc
+}
+
+// specPath converts a Fiber route path to its OpenAPI form: /file/:fileid becomes
+// /file/{fileid} and optional parameters glued to a segment, as in /files:keyword?, are dropped.
+func specPath(path string) string {
+	path = regexp.MustCompile(`([^/])(:[A-Za-z_]+\?)+`).ReplaceAllString(path, "$1")
+	return regexp.MustCompile(`:([A-Za-z_]+)`).ReplaceAllString(path, "{$1}")
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	voidLogger := log.New(io.Discard, "", 0)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	database := tests.SetupTestDB(t)
+	if err := RegisterRoutes(app.Group("/api"), database, internal.DefaultConfig(), testSettings(t, database, internal.DefaultConfig()), voidLogger, voidLogger); err != nil {
+		t.Fatal(err)
+	}
+
+	registered := map[string]bool{}
+	for _, route := range app.GetRoutes(true) {
+		// Fiber adds HEAD for every GET, and USE entries are middleware
+		if route.Method == fiber.MethodHead || route.Method == "USE" || !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		registered[strings.ToLower(route.Method)+" "+specPath(strings.TrimPrefix(route.Path, "/api"))] = true
+	}
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			documented[method+" "+path] = true
+		}
+	}
+
+	for _, op := range sortedKeys(registered) {
+		if !documented[op] {
+			t.Errorf("route %s is registered but missing from openapi.json", op)
+		}
+	}
+	for _, op := range sortedKeys(documented) {
+		if !registered[op] {
+			t.Errorf("openapi.json documents %s, which is not a registered route", op)
+		}
+	}
+}
+
+func TestOpenAPISchemasMatchModels(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	for name, value := range schemaTypes {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing from openapi.json", name)
+			continue
+		}
+
+		want := jsonFields(reflect.TypeOf(value))
+		got := schemaFields(doc, schema)
+		if !reflect.DeepEqual(sortedKeys(got), sortedKeys(want)) {
+			t.Errorf("schema %s has fields %v, but %T encodes %v", name, sortedKeys(got), value, sortedKeys(want))
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Get("/api/openapi.json", OpenAPI)
+	app.Get("/api/docs", Docs)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Fatalf("unexpected spec response: %v %v", resp, err)
+	}
+	var spec map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil || spec["openapi"] == nil {
+		t.Fatalf("spec response is not an OpenAPI document: %v", err)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/docs", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("unexpected docs response: %v %v", resp, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `fetch("openapi.json")`) {
+		t.Fatal("docs page does not load the spec")
+	}
+}
+
+// jsonFields returns the names encoding/json uses for the fields of a struct type.
+func jsonFields(typ reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" {
+			for name := range jsonFields(field.Type) {
+				fields[name] = true
+			}
+			continue
+		}
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
+
+// schemaFields returns the property names of a schema, following $ref and allOf.
+func schemaFields(doc openAPIDoc, schema openAPISchema) map[string]bool {
+	fields := map[string]bool{}
+	if schema.Ref != "" {
+		return schemaFields(doc, doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")])
+	}
+	for _, part := range schema.AllOf {
+		for name := range schemaFields(doc, part) {
+			fields[name] = true
+		}
+	}
+	for name := range schema.Properties {
+		fields[name] = true
+	}
+	return fields
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
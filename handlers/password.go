@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTokenTTL is how long a reset token issued by an admin can be used.
+const passwordResetTokenTTL = 24 * time.Hour
+
+// weakPassword is the error for a new password the policy does not allow.
+func weakPassword(err error) error {
+	msg := err.Error()
+	return NewAPIError(fiber.StatusBadRequest, "", strings.ToUpper(msg[:1])+msg[1:])
+}
+
+// hashResetToken returns the digest a reset token is stored under.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueResetToken creates a one-time token the user can set a new password with, for users
+// who forgot theirs. It replaces any earlier token of the user. Admin only.
+func (h *AuthHandler) IssueResetToken(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can issue reset tokens")
+	}
+
+	targetID, err := internal.CleanParam(c.Params("id"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "User ID provided is not proper")
+	}
+	username, err := internal.GetUsernameByID(targetID, h.DB)
+	if err != nil {
+		return NewAPIError(fiber.StatusNotFound, "", "User not found")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to generate token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	expiresAt := time.Now().Add(passwordResetTokenTTL)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to store token")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM password_reset_tokens WHERE user_id = ?`, targetID); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to store token")
+	}
+	if _, err := tx.Exec(`INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		hashResetToken(token), targetID, expiresAt.Unix()); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to store token")
+	}
+	if err := tx.Commit(); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to store token")
+	}
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+	h.LogINFO.Printf("ADMIN user [%s] issued a password reset token for user (%s)", adminUsername, username)
+
+	return c.Status(fiber.StatusCreated).JSON(models.PasswordResetToken{
+		Token:     token,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// ResetPasswordWithToken sets a new password with a token issued by an admin. The token
+// works once, and it also ends a lockout of the account.
+func (h *AuthHandler) ResetPasswordWithToken(c *fiber.Ctx) error {
+	var req models.TokenPasswordReset
+	if err := c.BodyParser(&req); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
+	}
+	if req.Token == "" || req.NewPassword == "" {
+		return NewAPIError(fiber.StatusBadRequest, "", "All the fields are required")
+	}
+
+	// Checked first so a password that is refused does not use up the token
+	if err := h.Passwords.Check(req.NewPassword); err != nil {
+		return weakPassword(err)
+	}
+	hashedNew, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), 14)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to hash password")
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update password")
+	}
+	defer tx.Rollback()
+
+	var userID string
+	var expiresAt int64
+	err = tx.QueryRow(`DELETE FROM password_reset_tokens WHERE token_hash = ? RETURNING user_id, expires_at`,
+		hashResetToken(req.Token)).Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && expiresAt <= time.Now().Unix()) {
+		// An expired token is deleted all the same
+		tx.Commit()
+		return NewAPIError(fiber.StatusBadRequest, "", "Reset token is invalid or expired")
+	}
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update password")
+	}
+
+	var username string
+	err = tx.QueryRow(`UPDATE users SET password = ?, must_change_password = FALSE WHERE id = ? RETURNING username`,
+		string(hashedNew), userID).Scan(&username)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update password")
+	}
+	if err := tx.Commit(); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update password")
+	}
+
+	if err := h.Guard.Unlock(userID); err != nil {
+		h.LogError.Println("Failed to unlock account after password reset:", err)
+	}
+	h.LogINFO.Printf("User (%s) reset the password with a reset token", username)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password reset successful"})
+}
+
+// ForcePasswordChange makes a user choose a new password before doing anything else,
+// including with tokens issued before. Admin only.
+func (h *AuthHandler) ForcePasswordChange(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can force a password change")
+	}
+
+	targetID, err := internal.CleanParam(c.Params("id"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "User ID provided is not proper")
+	}
+
+	var username string
+	err = h.DB.QueryRow(`UPDATE users SET must_change_password = TRUE WHERE id = ? RETURNING username`, targetID).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return NewAPIError(fiber.StatusNotFound, "", "User not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update user")
+	}
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+	h.LogINFO.Printf("ADMIN user [%s] required user (%s) to change the password", adminUsername, username)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestJSON sends body to app with token, when not empty, and decodes the response into out.
+func requestJSON(t *testing.T, app *fiber.App, method, target, token string, body, out any) *http.Response {
+	t.Helper()
+
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	digest := sha1.Sum([]byte("Correct1Horse"))
+	list := "Password123456\r\n\n" + strings.ToUpper(hex.EncodeToString(digest[:])) + ":42\n"
+	if err := os.WriteFile(breached, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := SetupTestContext(t)
+	ctx.Config.PasswordPolicy = internal.PasswordPolicyConfig{MinLength: 10, RequireUppercase: true, RequireDigit: true, BreachedListFile: breached}
+	if err := ctx.Config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := internal.NewPasswordPolicy(ctx.Config.PasswordPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		password string
+		problem  string
+	}{
+		{"Tr0ub4dor&3x", ""},
+		{"Ünïcödé1234", ""},
+		{"short1A", "be at least 10 characters"},
+		{"nouppercase1", "contain an uppercase letter"},
+		{"NoDigitsHere", "contain a digit"},
+		{"Password123456", "not be a password known from data breaches"},
+		{"Correct1Horse", "not be a password known from data breaches"},
+		{strings.Repeat("Aa1", 25), "be at most 72 bytes"},
+		{"lower", "password must be at least 10 characters, contain an uppercase letter and contain a digit"},
+	}
+	for _, tc := range cases {
+		err := policy.Check(tc.password)
+		if tc.problem == "" {
+			if err != nil {
+				t.Errorf("%q: expected the password to be allowed, got %v", tc.password, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("%q: expected %q, got %v", tc.password, tc.problem, err)
+		}
+	}
+
+	// The policy applies wherever a password is chosen
+	if _, err := ctx.Settings.Update([]byte(`{"registration": "open"}`)); err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]string
+	resp := requestJSON(t, app, "POST", "/api/signup", ctx.Token, models.SignUp{Username: "alice", Password: "a"}, &body)
+	if resp.StatusCode != fiber.StatusBadRequest || !strings.HasPrefix(body["error"], "Password must be at least 10 characters") {
+		t.Fatalf("expected a weak password to be refused at signup, got %d %v", resp.StatusCode, body)
+	}
+	if resp := requestJSON(t, app, "POST", "/api/register", "", models.Login{Username: "bob", Password: "Password123456"}, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected a breached password to be refused at registration, got %d", resp.StatusCode)
+	}
+	reset := models.ResetPassword{CurrentPassword: "securepass", NewPassword: "nouppercase1"}
+	if resp := requestJSON(t, app, "PUT", "/api/reset-password", ctx.Token, reset, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected a weak password to be refused at reset, got %d", resp.StatusCode)
+	}
+	if resp := requestJSON(t, app, "POST", "/api/register", "", models.Login{Username: "bob", Password: "Tr0ub4dor&3x"}, nil); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected a strong password to be accepted, got %d", resp.StatusCode)
+	}
+
+	// A missing list is reported by the configuration
+	ctx.Config.PasswordPolicy.BreachedListFile = filepath.Join(t.TempDir(), "missing.txt")
+	if err := ctx.Config.Validate(); err == nil {
+		t.Error("expected a missing breached password list to be rejected")
+	}
+	// and stops the API from starting if it goes missing after validation
+	if err := RegisterAPI(fiber.New(fiber.Config{ErrorHandler: ErrorHandler}), ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err == nil {
+		t.Error("expected the API to refuse a missing breached password list")
+	}
+}
+
+func TestPasswordResetByAdmin(t *testing.T) {
+	ctx := SetupTestContext(t)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(username, password string) map[string]any {
+		var body map[string]any
+		if resp := requestJSON(t, app, "POST", "/api/login", "", models.Login{Username: username, Password: password}, &body); resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("login of %s failed with %d %v", username, resp.StatusCode, body)
+		}
+		return body
+	}
+
+	if resp := requestJSON(t, app, "POST", "/api/signup", ctx.Token, models.SignUp{Username: "alice", Password: "first-password"}, nil); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("signup failed with %d", resp.StatusCode)
+	}
+	var aliceID string
+	if err := ctx.DB.QueryRow(`SELECT id FROM users WHERE username = 'alice'`).Scan(&aliceID); err != nil {
+		t.Fatal(err)
+	}
+	aliceToken := login("alice", "first-password")["token"].(string)
+
+	// Only admins issue tokens and force changes
+	if resp := requestJSON(t, app, "POST", "/api/users/test-id/reset-token", aliceToken, nil, nil); resp.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("expected status %d for a regular user, got %d", fiber.StatusForbidden, resp.StatusCode)
+	}
+	if resp := requestJSON(t, app, "POST", "/api/users/test-id/force-password-change", aliceToken, nil, nil); resp.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("expected status %d for a regular user, got %d", fiber.StatusForbidden, resp.StatusCode)
+	}
+	if resp := requestJSON(t, app, "POST", "/api/users/missing/reset-token", ctx.Token, nil, nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected status %d for an unknown user, got %d", fiber.StatusNotFound, resp.StatusCode)
+	}
+
+	// A reset token works once, and a refused password does not use it up
+	var token models.PasswordResetToken
+	if resp := requestJSON(t, app, "POST", "/api/users/"+aliceID+"/reset-token", ctx.Token, nil, &token); resp.StatusCode != fiber.StatusCreated || token.Token == "" || token.ExpiresAt == "" {
+		t.Fatalf("unexpected token %d %+v", resp.StatusCode, token)
+	}
+	var stored string
+	ctx.DB.QueryRow(`SELECT token_hash FROM password_reset_tokens WHERE user_id = ?`, aliceID).Scan(&stored)
+	if stored == "" || stored == token.Token {
+		t.Fatalf("expected only a digest of the token to be stored, got %q", stored)
+	}
+
+	redeem := func(token, password string) int {
+		return requestJSON(t, app, "POST", "/api/reset-password/token", "", models.TokenPasswordReset{Token: token, NewPassword: password}, nil).StatusCode
+	}
+	if status := redeem(token.Token, "short"); status != fiber.StatusBadRequest {
+		t.Fatalf("expected a weak password to be refused, got %d", status)
+	}
+	if status := redeem(token.Token, "second-password"); status != fiber.StatusOK {
+		t.Fatalf("expected the token to reset the password, got %d", status)
+	}
+	if status := redeem(token.Token, "third-password"); status != fiber.StatusBadRequest {
+		t.Fatalf("expected a used token to be refused, got %d", status)
+	}
+	login("alice", "second-password")
+
+	// Expired tokens are refused
+	requestJSON(t, app, "POST", "/api/users/"+aliceID+"/reset-token", ctx.Token, nil, &token)
+	ctx.DB.Exec(`UPDATE password_reset_tokens SET expires_at = 0`)
+	if status := redeem(token.Token, "third-password"); status != fiber.StatusBadRequest {
+		t.Fatalf("expected an expired token to be refused, got %d", status)
+	}
+
+	// After a forced change the login only allows choosing a new password, and tokens
+	// issued before are limited the same way
+	if resp := requestJSON(t, app, "POST", "/api/users/"+aliceID+"/force-password-change", ctx.Token, nil, nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", fiber.StatusNoContent, resp.StatusCode)
+	}
+	if resp := requestJSON(t, app, "GET", "/api/files", aliceToken, nil, nil); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected a token issued before the forced change to be limited, got %d", resp.StatusCode)
+	}
+	body := login("alice", "second-password")
+	if body["must_change_password"] != true {
+		t.Fatalf("expected the login to ask for a new password, got %v", body)
+	}
+	restricted := body["token"].(string)
+	for _, target := range []string{"/api/files", "/api/v2/files", "/api/users/" + aliceID + "/logins"} {
+		if resp := requestJSON(t, app, "GET", target, restricted, nil, nil); resp.StatusCode != fiber.StatusForbidden {
+			t.Errorf("%s: expected status %d before the change, got %d", target, fiber.StatusForbidden, resp.StatusCode)
+		}
+	}
+	var info models.UserInfo
+	if resp := requestJSON(t, app, "GET", "/api/user-info", restricted, nil, &info); resp.StatusCode != fiber.StatusOK || !info.MustChangePassword {
+		t.Fatalf("unexpected user info %d %+v", resp.StatusCode, info)
+	}
+
+	var changed map[string]string
+	reset := models.ResetPassword{CurrentPassword: "second-password", NewPassword: "third-password"}
+	if resp := requestJSON(t, app, "PUT", "/api/v2/reset-password", restricted, reset, &changed); resp.StatusCode != fiber.StatusOK || changed["token"] == "" {
+		t.Fatalf("expected a regular token after the change, got %d %v", resp.StatusCode, changed)
+	}
+	if resp := requestJSON(t, app, "GET", "/api/files", changed["token"], nil, nil); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d after the change, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+	if resp := requestJSON(t, app, "GET", "/api/files", restricted, nil, nil); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected the password change token to stay limited, got %d", resp.StatusCode)
+	}
+	if body := login("alice", "third-password"); body["must_change_password"] != nil {
+		t.Fatalf("expected a regular login after the change, got %v", body)
+	}
+}
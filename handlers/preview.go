@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPreviewLines caps the lines query parameter of Preview.
+const maxPreviewLines = 200
+
+// Thumbnail serves the cached thumbnail of an image. While it is being generated
+// the response is 202 Accepted with a Retry-After header.
+func (h *FileHandler) Thumbnail(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	fileID, err := internal.CleanParam(c.Params("fileid"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "File ID provided is not proper")
+	}
+
+	file, err := h.accessibleFile(fileID, userID)
+	if err != nil {
+		if errors.Is(err, errFileNotFound) {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	if !internal.HasThumbnail(file.Filename) {
+		return NewAPIError(fiber.StatusNotFound, "", "No thumbnail available for this file")
+	}
+
+	size := internal.ThumbnailSize(c.QueryInt("size", 0))
+	thumbPath, err := internal.ThumbnailPath(h.Config.FilesDir, file.ID, file.Path, size)
+	if err != nil {
+		return NewAPIError(fiber.StatusNotFound, "", "File not found")
+	}
+
+	if _, err := os.Stat(thumbPath); err == nil {
+		// The path changes with the file version, so the thumbnail never goes stale
+		c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+		c.Type("jpg")
+		return c.SendFile(thumbPath)
+	}
+
+	switch err := internal.QueueThumbnail(file.Path, thumbPath, size); {
+	case errors.Is(err, internal.ErrNoPreview):
+		return NewAPIError(fiber.StatusNotFound, "", "No thumbnail available for this file")
+	case err != nil:
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return NewAPIError(fiber.StatusServiceUnavailable, "", "Thumbnail generation is busy, try again later")
+	}
+
+	c.Set(fiber.HeaderRetryAfter, "1")
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Thumbnail is being generated"})
+}
+
+// Preview returns the first lines of a text file.
+func (h *FileHandler) Preview(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	fileID, err := internal.CleanParam(c.Params("fileid"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "File ID provided is not proper")
+	}
+
+	lines := c.QueryInt("lines", 20)
+	if lines <= 0 || lines > maxPreviewLines {
+		return NewAPIError(fiber.StatusBadRequest, "", "lines must be between 1 and "+strconv.Itoa(maxPreviewLines))
+	}
+
+	file, err := h.accessibleFile(fileID, userID)
+	if err != nil {
+		if errors.Is(err, errFileNotFound) {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Could not fetch file metadata")
+	}
+
+	content, truncated, err := internal.TextPreview(file.Path, lines)
+	if err != nil {
+		if errors.Is(err, internal.ErrNoPreview) {
+			return NewAPIError(fiber.StatusUnsupportedMediaType, "", "No preview available for this file")
+		}
+		if os.IsNotExist(err) {
+			return NewAPIError(fiber.StatusNotFound, "", "File not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to read file")
+	}
+
+	h.recordActivity(userID, file.ID, activityView)
+
+	return c.Status(fiber.StatusOK).JSON(models.FilePreview{
+		FileID:    fileID,
+		Lines:     content,
+		Truncated: truncated,
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestThumbnailAndPreview(t *testing.T) {
+	ctx := SetupTestContext(t)
+	internal.Error = log.New(io.Discard, "", 0)
+
+	stop := internal.StartThumbnailer(1)
+	defer stop()
+
+	// A 400x200 image should be scaled to fit 128x128 keeping its aspect ratio
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	insertTestFile(t, ctx, 1, "photo.png", buf.String(), false)
+	insertTestFile(t, ctx, 2, "broken.png", "not really a png", false)
+	insertTestFile(t, ctx, 3, "notes.txt", "first\nsecond\nthird\n", false)
+	insertTestFile(t, ctx, 4, "copy.png", buf.String(), false)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/file/:fileid/thumbnail", handler.Thumbnail)
+	ctx.App.Get("/file/:fileid/preview", handler.Preview)
+
+	get := func(url string) *http.Response {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		return resp
+	}
+
+	// waitFor polls until the thumbnail is no longer pending
+	waitFor := func(url string) *http.Response {
+		for i := 0; i < 100; i++ {
+			resp := get(url)
+			if resp.StatusCode != fiber.StatusAccepted {
+				return resp
+			}
+			resp.Body.Close()
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("thumbnail was never generated")
+		return nil
+	}
+
+	resp := waitFor("/file/1/thumbnail?size=100")
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+	thumb, err := jpeg.Decode(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if b := thumb.Bounds(); b.Dx() != 128 || b.Dy() != 64 {
+		t.Errorf("expected 128x64 thumbnail, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// A broken image fails once and is then reported as unavailable
+	resp = waitFor("/file/2/thumbnail")
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected status %d for broken image, got %d", fiber.StatusNotFound, resp.StatusCode)
+	}
+
+	// Failures unrelated to the image, such as an unwritable cache, are retried
+	blocker := filepath.Join(ctx.TempDir, internal.ThumbnailDir, "4")
+	if err := os.MkdirAll(filepath.Dir(blocker), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp = get("/file/4/thumbnail")
+	resp.Body.Close()
+	time.Sleep(200 * time.Millisecond)
+	resp = get("/file/4/thumbnail")
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusAccepted {
+		t.Errorf("expected the failed thumbnail to be queued again, got %d", resp.StatusCode)
+	}
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	resp = waitFor("/file/4/thumbnail")
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected the thumbnail to be generated once the cache is writable, got %d", resp.StatusCode)
+	}
+
+	resp = get("/file/3/thumbnail")
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected status %d for text thumbnail, got %d", fiber.StatusNotFound, resp.StatusCode)
+	}
+
+	resp = get("/file/3/preview?lines=2")
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+	var preview models.FilePreview
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil {
+		t.Fatalf("failed to decode preview: %v", err)
+	}
+	resp.Body.Close()
+	if strings.Join(preview.Lines, ",") != "first,second" || !preview.Truncated {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+
+	resp = get("/file/1/preview")
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d for image preview, got %d", fiber.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRateLimits(t *testing.T) {
+	ctx := SetupTestContext(t)
+	if _, err := ctx.Settings.Update([]byte(`{
+		"login_rate_limit": {"enabled": true, "max": 2, "expiration_seconds": 60},
+		"user_rate_limit": {"enabled": true, "max": 3, "expiration_seconds": 60}
+	}`)); err != nil {
+		t.Fatal("failed to update settings:", err)
+	}
+
+	newApp := func() *fiber.App {
+		app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+			t.Fatal(err)
+		}
+		return app
+	}
+	app := newApp()
+	login := func(app *fiber.App, password string) *http.Response {
+		body, _ := json.Marshal(map[string]string{"username": "testuser", "password": password})
+		req := httptest.NewRequest("POST", "/api/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Only failed logins count
+	if resp := login(app, "securepass"); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+	for i := range 2 {
+		resp := login(app, "wrong")
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected status %d, got %d", i, fiber.StatusUnauthorized, resp.StatusCode)
+		}
+		if got := resp.Header.Get(internal.HeaderRateLimitRemaining); got != strconv.Itoa(1-i) {
+			t.Errorf("attempt %d: expected %d requests left, got %q", i, 1-i, got)
+		}
+	}
+	resp := login(app, "securepass")
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("expected status %d once the limit is reached, got %d", fiber.StatusTooManyRequests, resp.StatusCode)
+	}
+	if retry, _ := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter)); retry <= 0 || retry > 60 {
+		t.Errorf("unexpected Retry-After %q", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	if resp.Header.Get(internal.HeaderRateLimitLimit) != "2" || resp.Header.Get(internal.HeaderRateLimitRemaining) != "0" {
+		t.Errorf("unexpected rate limit headers %v", resp.Header)
+	}
+
+	// The counters are kept in the database, a restarted server still refuses the login
+	if resp := login(newApp(), "securepass"); resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("expected status %d after a restart, got %d", fiber.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	// Logged in users are limited by their ID, across both API versions
+	for i, target := range []string{"/api/files", "/api/v2/files", "/api/files", "/api/v2/files"} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		want := fiber.StatusOK
+		if i == 3 {
+			want = fiber.StatusTooManyRequests
+		}
+		if resp.StatusCode != want {
+			t.Fatalf("%s request %d: expected status %d, got %d", target, i, want, resp.StatusCode)
+		}
+	}
+
+	// Disabling a limit applies to the next request
+	if _, err := ctx.Settings.Update([]byte(`{"user_rate_limit": {"enabled": false, "max": 3, "expiration_seconds": 60}}`)); err != nil {
+		t.Fatal("failed to update settings:", err)
+	}
+	req := httptest.NewRequest("GET", "/api/files", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	if resp, _ := app.Test(req, -1); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d without a user limit, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	// The server wide per IP limit answers in the error format of each API version
+	if _, err := ctx.Settings.Update([]byte(`{"rate_limit": {"enabled": true, "max": 1, "expiration_seconds": 60}}`)); err != nil {
+		t.Fatal("failed to update settings:", err)
+	}
+	server := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	server.Use(internal.NewRateLimiter(ctx.DB, ctx.Settings).PerIP())
+	if err := RegisterAPI(server, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	requestJSON(t, server, "GET", "/api/files", ctx.Token, nil, nil)
+	var v2 struct {
+		Error APIError `json:"error"`
+	}
+	if resp := requestJSON(t, server, "GET", "/api/v2/files", ctx.Token, nil, &v2); resp.StatusCode != fiber.StatusTooManyRequests || v2.Error.Code != CodeRateLimited {
+		t.Fatalf("expected the v2 envelope, got %d %+v", resp.StatusCode, v2)
+	}
+	var v1 map[string]string
+	if resp := requestJSON(t, server, "GET", "/api/files", ctx.Token, nil, &v1); resp.StatusCode != fiber.StatusTooManyRequests || v1["error"] == "" {
+		t.Fatalf("expected the original error body, got %d %v", resp.StatusCode, v1)
+	}
+}
+
+func TestBandwidthLimits(t *testing.T) {
+	ctx := SetupTestContext(t)
+	if _, err := ctx.Settings.Update([]byte(`{"bandwidth": {"download_kbps": 64, "upload_kbps": 1}}`)); err != nil {
+		t.Fatal("failed to update settings:", err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first second of a 96KB download is free, the last chunk waits about half a second
+	content := strings.Repeat("x", 96<<10)
+	insertTestFile(t, ctx, 1, "large.txt", content, false)
+
+	req := httptest.NewRequest("GET", "/api/file/1", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	start := time.Now()
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK || string(body) != content {
+		t.Fatalf("unexpected download %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expected the download to be throttled, took %v", elapsed)
+	}
+
+	// An upload over the cap is accepted, the next one has to wait
+	upload := func(name string) *http.Response {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("files", name)
+		part.Write([]byte(strings.Repeat("u", 4<<10)))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/upload", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := upload("first.txt"); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+	}
+	resp = upload("second.txt")
+	if resp.StatusCode != fiber.StatusTooManyRequests || resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Fatalf("expected status %d with Retry-After, got %d %v", fiber.StatusTooManyRequests, resp.StatusCode, resp.Header)
+	}
+}
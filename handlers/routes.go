@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterAPI mounts the API on app under /api/v2, with the v2 error envelope and request IDs,
+// and under /api for existing clients. The v2 routes come first because the /api middleware
+// would otherwise also run for them. Both share the rate and bandwidth limits.
+func RegisterAPI(app fiber.Router, database *sql.DB, cfg *internal.Config, settings *internal.SettingsStore, infoLogger, errorLogger *log.Logger) error {
+	passwords, err := internal.NewPasswordPolicy(cfg.PasswordPolicy)
+	if err != nil {
+		return err
+	}
+	limits := newLimits(database, settings)
+	registerRoutes(app.Group("/api/v2", internal.RequestID(), ErrorEnvelope()), database, cfg, settings, limits, passwords, infoLogger, errorLogger)
+	registerRoutes(app.Group("/api"), database, cfg, settings, limits, passwords, infoLogger, errorLogger)
+	return nil
+}
+
+// RegisterRoutes mounts every API endpoint on api. Server wide middleware such as CORS
+// and the per IP rate limit is left to the caller so tests and embedders can serve the same API.
+func RegisterRoutes(api fiber.Router, database *sql.DB, cfg *internal.Config, settings *internal.SettingsStore, infoLogger, errorLogger *log.Logger) error {
+	passwords, err := internal.NewPasswordPolicy(cfg.PasswordPolicy)
+	if err != nil {
+		return err
+	}
+	registerRoutes(api, database, cfg, settings, newLimits(database, settings), passwords, infoLogger, errorLogger)
+	return nil
+}
+
+// limits are the rate and bandwidth limits applied by the routes.
+type limits struct {
+	requests  *internal.RateLimiter
+	bandwidth *internal.BandwidthLimiter
+}
+
+func newLimits(database *sql.DB, settings *internal.SettingsStore) limits {
+	return limits{
+		requests:  internal.NewRateLimiter(database, settings),
+		bandwidth: internal.NewBandwidthLimiter(settings),
+	}
+}
+
+func registerRoutes(api fiber.Router, database *sql.DB, cfg *internal.Config, settings *internal.SettingsStore, limits limits, passwords *internal.PasswordPolicy, infoLogger, errorLogger *log.Logger) {
+	authHandler := NewAuthHandler(database, settings, infoLogger, errorLogger)
+	authHandler.Passwords = passwords
+	authHandler.Config = cfg
+	fileHandler := NewFileHandler(database, cfg, settings)
+	fileHandler.Bandwidth = limits.bandwidth
+	adminHandler := NewAdminHandler(database, cfg, settings, infoLogger, errorLogger)
+	eventHandler := NewEventHandler(database)
+
+	//Public routes
+	api.Post("/login", limits.requests.Login(), authHandler.Login)
+	api.Post("/register", limits.requests.Login(), authHandler.Register)
+	api.Post("/reset-password/token", limits.requests.Login(), authHandler.ResetPasswordWithToken)
+	api.Get("/openapi.json", OpenAPI)
+	api.Get("/docs", Docs)
+
+	// The browser EventSource cannot send headers, let it pass the token in the query
+	api.Use("/events", internal.TokenFromQuery())
+
+	//Protected routes
+	api.Use(internal.JWTProtected(database), limits.requests.PerUser())
+
+	// Users told to change their password can do only that until they have
+	api.Put("/reset-password", authHandler.ResetPassword)
+	api.Get("/user-info", authHandler.GetUserInfo)
+	api.Use(internal.PasswordChangeGuard())
+
+	// Files endpoint
+	api.Post("/upload:shared?", limits.bandwidth.Uploads(), fileHandler.UploadFile)
+	api.Post("/files/delete", fileHandler.BatchDelete)
+	api.Post("/files/move", fileHandler.BatchMove)
+	api.Post("/files/share", fileHandler.BatchShare)
+	api.Post("/files/tag", fileHandler.BatchTag)
+	api.Get("/files/archive", fileHandler.DownloadArchive)
+	api.Get("/search", fileHandler.Search)
+	api.Get("/favorites", fileHandler.ListFavorites)
+	api.Get("/recent", fileHandler.ListRecent)
+	api.Get("/changes", fileHandler.Changes)
+	api.Get("/files:keyword?:shared?", fileHandler.ListFiles)
+	api.Get("/file/:fileid", fileHandler.DownloadFile)
+	api.Get("/file/:fileid/thumbnail", fileHandler.Thumbnail)
+	api.Get("/file/:fileid/preview", fileHandler.Preview)
+	api.Get("/file/:fileid/meta", fileHandler.GetMeta)
+	api.Patch("/file/:fileid/meta", fileHandler.UpdateMeta)
+	api.Put("/file/:fileid/favorite", fileHandler.StarFile)
+	api.Delete("/file/:fileid/favorite", fileHandler.UnstarFile)
+	api.Delete("/file/:fileid", fileHandler.DeleteFile)
+
+	// Real-time change notifications
+	api.Get("/events", eventHandler.Stream)
+
+	// User endpoints
+	api.Post("/signup", authHandler.SignUp)
+	api.Get("/users", authHandler.GetUsers)
+	api.Patch("/users/:id", authHandler.UpdateUser)
+	api.Delete("/users/:id", authHandler.DeleteUser)
+	api.Get("/users/:id/logins", authHandler.LoginHistory)
+	api.Delete("/users/:id/lockout", authHandler.UnlockUser)
+	api.Post("/users/:id/reset-token", authHandler.IssueResetToken)
+	api.Post("/users/:id/force-password-change", authHandler.ForcePasswordChange)
+
+	// Admin endpoints
+	api.Get("/admin/backup", adminHandler.Backup)
+	api.Get("/admin/fsck", adminHandler.Fsck)
+	api.Post("/admin/fsck", adminHandler.Fsck)
+	api.Post("/admin/import", adminHandler.Import)
+	api.Get("/admin/settings", adminHandler.GetSettings)
+	api.Patch("/admin/settings", adminHandler.UpdateSettings)
+	api.Get("/admin/webhooks", adminHandler.ListWebhooks)
+	api.Post("/admin/webhooks", adminHandler.CreateWebhook)
+	api.Delete("/admin/webhooks/:id", adminHandler.DeleteWebhook)
+	api.Get("/admin/webhooks/:id/deliveries", adminHandler.ListWebhookDeliveries)
+	api.Post("/admin/webhooks/deliveries/:id/retry", adminHandler.RetryWebhookDelivery)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxSearchResults caps the limit query parameter of Search.
+const maxSearchResults = 100
+
+func (h *FileHandler) Search(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	query := internal.FTSQuery(c.Query("q"))
+	if query == "" {
+		return NewAPIError(fiber.StatusBadRequest, "", "Search query is required")
+	}
+
+	limit := 20
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSearchResults {
+			return NewAPIError(fiber.StatusBadRequest, "", fmt.Sprintf("limit must be between 1 and %d", maxSearchResults))
+		}
+		limit = n
+	}
+
+	// Only the user's personal files and the shared space are searchable
+	stmt := `SELECT md.id, md.filename, md.size, md.uploaded_at,
+			CASE WHEN md.is_shared THEN COALESCE(u.username, '') ELSE 'Me' END,
+			md.mime_type,
+			md.is_shared,
+			snippet(file_index, 1, '<mark>', '</mark>', '…', 12),
+			bm25(file_index)
+		FROM file_index
+		JOIN metadata AS md ON md.id = file_index.rowid
+		LEFT JOIN users AS u ON md.user_id = u.id
+		WHERE file_index MATCH ? AND (md.is_shared = TRUE OR md.user_id = ?)
+		ORDER BY bm25(file_index)
+		LIMIT ?`
+
+	rows, err := h.DB.Query(stmt, query, userID, limit)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to search files")
+	}
+	defer rows.Close()
+
+	hits := make([]models.SearchHit, 0)
+
+	for rows.Next() {
+		var hit models.SearchHit
+		var rank float64
+		if err := rows.Scan(&hit.FileID, &hit.Filename, &hit.Size, &hit.UploadedAt, &hit.UploadedBy, &hit.MimeType, &hit.IsShared, &hit.Snippet, &rank); err != nil {
+			continue
+		}
+
+		// bm25 is negative with better matches further below zero
+		hit.Score = -rank
+		hits = append(hits, hit)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(hits)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/AumSahayata/cloudboxio/tests"
+	"github.com/gofiber/fiber/v2"
+)
+
+// buildDOCX returns a minimal Word document containing text.
+func buildDOCX(t *testing.T, text string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:p><w:r><w:t>%s</w:t></w:r></w:p></w:body></w:document>`, text)
+	zw.Close()
+
+	return buf.String()
+}
+
+// buildPDF returns a minimal PDF whose single page shows text in a compressed stream.
+func buildPDF(t *testing.T, text string) string {
+	t.Helper()
+
+	var stream bytes.Buffer
+	zw := zlib.NewWriter(&stream)
+	fmt.Fprintf(zw, "BT /F1 12 Tf 72 712 Td (%s) Tj ET", text)
+	zw.Close()
+
+	return fmt.Sprintf("%%PDF-1.4\n4 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream\nendobj\n%%%%EOF\n", stream.Len(), stream.String())
+}
+
+func TestSearchFileContents(t *testing.T) {
+	ctx := SetupTestContext(t)
+	tests.SetAdminSetupFlag(ctx.DB, true)
+
+	_, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, ?, ?)`, "other-id", "other", "x", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertTestFile(t, ctx, 1, "notes.txt", "The quarterly budgeting meeting moved to Friday", false)
+	insertTestFile(t, ctx, 2, "plan.docx", buildDOCX(t, "Budget plan for the new office"), false)
+	insertTestFile(t, ctx, 3, "invoice.pdf", buildPDF(t, "Budget invoice total"), true)
+	insertTestFile(t, ctx, 4, "unrelated.txt", "Nothing to see here", false)
+
+	// A personal file of another user must never show up
+	otherPath := filepath.Join(ctx.TempDir, "other-id", "secret.txt")
+	if err := os.MkdirAll(filepath.Dir(otherPath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(otherPath, []byte("secret budget"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctx.DB.Exec(`INSERT INTO metadata (id, user_id, filename, size, path, is_shared, uploaded_at) VALUES (?,?,?,?,?,?,?)`,
+		5, "other-id", "secret.txt", 13, otherPath, false, "today")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id := int64(1); id <= 5; id++ {
+		if err := internal.IndexFile(ctx.DB, id); err != nil {
+			t.Fatalf("failed to index file %d: %v", id, err)
+		}
+	}
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/search", handler.Search)
+	ctx.App.Delete("/file/:fileid", handler.DeleteFile)
+
+	search := func(q string) []models.SearchHit {
+		req := httptest.NewRequest("GET", "/search?q="+q, nil)
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+
+		var hits []models.SearchHit
+		if err := json.NewDecoder(resp.Body).Decode(&hits); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return hits
+	}
+
+	hits := search("budget")
+
+	found := make(map[string]models.SearchHit)
+	for _, hit := range hits {
+		found[hit.Filename] = hit
+	}
+
+	for _, name := range []string{"notes.txt", "plan.docx", "invoice.pdf"} {
+		if _, ok := found[name]; !ok {
+			t.Errorf("expected %s in results, got %+v", name, hits)
+		}
+	}
+	if _, ok := found["secret.txt"]; ok {
+		t.Error("search leaked another user's personal file")
+	}
+	if !strings.Contains(found["notes.txt"].Snippet, "<mark>") {
+		t.Errorf("expected highlighted snippet, got %q", found["notes.txt"].Snippet)
+	}
+
+	// An out of range limit is refused instead of replaced
+	for _, limit := range []string{"0", "101", "ten"} {
+		req := httptest.NewRequest("GET", "/search?q=budget&limit="+limit, nil)
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("limit=%s: expected status %d, got %d", limit, fiber.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	// Deleting a file removes it from the index
+	req := httptest.NewRequest("DELETE", "/file/1", nil)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	if _, err := ctx.App.Test(req, -1); err != nil {
+		t.Fatal("request failed:", err)
+	}
+
+	for _, hit := range search("quarterly") {
+		if hit.Filename == "notes.txt" {
+			t.Error("deleted file still returned by search")
+		}
+	}
+}
+
+func TestIndexerCatchesUpOnLargeStores(t *testing.T) {
+	ctx := SetupTestContext(t)
+	// Every connection to an in-memory database opens a new, empty one
+	ctx.DB.SetMaxOpenConns(1)
+
+	// More unindexed files than the queue holds
+	const files = 1500
+	for i := 1; i <= files; i++ {
+		insertTestFile(t, ctx, i, fmt.Sprintf("note-%d.txt", i), "catch up", false)
+	}
+
+	stop := internal.StartIndexer(ctx.DB)
+	defer stop()
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		var indexed int
+		if err := ctx.DB.QueryRow(`SELECT COUNT(*) FROM file_index`).Scan(&indexed); err != nil {
+			t.Fatal(err)
+		}
+		if indexed == files {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d files to be indexed, got %d", files, indexed)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSettings returns the runtime settings.
+func (h *AdminHandler) GetSettings(c *fiber.Ctx) error {
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can view settings")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(h.Settings.Get())
+}
+
+// UpdateSettings changes the runtime settings present in the body. They take effect immediately.
+func (h *AdminHandler) UpdateSettings(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can change settings")
+	}
+
+	settings, err := h.Settings.Update(c.Body())
+	if err != nil {
+		var settingsErr *internal.SettingsError
+		if errors.As(err, &settingsErr) {
+			return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+		}
+		h.LogError.Println("Failed to update settings:", err)
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to save settings")
+	}
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+	h.LogINFO.Printf("ADMIN user [%s] updated settings: %s", adminUsername, c.Body())
+
+	return c.Status(fiber.StatusOK).JSON(settings)
+}
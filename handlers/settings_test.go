@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSettingsAPI(t *testing.T) {
+	ctx := SetupTestContext(t)
+
+	auth := NewAuthHandler(ctx.DB, ctx.Settings, ctx.Log, ctx.Log)
+	admin := NewAdminHandler(ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log)
+	ctx.App.Post("/register", auth.Register)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/admin/settings", admin.GetSettings)
+	ctx.App.Patch("/admin/settings", admin.UpdateSettings)
+
+	resp := sendJSON(t, ctx, "GET", "/admin/settings", nil)
+	var settings internal.Settings
+	json.NewDecoder(resp.Body).Decode(&settings)
+	if resp.StatusCode != fiber.StatusOK || settings.MaxUploadSizeMB != ctx.Config.MaxUploadSizeMB || settings.Registration != internal.RegistrationClosed {
+		t.Fatalf("unexpected default settings %d %+v", resp.StatusCode, settings)
+	}
+
+	// Invalid values and unknown fields are rejected without changing anything
+	for _, body := range []string{
+		`{"max_upload_size_mb": 0}`,
+		`{"max_upload_size_mb": 100000}`,
+		`{"registration": "sometimes"}`,
+		`{"allowed_mime_types": ["png"]}`,
+		`{"quota": 5}`,
+	} {
+		resp = sendJSON(t, ctx, "PATCH", "/admin/settings", json.RawMessage(body))
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", body, fiber.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	// Registration is closed by default
+	register := func(username string) int {
+		body, _ := json.Marshal(map[string]string{"username": username, "password": "longenough"})
+		req := httptest.NewRequest("POST", "/register", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := register("visitor"); status != fiber.StatusForbidden {
+		t.Fatalf("expected status %d while closed, got %d", fiber.StatusForbidden, status)
+	}
+
+	resp = sendJSON(t, ctx, "PATCH", "/admin/settings", map[string]any{"registration": "open", "default_quota_mb": 1})
+	json.NewDecoder(resp.Body).Decode(&settings)
+	if resp.StatusCode != fiber.StatusOK || settings.Registration != internal.RegistrationOpen || settings.DefaultQuotaMB != 1 {
+		t.Fatalf("unexpected settings after update %d %+v", resp.StatusCode, settings)
+	}
+	if settings.MaxUploadSizeMB != ctx.Config.MaxUploadSizeMB {
+		t.Errorf("fields missing from the update changed: %+v", settings)
+	}
+
+	if status := register("visitor"); status != fiber.StatusCreated {
+		t.Fatalf("expected status %d while open, got %d", fiber.StatusCreated, status)
+	}
+	var isAdmin bool
+	if err := ctx.DB.QueryRow(`SELECT is_admin FROM users WHERE username = 'visitor'`).Scan(&isAdmin); err != nil || isAdmin {
+		t.Fatalf("expected a regular user to be registered, got admin=%v err=%v", isAdmin, err)
+	}
+
+	// Regular users can neither read nor change the settings
+	userCtx := *ctx
+	userCtx.Token = loginAndGetToken(t, ctx.App, "visitor", "longenough")
+	for _, method := range []string{"GET", "PATCH"} {
+		if resp = sendJSON(t, &userCtx, method, "/admin/settings", map[string]any{}); resp.StatusCode != fiber.StatusForbidden {
+			t.Errorf("%s: expected status %d for a regular user, got %d", method, fiber.StatusForbidden, resp.StatusCode)
+		}
+	}
+
+	// The stored settings survive a restart
+	reloaded := testSettings(t, ctx.DB, ctx.Config).Get()
+	if reloaded.Registration != internal.RegistrationOpen || reloaded.DefaultQuotaMB != 1 {
+		t.Fatalf("settings were not persisted: %+v", reloaded)
+	}
+
+	// Settings nobody changed follow the configuration after a restart
+	changedConfig := *ctx.Config
+	changedConfig.Bandwidth.DownloadKBps = 123
+	reloaded = testSettings(t, ctx.DB, &changedConfig).Get()
+	if reloaded.Bandwidth.DownloadKBps != 123 || reloaded.Registration != internal.RegistrationOpen {
+		t.Fatalf("expected the configured bandwidth next to the stored registration, got %+v", reloaded)
+	}
+
+	// null puts a setting back to its configured value
+	resp = sendJSON(t, ctx, "PATCH", "/admin/settings", json.RawMessage(`{"registration": null}`))
+	json.NewDecoder(resp.Body).Decode(&settings)
+	if resp.StatusCode != fiber.StatusOK || settings.Registration != internal.RegistrationClosed || settings.DefaultQuotaMB != 1 {
+		t.Fatalf("unexpected settings after clearing registration %d %+v", resp.StatusCode, settings)
+	}
+	var stored int
+	ctx.DB.QueryRow(`SELECT COUNT(*) FROM settings WHERE key = 'registration'`).Scan(&stored)
+	if stored != 0 {
+		t.Errorf("expected the cleared setting to be removed from the database")
+	}
+}
+
+func TestUploadEnforcesSettings(t *testing.T) {
+	ctx := SetupTestContext(t)
+
+	handler := NewFileHandler(ctx.DB, ctx.Config, ctx.Settings)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/upload:shared?", handler.UploadFile)
+
+	upload := func(name, content string) int {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+
+		resp, err := ctx.App.Test(req, -1)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	update := func(patch string) {
+		if _, err := ctx.Settings.Update([]byte(patch)); err != nil {
+			t.Fatal("failed to update settings:", err)
+		}
+	}
+
+	large := strings.Repeat("a", 3<<19)
+	if status := upload("large.txt", large); status != fiber.StatusCreated {
+		t.Fatalf("expected status %d, got %d", fiber.StatusCreated, status)
+	}
+
+	// Changes apply to the next request
+	update(`{"max_upload_size_mb": 1}`)
+	if status := upload("large-2.txt", large); status != fiber.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d above the limit, got %d", fiber.StatusRequestEntityTooLarge, status)
+	}
+
+	update(`{"allowed_mime_types": ["image/*"]}`)
+	if status := upload("notes.txt", "plain text"); status != fiber.StatusUnsupportedMediaType {
+		t.Fatalf("expected status %d for a type that is not allowed, got %d", fiber.StatusUnsupportedMediaType, status)
+	}
+	if status := upload("photo.png", pngHeader); status != fiber.StatusCreated {
+		t.Fatalf("expected status %d for an allowed type, got %d", fiber.StatusCreated, status)
+	}
+
+	// The first upload already uses more than the quota
+	update(`{"default_quota_mb": 1}`)
+	if status := upload("photo-2.png", pngHeader); status != fiber.StatusInsufficientStorage {
+		t.Fatalf("expected status %d over the quota, got %d", fiber.StatusInsufficientStorage, status)
+	}
+	update(`{"default_quota_mb": 0}`)
+	if status := upload("photo-2.png", pngHeader); status != fiber.StatusCreated {
+		t.Fatalf("expected status %d without a quota, got %d", fiber.StatusCreated, status)
+	}
+}
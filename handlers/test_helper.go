@@ -17,11 +17,13 @@ import (
 )
 
 type TestContext struct {
-	App     *fiber.App
-	DB      *sql.DB
-	Log     *log.Logger
-	Token   string
-	TempDir string
+	App      *fiber.App
+	DB       *sql.DB
+	Config   *internal.Config
+	Settings *internal.SettingsStore
+	Log      *log.Logger
+	Token    string
+	TempDir  string
 }
 
 func SetupTestContext(t *testing.T) *TestContext {
@@ -45,28 +47,47 @@ func SetupTestContext(t *testing.T) *TestContext {
 		t.Fatalf("Failed to insert user for testing:, %v", err)
 	}
 
+	// Store files in the temp dir
+	tempDir := setupTestEnv(t)
+	cfg := internal.DefaultConfig()
+	cfg.FilesDir = tempDir
+	cfg.SharedDir = "shared"
+	settings := testSettings(t, database, cfg)
+
 	// Setup app
-	app := fiber.New()
-	handler := NewAuthHandler(database, voidLogger, voidLogger)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	handler := NewAuthHandler(database, settings, voidLogger, voidLogger)
 	app.Post("/login", handler.Login)
 
 	tests.SetAdminSetupFlag(database, true)
 
 	// Login to get token
 	token := loginAndGetToken(t, app, username, password)
-	tempDir := setupTestEnv(t)
 
 	t.Cleanup(func() {
 		cleanupTestAppAndFiles(t, app, database, tempDir)
 	})
 
 	return &TestContext{
-		App:     app,
-		DB:      database,
-		Log:     voidLogger,
-		Token:   token,
-		TempDir: tempDir,
+		App:      app,
+		DB:       database,
+		Config:   cfg,
+		Settings: settings,
+		Log:      voidLogger,
+		Token:    token,
+		TempDir:  tempDir,
+	}
+}
+
+// testSettings loads the runtime settings of a test database, defaulting to cfg.
+func testSettings(t *testing.T, db *sql.DB, cfg *internal.Config) *internal.SettingsStore {
+	t.Helper()
+
+	settings, err := internal.LoadSettings(db, cfg)
+	if err != nil {
+		t.Fatal("Failed to load settings:", err)
 	}
+	return settings
 }
 
 // LoginAndGetToken logs in with the given credentials and returns the JWT token
@@ -118,10 +139,6 @@ func setupTestEnv(t *testing.T) string {
 		t.Fatal("Failed to create base dir:", err)
 	}
 
-	// Set env variables
-	os.Setenv("FILES_DIR", tempDir)
-	os.Setenv("SHARED_DIR", "shared")
-
 	// Initialize FileOps logger to avoid nil panic
 	internal.FileOps = log.New(io.Discard, "", 0)
 
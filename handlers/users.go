@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on user profile details.
+const (
+	maxDisplayNameLength = 100
+	maxEmailLength       = 254
+)
+
+// userInfoColumns are the columns scanUserInfo reads, in order.
+const userInfoColumns = `id, username, display_name, email, is_admin, disabled, must_change_password`
+
+// scanUserInfo reads a user selected with userInfoColumns.
+func scanUserInfo(row interface{ Scan(...any) error }) (models.UserInfo, error) {
+	var u models.UserInfo
+	err := row.Scan(&u.ID, &u.Username, &u.DisplayName, &u.Email, &u.IsAdmin, &u.Disabled, &u.MustChangePassword)
+	return u, err
+}
+
+// countActiveAdmins returns how many admins other than exceptID are not disabled.
+func countActiveAdmins(q interface {
+	QueryRow(string, ...any) *sql.Row
+}, exceptID string) (int, error) {
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM users WHERE is_admin = TRUE AND disabled = FALSE AND id != ?`, exceptID).Scan(&count)
+	return count, err
+}
+
+// validateUserUpdate trims the fields of req that are present and checks them.
+func validateUserUpdate(req *models.UserUpdate) error {
+	if req.Username != nil {
+		*req.Username = strings.TrimSpace(*req.Username)
+		if *req.Username == "" {
+			return errors.New("username cannot be empty")
+		}
+	}
+	if req.DisplayName != nil {
+		*req.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(*req.DisplayName) > maxDisplayNameLength {
+			return fmt.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+		}
+	}
+	if req.Email != nil {
+		*req.Email = strings.TrimSpace(*req.Email)
+		// An empty email removes it
+		if *req.Email != "" {
+			addr, err := mail.ParseAddress(*req.Email)
+			if err != nil || addr.Address != *req.Email || len(*req.Email) > maxEmailLength {
+				return errors.New("email address is not valid")
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateUser changes the username, display name, email, admin role or disabled state of a
+// user. Users may change their own display name and email, everything else is admin only.
+// The last admin that is not disabled cannot be demoted or disabled.
+func (h *AuthHandler) UpdateUser(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	targetID, err := internal.CleanParam(c.Params("id"))
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "User ID provided is not proper")
+	}
+
+	var req models.UserUpdate
+	if err := c.BodyParser(&req); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
+	}
+
+	if !isAdmin && (targetID != userID || req.Username != nil || req.IsAdmin != nil || req.Disabled != nil) {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can change usernames, roles and other users")
+	}
+	if targetID == userID && req.Disabled != nil && *req.Disabled {
+		return NewAPIError(fiber.StatusForbidden, "", "Cannot disable self")
+	}
+	if err := validateUserUpdate(&req); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update user")
+	}
+	defer tx.Rollback()
+
+	var oldUsername string
+	if err := tx.QueryRow(`SELECT username FROM users WHERE id = ?`, targetID).Scan(&oldUsername); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return NewAPIError(fiber.StatusNotFound, "", "User not found")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update user")
+	}
+
+	// Absent fields are passed as NULL and keep their value
+	_, err = tx.Exec(`UPDATE users SET
+			username = COALESCE(?, username),
+			display_name = COALESCE(?, display_name),
+			email = COALESCE(?, email),
+			is_admin = COALESCE(?, is_admin),
+			disabled = COALESCE(?, disabled)
+		WHERE id = ?`,
+		req.Username, req.DisplayName, req.Email, req.IsAdmin, req.Disabled, targetID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return NewAPIError(fiber.StatusBadRequest, "", "Username already exists")
+		}
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update user")
+	}
+
+	// Counted after the update, inside the transaction, so concurrent requests cannot
+	// demote the last two admins at once
+	if (req.IsAdmin != nil && !*req.IsAdmin) || (req.Disabled != nil && *req.Disabled) {
+		adminCount, err := countActiveAdmins(tx, "")
+		if err != nil {
+			return NewAPIError(fiber.StatusInternalServerError, "", "Failed to check admin count")
+		}
+		if adminCount == 0 {
+			return NewAPIError(fiber.StatusForbidden, "", "Cannot demote or disable the only remaining admin user")
+		}
+	}
+
+	user, err := scanUserInfo(tx.QueryRow(`SELECT `+userInfoColumns+` FROM users WHERE id = ?`, targetID))
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update user")
+	}
+	if err := tx.Commit(); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to update user")
+	}
+
+	if targetID == userID && !isAdmin {
+		h.LogINFO.Printf("User (%s) updated the profile", user.Username)
+	} else {
+		adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+		if err != nil {
+			adminUsername = userID
+		}
+		h.LogINFO.Printf("ADMIN user [%s] updated user (%s): %s", adminUsername, oldUsername, describeUserUpdate(req))
+	}
+	internal.PublishEvent(internal.Event{Type: internal.EventUserUpdated, Username: user.Username, UserID: user.ID})
+
+	return c.Status(fiber.StatusOK).JSON(user)
+}
+
+// describeUserUpdate lists the changes of req for the admin log.
+func describeUserUpdate(req models.UserUpdate) string {
+	var changes []string
+	if req.Username != nil {
+		changes = append(changes, fmt.Sprintf("username=%q", *req.Username))
+	}
+	if req.DisplayName != nil {
+		changes = append(changes, fmt.Sprintf("display_name=%q", *req.DisplayName))
+	}
+	if req.Email != nil {
+		changes = append(changes, fmt.Sprintf("email=%q", *req.Email))
+	}
+	if req.IsAdmin != nil {
+		changes = append(changes, fmt.Sprintf("is_admin=%t", *req.IsAdmin))
+	}
+	if req.Disabled != nil {
+		changes = append(changes, fmt.Sprintf("disabled=%t", *req.Disabled))
+	}
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changes, " ")
+}
+
+// deletedUsersDir is the directory under FILES_DIR that keeps the exports and archives of
+// deleted users. Like the quarantine it starts with a dot, so fsck leaves it alone.
+const deletedUsersDir = ".deleted-users"
+
+// userDeletion records what happened to the personal files of a deleted user.
+type userDeletion struct {
+	mode  string
+	files int
+	// export is the ZIP of the files made before the deletion
+	export string
+	// location is where the personal directory was moved, empty for a purge
+	location string
+}
+
+func (d userDeletion) String() string {
+	if d.files == 0 {
+		return "no personal files"
+	}
+	switch d.mode {
+	case models.UserFilesTransfer:
+		return fmt.Sprintf("%d personal file(s) exported to %s and transferred to %s", d.files, d.export, d.location)
+	case models.UserFilesArchive:
+		return fmt.Sprintf("%d personal file(s) exported to %s and archived in %s", d.files, d.export, d.location)
+	default:
+		return fmt.Sprintf("%d personal file(s) exported to %s and purged", d.files, d.export)
+	}
+}
+
+// deleteUserWithFiles deletes a user after exporting the personal files to a ZIP, and then
+// archives, transfers or purges them as mode says. Shared files stay shared and pass to
+// newOwner. The database changes happen in one transaction, and the files are moved back
+// if it fails.
+func (h *AuthHandler) deleteUserWithFiles(id, username, mode, newOwner string) (userDeletion, error) {
+	deletion := userDeletion{mode: mode}
+
+	files, err := h.personalFiles(id)
+	if err != nil {
+		return deletion, fmt.Errorf("failed to query files: %w", err)
+	}
+	deletion.files = len(files)
+
+	archive := filepath.Join(h.Config.FilesDir, deletedUsersDir, id+"-"+time.Now().UTC().Format("20060102-150405"))
+	if len(files) > 0 {
+		deletion.export = archive + ".zip"
+		if err := exportFiles(deletion.export, files); err != nil {
+			return deletion, fmt.Errorf("failed to export files: %w", err)
+		}
+	}
+
+	var folder string
+	switch mode {
+	case models.UserFilesTransfer:
+		if folder, err = h.transferFolder(newOwner, username, id); err != nil {
+			return deletion, fmt.Errorf("failed to choose a folder for the files: %w", err)
+		}
+		deletion.location = filepath.Join(h.Config.FilesDir, newOwner, filepath.FromSlash(folder))
+	case models.UserFilesArchive:
+		deletion.location = archive
+	}
+
+	// The personal directory is moved as a whole, so a failure is undone with one rename
+	userDir := filepath.Join(h.Config.FilesDir, id)
+	moved := false
+	if _, err := os.Stat(userDir); err == nil && deletion.location != "" {
+		if err := os.MkdirAll(filepath.Dir(deletion.location), os.ModePerm); err != nil {
+			return deletion, fmt.Errorf("failed to create folder: %w", err)
+		}
+		if err := os.Rename(userDir, deletion.location); err != nil {
+			return deletion, fmt.Errorf("failed to move files: %w", err)
+		}
+		moved = true
+	}
+
+	// Transferred files keep their place in the moved directory, under the new folder
+	names := make([]string, len(files))
+	paths := make([]string, len(files))
+	for i, f := range files {
+		names[i], paths[i] = path.Join(folder, f.Filename), f.Path
+		if rel, err := filepath.Rel(userDir, f.Path); moved && err == nil && !strings.HasPrefix(rel, "..") {
+			paths[i] = filepath.Join(deletion.location, rel)
+		}
+	}
+
+	if err := h.deleteUserRows(id, mode, newOwner, files, names, paths); err != nil {
+		if moved {
+			if rerr := os.Rename(deletion.location, userDir); rerr != nil {
+				internal.FileOps.Println("Error restoring files of user:", rerr)
+			}
+		}
+		// Nothing was deleted, so the export is not needed
+		if deletion.export != "" {
+			os.Remove(deletion.export)
+		}
+		return deletion, err
+	}
+
+	for i, f := range files {
+		if mode == models.UserFilesTransfer {
+			internal.PublishEvent(internal.Event{Type: internal.EventFileRenamed, FileID: f.ID, Filename: names[i], OldName: f.Filename, OwnerID: newOwner})
+			continue
+		}
+		if mode == models.UserFilesPurge {
+			if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+				internal.FileOps.Println("Error deleting file:", err)
+			}
+		}
+		internal.RemoveThumbnails(h.Config.FilesDir, f.ID)
+		internal.PublishEvent(internal.Event{Type: internal.EventFileDeleted, FileID: f.ID, Filename: f.Filename, OwnerID: id})
+	}
+	if mode == models.UserFilesPurge {
+		if err := os.RemoveAll(userDir); err != nil {
+			internal.FileOps.Println("Error deleting user directory:", err)
+		}
+	}
+
+	internal.FileOps.Printf("Deleted user [%s]: %s", id, deletion)
+	return deletion, nil
+}
+
+// deleteUserRows deletes a user and everything recorded about them in one transaction.
+// Personal files are deleted as well, or with a transfer given to newOwner under the new
+// names and paths. Shared files pass to newOwner.
+func (h *AuthHandler) deleteUserRows(id, mode, newOwner string, files []*fileRecord, names, paths []string) error {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if mode == models.UserFilesTransfer {
+		for i, f := range files {
+			if _, err := tx.Exec(`UPDATE metadata SET user_id = ?, filename = ?, path = ? WHERE id = ?`, newOwner, names[i], paths[i], f.ID); err != nil {
+				return fmt.Errorf("failed to transfer file: %w", err)
+			}
+		}
+	} else if _, err := tx.Exec(`DELETE FROM metadata WHERE user_id = ? AND is_shared = FALSE`, id); err != nil {
+		return fmt.Errorf("failed to delete files: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE metadata SET user_id = ? WHERE user_id = ? AND is_shared = TRUE`, newOwner, id); err != nil {
+		return fmt.Errorf("failed to transfer shared files: %w", err)
+	}
+	for _, stmt := range []string{
+		`DELETE FROM favorites WHERE user_id = ?`,
+		`DELETE FROM recent_files WHERE user_id = ?`,
+		`DELETE FROM import_log WHERE user_id = ?`,
+		`DELETE FROM login_history WHERE user_id = ?`,
+		`DELETE FROM password_reset_tokens WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+	}
+	// The failed logins of the account go with it
+	if _, err := tx.Exec(`DELETE FROM login_failures WHERE key = ?`, internal.AccountFailureKey(id)); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// personalFiles lists the files in the personal space of a user.
+func (h *AuthHandler) personalFiles(userID string) ([]*fileRecord, error) {
+	rows, err := h.DB.Query(`SELECT id, user_id, filename, size, path, is_shared, uploaded_at, mime_type FROM metadata WHERE user_id = ? AND is_shared = FALSE`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*fileRecord
+	for rows.Next() {
+		var f fileRecord
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Filename, &f.Size, &f.Path, &f.IsShared, &f.UploadedAt, &f.MimeType); err != nil {
+			return nil, err
+		}
+		files = append(files, &f)
+	}
+
+	return files, rows.Err()
+}
+
+// transferFolder picks the folder of the new owner that receives the files of a deleted
+// user: the username, numbered when the new owner already has such a folder.
+func (h *AuthHandler) transferFolder(newOwner, username, id string) (string, error) {
+	base, err := cleanFolder(username)
+	if err != nil || base == "" {
+		base = id
+	}
+
+	folder := base
+	for n := 1; ; n++ {
+		var taken bool
+		err := h.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM metadata WHERE user_id = ? AND is_shared = FALSE AND (filename = ? OR filename LIKE ? ESCAPE '\'))`,
+			newOwner, folder, escapeLike(folder)+"/%").Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(filepath.Join(h.Config.FilesDir, newOwner, filepath.FromSlash(folder))); !taken && os.IsNotExist(err) {
+			return folder, nil
+		}
+		folder = fmt.Sprintf("%s(%d)", base, n)
+	}
+}
+
+// exportFiles writes files to a ZIP at dest, which appears only once it is complete.
+func exportFiles(dest string, files []*fileRecord) error {
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeZipArchive(tmp, files); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
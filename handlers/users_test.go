@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUpdateUser(t *testing.T) {
+	ctx := SetupTestContext(t)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := requestJSON(t, app, "POST", "/api/signup", ctx.Token, models.SignUp{Username: "alice", Password: "alice-password"}, nil); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("signup failed with %d", resp.StatusCode)
+	}
+	var aliceID string
+	if err := ctx.DB.QueryRow(`SELECT id FROM users WHERE username = 'alice'`).Scan(&aliceID); err != nil {
+		t.Fatal(err)
+	}
+	login := func(username, password string) (int, string) {
+		var body map[string]any
+		resp := requestJSON(t, app, "POST", "/api/login", "", models.Login{Username: username, Password: password}, &body)
+		token, _ := body["token"].(string)
+		return resp.StatusCode, token
+	}
+	_, aliceToken := login("alice", "alice-password")
+
+	str := func(s string) *string { return &s }
+	yes, no := true, false
+	update := func(token, id string, req models.UserUpdate) (int, models.UserInfo) {
+		var user models.UserInfo
+		resp := requestJSON(t, app, "PATCH", "/api/users/"+id, token, req, &user)
+		return resp.StatusCode, user
+	}
+
+	// Admins change every field, absent fields keep their value
+	status, user := update(ctx.Token, aliceID, models.UserUpdate{Username: str(" alicia "), DisplayName: str("Alice A."), Email: str("alice@example.com")})
+	if status != fiber.StatusOK || user.Username != "alicia" || user.DisplayName != "Alice A." || user.Email != "alice@example.com" || user.IsAdmin {
+		t.Fatalf("unexpected update %d %+v", status, user)
+	}
+	if status, _ := login("alicia", "alice-password"); status != fiber.StatusOK {
+		t.Fatalf("expected the new username to log in, got %d", status)
+	}
+
+	invalid := []models.UserUpdate{
+		{Username: str("testuser")},
+		{Username: str("  ")},
+		{Email: str("not an email")},
+		{Email: str("Alice <alice@example.com>")},
+	}
+	for i, req := range invalid {
+		if status, _ := update(ctx.Token, aliceID, req); status != fiber.StatusBadRequest {
+			t.Errorf("case %d: expected status %d, got %d", i, fiber.StatusBadRequest, status)
+		}
+	}
+	if status, _ := update(ctx.Token, "missing", models.UserUpdate{DisplayName: str("x")}); status != fiber.StatusNotFound {
+		t.Errorf("expected status %d for an unknown user, got %d", fiber.StatusNotFound, status)
+	}
+
+	// Users change only their own profile details
+	if status, user := update(aliceToken, aliceID, models.UserUpdate{DisplayName: str("Alicia"), Email: str("")}); status != fiber.StatusOK || user.DisplayName != "Alicia" || user.Email != "" {
+		t.Fatalf("unexpected self update %d %+v", status, user)
+	}
+	for i, req := range []models.UserUpdate{{IsAdmin: &yes}, {Username: str("root")}, {Disabled: &no}} {
+		if status, _ := update(aliceToken, aliceID, req); status != fiber.StatusForbidden {
+			t.Errorf("case %d: expected status %d, got %d", i, fiber.StatusForbidden, status)
+		}
+	}
+	if status, _ := update(aliceToken, "test-id", models.UserUpdate{DisplayName: str("x")}); status != fiber.StatusForbidden {
+		t.Errorf("expected status %d for another user, got %d", fiber.StatusForbidden, status)
+	}
+
+	// Role changes apply to tokens issued before
+	if status, _ := update(ctx.Token, aliceID, models.UserUpdate{IsAdmin: &yes}); status != fiber.StatusOK {
+		t.Fatalf("promote failed with %d", status)
+	}
+	if resp := requestJSON(t, app, "GET", "/api/users", aliceToken, nil, nil); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected a promoted user to list users, got %d", resp.StatusCode)
+	}
+
+	// Disabled users can neither log in nor use their token
+	if status, user := update(ctx.Token, aliceID, models.UserUpdate{Disabled: &yes}); status != fiber.StatusOK || !user.Disabled {
+		t.Fatalf("disable failed with %d %+v", status, user)
+	}
+	if status, _ := login("alicia", "alice-password"); status != fiber.StatusForbidden {
+		t.Errorf("expected a disabled user to be refused at login, got %d", status)
+	}
+	if resp := requestJSON(t, app, "GET", "/api/files", aliceToken, nil, nil); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected the token of a disabled user to be refused, got %d", resp.StatusCode)
+	}
+
+	// A disabled admin does not count, the remaining admin cannot be demoted or disabled
+	if status, _ := update(ctx.Token, "test-id", models.UserUpdate{IsAdmin: &no}); status != fiber.StatusForbidden {
+		t.Errorf("expected the last active admin to stay admin, got %d", status)
+	}
+	if status, _ := update(ctx.Token, "test-id", models.UserUpdate{Disabled: &yes}); status != fiber.StatusForbidden {
+		t.Errorf("expected admins not to disable themselves, got %d", status)
+	}
+	var isAdmin bool
+	ctx.DB.QueryRow(`SELECT is_admin FROM users WHERE id = 'test-id'`).Scan(&isAdmin)
+	if !isAdmin {
+		t.Fatal("a refused demotion was saved")
+	}
+
+	// Once enabled again the other admin can be demoted
+	if status, _ := update(ctx.Token, aliceID, models.UserUpdate{Disabled: &no}); status != fiber.StatusOK {
+		t.Fatalf("enable failed with %d", status)
+	}
+	if status, _ := update(aliceToken, "test-id", models.UserUpdate{IsAdmin: &no}); status != fiber.StatusOK {
+		t.Fatalf("expected an admin to be demoted while another is left, got %d", status)
+	}
+	if resp := requestJSON(t, app, "GET", "/api/users", ctx.Token, nil, nil); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected a demoted admin to lose access at once, got %d", resp.StatusCode)
+	}
+
+	var users []models.UserInfo
+	requestJSON(t, app, "GET", "/api/users", aliceToken, nil, &users)
+	if len(users) != 2 {
+		t.Fatalf("unexpected users %+v", users)
+	}
+	for _, u := range users {
+		if u.ID == aliceID && (!u.IsAdmin || u.Disabled || u.DisplayName != "Alicia") {
+			t.Errorf("unexpected user %+v", u)
+		}
+	}
+}
+
+// insertUserFile stores a file of userID like an upload would and returns its path.
+func insertUserFile(t *testing.T, ctx *TestContext, userID string, id int, filename, content string, shared bool) string {
+	t.Helper()
+
+	dir := filepath.Join(ctx.TempDir, userID)
+	if shared {
+		dir = filepath.Join(ctx.TempDir, "shared")
+	}
+	savePath := filepath.Join(dir, filepath.FromSlash(filename))
+	if err := os.MkdirAll(filepath.Dir(savePath), os.ModePerm); err != nil {
+		t.Fatal("failed to create dir:", err)
+	}
+	if err := os.WriteFile(savePath, []byte(content), 0644); err != nil {
+		t.Fatal("failed to write file:", err)
+	}
+	_, err := ctx.DB.Exec(`INSERT INTO metadata (id, user_id, filename, size, path, is_shared, uploaded_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, userID, filename, len(content), savePath, shared, "2025-06-01 10:00:00")
+	if err != nil {
+		t.Fatal("failed to insert file:", err)
+	}
+	return savePath
+}
+
+func TestDeleteUserFiles(t *testing.T) {
+	ctx := SetupTestContext(t)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	if err := RegisterAPI(app, ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		if _, err := ctx.DB.Exec(`INSERT INTO users (id, username, password, is_admin) VALUES (?, ?, 'x', FALSE)`, name+"-id", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove := func(target string) int {
+		t.Helper()
+		return requestJSON(t, app, "DELETE", target, ctx.Token, nil, nil).StatusCode
+	}
+	exports := func(userID string) []string {
+		t.Helper()
+		matches, _ := filepath.Glob(filepath.Join(ctx.TempDir, deletedUsersDir, userID+"-*.zip"))
+		return matches
+	}
+	zipEntries := func(name string) []string {
+		t.Helper()
+		r, err := zip.OpenReader(name)
+		if err != nil {
+			t.Fatal("failed to open export:", err)
+		}
+		defer r.Close()
+		var entries []string
+		for _, f := range r.File {
+			entries = append(entries, f.Name)
+		}
+		sort.Strings(entries)
+		return entries
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	// Invalid choices change nothing
+	for target, want := range map[string]int{
+		"/api/users/alice-id?files=keep":                   fiber.StatusBadRequest,
+		"/api/users/alice-id?files=transfer":               fiber.StatusBadRequest,
+		"/api/users/alice-id?files=transfer&to=alice-id":   fiber.StatusBadRequest,
+		"/api/users/alice-id?files=transfer&to=missing-id": fiber.StatusBadRequest,
+		"/api/users/missing-id?files=transfer&to=bob-id":   fiber.StatusNotFound,
+	} {
+		if status := remove(target); status != want {
+			t.Errorf("%s: expected status %d, got %d", target, want, status)
+		}
+	}
+
+	// By default personal files are exported and archived, shared files pass to the admin
+	insertUserFile(t, ctx, "alice-id", 1, "notes.txt", "notes", false)
+	plan := insertUserFile(t, ctx, "alice-id", 2, "docs/plan.txt", "plan", false)
+	insertUserFile(t, ctx, "alice-id", 3, "team.txt", "team", true)
+	if status := remove("/api/users/alice-id"); status != fiber.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", fiber.StatusNoContent, status)
+	}
+	zips := exports("alice-id")
+	if len(zips) != 1 || strings.Join(zipEntries(zips[0]), ",") != "docs/plan.txt,notes.txt" {
+		t.Fatalf("unexpected export %v", zips)
+	}
+	archived := filepath.Join(strings.TrimSuffix(zips[0], ".zip"), "docs", "plan.txt")
+	if exists(plan) || !exists(archived) {
+		t.Errorf("expected %s to be archived as %s", plan, archived)
+	}
+	var personal int
+	ctx.DB.QueryRow(`SELECT COUNT(*) FROM metadata WHERE user_id = 'alice-id'`).Scan(&personal)
+	if personal != 0 {
+		t.Errorf("expected the files of alice to be gone, %d left", personal)
+	}
+	var shared []models.File
+	requestJSON(t, app, "GET", "/api/files?shared=true", ctx.Token, nil, &shared)
+	if len(shared) != 1 || shared[0].Filename != "team.txt" || shared[0].UploadedBy != "testuser" {
+		t.Errorf("expected the shared file to stay listed, got %+v", shared)
+	}
+
+	// A transfer lands in a folder named after the user, numbered when bob has one already
+	insertUserFile(t, ctx, "bob-id", 4, "carol/old.txt", "old", false)
+	insertUserFile(t, ctx, "carol-id", 5, "report.txt", "report", false)
+	insertUserFile(t, ctx, "carol-id", 6, "carol-shared.txt", "shared", true)
+	if status := remove("/api/users/carol-id?files=transfer&to=bob-id"); status != fiber.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", fiber.StatusNoContent, status)
+	}
+	var owner, filename, savedPath string
+	ctx.DB.QueryRow(`SELECT user_id, filename, path FROM metadata WHERE id = 5`).Scan(&owner, &filename, &savedPath)
+	want := filepath.Join(ctx.TempDir, "bob-id", "carol(1)", "report.txt")
+	if owner != "bob-id" || filename != "carol(1)/report.txt" || savedPath != want || !exists(want) {
+		t.Errorf("unexpected transfer: %s %s %s", owner, filename, savedPath)
+	}
+	ctx.DB.QueryRow(`SELECT user_id FROM metadata WHERE id = 6`).Scan(&owner)
+	if owner != "bob-id" || len(exports("carol-id")) != 1 {
+		t.Errorf("expected the shared file to pass to bob and an export, got owner %s", owner)
+	}
+
+	// A purge deletes the files after the export, and the tokens of the user stop working
+	daveToken, err := internal.GenerateToken("dave-id", true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone := insertUserFile(t, ctx, "dave-id", 7, "secret.txt", "secret", false)
+	for _, stmt := range []string{
+		`INSERT INTO login_history (user_id, ip, result) VALUES ('dave-id', '0.0.0.0', 'success')`,
+		`INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ('digest', 'dave-id', 0)`,
+		`INSERT INTO login_failures (key, failures, last_failure, blocked_until) VALUES ('account:dave-id', 3, 0, 0)`,
+	} {
+		if _, err := ctx.DB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := remove("/api/users/dave-id?files=purge"); status != fiber.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", fiber.StatusNoContent, status)
+	}
+	var leftovers int
+	ctx.DB.QueryRow(`SELECT (SELECT COUNT(*) FROM login_history WHERE user_id = 'dave-id') +
+		(SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = 'dave-id') +
+		(SELECT COUNT(*) FROM login_failures WHERE key = 'account:dave-id')`).Scan(&leftovers)
+	if leftovers != 0 {
+		t.Errorf("expected the login records of dave to be deleted, %d left", leftovers)
+	}
+	for _, target := range []string{"/api/files", "/api/users"} {
+		if resp := requestJSON(t, app, "GET", target, daveToken, nil, nil); resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("%s: expected the token of a deleted user to be refused, got %d", target, resp.StatusCode)
+		}
+	}
+	if exists(gone) || exists(filepath.Join(ctx.TempDir, "dave-id")) || len(exports("dave-id")) != 1 {
+		t.Errorf("expected the files of dave to be purged after an export")
+	}
+
+	// When the database refuses, the files are put back and the export is dropped
+	kept := insertUserFile(t, ctx, "erin-id", 8, "kept.txt", "kept", false)
+	if _, err := ctx.DB.Exec(`CREATE TRIGGER refuse_delete BEFORE DELETE ON users WHEN OLD.id = 'erin-id' BEGIN SELECT RAISE(ABORT, 'refused'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if status := remove("/api/users/erin-id"); status != fiber.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", fiber.StatusInternalServerError, status)
+	}
+	ctx.DB.QueryRow(`SELECT user_id FROM metadata WHERE id = 8`).Scan(&owner)
+	if !exists(kept) || owner != "erin-id" || len(exports("erin-id")) != 0 {
+		t.Errorf("expected a failed delete to change nothing")
+	}
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *AdminHandler) ListWebhooks(c *fiber.Ctx) error {
+	if !c.Locals("is_admin").(bool) {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can manage webhooks")
+	}
+
+	rows, err := h.DB.Query(`SELECT id, url, events, include_personal, created_at FROM webhooks ORDER BY id`)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query webhooks")
+	}
+	defer rows.Close()
+
+	webhooks := make([]models.Webhook, 0)
+	for rows.Next() {
+		var w models.Webhook
+		var events string
+		if err := rows.Scan(&w.ID, &w.URL, &events, &w.IncludePersonal, &w.CreatedAt); err != nil {
+			continue
+		}
+		w.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, w)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(webhooks)
+}
+
+func (h *AdminHandler) CreateWebhook(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can manage webhooks")
+	}
+
+	var req models.CreateWebhook
+	if err := c.BodyParser(&req); err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Invalid input")
+	}
+
+	target, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return NewAPIError(fiber.StatusBadRequest, "", "URL must be an absolute http or https URL")
+	}
+
+	events, err := normalizeWebhookEvents(req.Events)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", err.Error())
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = internal.GenerateWebhookSecret(); err != nil {
+			return NewAPIError(fiber.StatusInternalServerError, "", "Failed to generate webhook secret")
+		}
+	}
+
+	res, err := h.DB.Exec(`INSERT INTO webhooks (url, secret, events, include_personal, created_by) VALUES (?, ?, ?, ?, ?)`,
+		target.String(), secret, strings.Join(events, ","), req.IncludePersonal, userID)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to create webhook")
+	}
+	id, _ := res.LastInsertId()
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+	h.LogINFO.Printf("ADMIN user [%s] registered webhook %d to %s for %s", adminUsername, id, target.Host, strings.Join(events, ","))
+
+	// The secret is only ever shown here, receivers need it to verify signatures
+	webhook := models.Webhook{
+		ID:              id,
+		URL:             target.String(),
+		Events:          events,
+		IncludePersonal: req.IncludePersonal,
+		Secret:          secret,
+	}
+	_ = h.DB.QueryRow(`SELECT created_at FROM webhooks WHERE id = ?`, id).Scan(&webhook.CreatedAt)
+
+	return c.Status(fiber.StatusCreated).JSON(webhook)
+}
+
+func (h *AdminHandler) DeleteWebhook(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	isAdmin := c.Locals("is_admin").(bool)
+
+	if !isAdmin {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can manage webhooks")
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Webhook ID provided is not proper")
+	}
+
+	res, err := h.DB.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to delete webhook")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return NewAPIError(fiber.StatusNotFound, "", "Webhook not found")
+	}
+
+	adminUsername, err := internal.GetUsernameByID(userID, h.DB)
+	if err != nil {
+		adminUsername = userID
+	}
+	h.LogINFO.Printf("ADMIN user [%s] deleted webhook %d", adminUsername, id)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AdminHandler) ListWebhookDeliveries(c *fiber.Ctx) error {
+	if !c.Locals("is_admin").(bool) {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can manage webhooks")
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Webhook ID provided is not proper")
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query webhook")
+	}
+	if !exists {
+		return NewAPIError(fiber.StatusNotFound, "", "Webhook not found")
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	// Newest first, optionally narrowed to one status such as failed
+	stmt := `SELECT id, webhook_id, event_type, payload, status, attempts, response_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = ?`
+	args := []any{id}
+	if status := c.Query("status"); status != "" {
+		stmt += ` AND status = ?`
+		args = append(args, status)
+	}
+	stmt += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := h.DB.Query(stmt, args...)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to query deliveries")
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var deliveredAt sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &d.CreatedAt, &deliveredAt); err != nil {
+			continue
+		}
+		d.DeliveredAt = deliveredAt.String
+		deliveries = append(deliveries, d)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(deliveries)
+}
+
+func (h *AdminHandler) RetryWebhookDelivery(c *fiber.Ctx) error {
+	if !c.Locals("is_admin").(bool) {
+		return NewAPIError(fiber.StatusForbidden, "", "Only admin can manage webhooks")
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return NewAPIError(fiber.StatusBadRequest, "", "Delivery ID provided is not proper")
+	}
+
+	found, err := internal.RetryWebhookDelivery(h.DB, id)
+	if err != nil {
+		return NewAPIError(fiber.StatusInternalServerError, "", "Failed to schedule delivery")
+	}
+	if !found {
+		return NewAPIError(fiber.StatusNotFound, "", "Delivery not found")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"id": id, "status": internal.DeliveryPending})
+}
+
+// normalizeWebhookEvents validates and deduplicates the event types of a webhook, replacing
+// aliases such as file.uploaded with the event type they stand for.
+// Subscribing to "*" covers every event, including ones added later.
+func normalizeWebhookEvents(events []string) ([]string, error) {
+	seen := make(map[string]bool, len(events))
+	result := make([]string, 0, len(events))
+
+	for _, name := range events {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := internal.WebhookEventAliases[name]; ok {
+			name = alias
+		}
+		if name == "" || seen[name] {
+			continue
+		}
+		if !internal.IsWebhookEvent(name) {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Unknown event %q, expected one of %s or %s", name, strings.Join(internal.WebhookEventTypes, ", "), internal.WebhookAllEvents))
+		}
+		if name == internal.WebhookAllEvents {
+			return []string{internal.WebhookAllEvents}, nil
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+
+	if len(result) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "At least one event is required")
+	}
+
+	return result, nil
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AumSahayata/cloudboxio/internal"
+	"github.com/AumSahayata/cloudboxio/models"
+)
+
+type receivedWebhook struct {
+	event     string
+	signature string
+	timestamp string
+	body      []byte
+}
+
+func TestWebhookDeliveryRetriesUntilSuccess(t *testing.T) {
+	ctx := SetupTestContext(t)
+	internal.Error = log.New(io.Discard, "", 0)
+
+	// The in-memory database only exists on one connection, share it with the dispatcher
+	ctx.DB.SetMaxOpenConns(1)
+
+	handler := NewAdminHandler(ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Get("/admin/webhooks", handler.ListWebhooks)
+	ctx.App.Post("/admin/webhooks", handler.CreateWebhook)
+	ctx.App.Get("/admin/webhooks/:id/deliveries", handler.ListWebhookDeliveries)
+	ctx.App.Post("/admin/webhooks/deliveries/:id/retry", handler.RetryWebhookDelivery)
+
+	// The receiver fails the first request so the delivery has to be retried
+	var mu sync.Mutex
+	var received []receivedWebhook
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		received = append(received, receivedWebhook{
+			event:     r.Header.Get("X-CloudBoxIO-Event"),
+			signature: r.Header.Get("X-CloudBoxIO-Signature"),
+			timestamp: r.Header.Get("X-CloudBoxIO-Timestamp"),
+			body:      body,
+		})
+		first := len(received) == 1
+		mu.Unlock()
+
+		if first {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	stop := internal.StartWebhooks(ctx.DB, internal.WebhookConfig{
+		MaxAttempts:  3,
+		BaseDelay:    20 * time.Millisecond,
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer stop()
+
+	// Unknown events are rejected
+	resp := sendJSON(t, ctx, "POST", "/admin/webhooks", map[string]any{"url": receiver.URL, "events": []string{"file.exploded"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown event, got %d", resp.StatusCode)
+	}
+
+	// file.uploaded is another name for file.created
+	resp = sendJSON(t, ctx, "POST", "/admin/webhooks", map[string]any{"url": receiver.URL, "events": []string{"file.uploaded", internal.EventFileCreated}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var webhook models.Webhook
+	json.NewDecoder(resp.Body).Decode(&webhook)
+	if webhook.Secret == "" {
+		t.Fatal("expected a generated secret on create")
+	}
+	if len(webhook.Events) != 1 || webhook.Events[0] != internal.EventFileCreated {
+		t.Fatalf("expected the alias to be stored as %s, got %v", internal.EventFileCreated, webhook.Events)
+	}
+
+	// Personal files and unsubscribed events are not delivered
+	internal.PublishEvent(internal.Event{Type: internal.EventFileCreated, FileID: 1, Filename: "private.txt", OwnerID: "test-id"})
+	internal.PublishEvent(internal.Event{Type: internal.EventFileDeleted, FileID: 2, Filename: "gone.txt", IsShared: true})
+	internal.PublishEvent(internal.Event{Type: internal.EventFileCreated, FileID: 3, Filename: "report.pdf", IsShared: true})
+
+	deliveries := waitForDeliveries(t, ctx, webhook.ID, func(d []models.WebhookDelivery) bool {
+		return len(d) == 1 && d[0].Status == internal.DeliverySucceeded
+	})
+
+	d := deliveries[0]
+	if d.EventType != internal.EventFileCreated || d.Attempts != 2 || d.ResponseCode != http.StatusOK || d.DeliveredAt == "" {
+		t.Fatalf("unexpected delivery log entry: %+v", d)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 requests at the receiver, got %d", len(received))
+	}
+	for _, r := range received {
+		ts, _ := strconv.ParseInt(r.timestamp, 10, 64)
+		if r.event != internal.EventFileCreated || r.signature != internal.SignWebhook(webhook.Secret, ts, r.body) {
+			t.Fatalf("request not signed for the webhook secret: %+v", r)
+		}
+
+		var e internal.Event
+		if err := json.Unmarshal(r.body, &e); err != nil || e.Filename != "report.pdf" {
+			t.Fatalf("unexpected payload: %s", r.body)
+		}
+	}
+
+	// The secret is never listed
+	resp = sendJSON(t, ctx, "GET", "/admin/webhooks", nil)
+	var listed []models.Webhook
+	json.NewDecoder(resp.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].Secret != "" {
+		t.Fatalf("unexpected webhook list: %+v", listed)
+	}
+}
+
+func TestWebhookDeliveryGivesUpAndCanBeRetried(t *testing.T) {
+	ctx := SetupTestContext(t)
+	internal.Error = log.New(io.Discard, "", 0)
+	ctx.DB.SetMaxOpenConns(1)
+
+	handler := NewAdminHandler(ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/admin/webhooks", handler.CreateWebhook)
+	ctx.App.Get("/admin/webhooks/:id/deliveries", handler.ListWebhookDeliveries)
+	ctx.App.Post("/admin/webhooks/deliveries/:id/retry", handler.RetryWebhookDelivery)
+
+	var mu sync.Mutex
+	healthy := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	stop := internal.StartWebhooks(ctx.DB, internal.WebhookConfig{
+		MaxAttempts:  2,
+		BaseDelay:    10 * time.Millisecond,
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer stop()
+
+	resp := sendJSON(t, ctx, "POST", "/admin/webhooks", map[string]any{"url": receiver.URL, "events": []string{"*"}, "secret": "s3cret"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var webhook models.Webhook
+	json.NewDecoder(resp.Body).Decode(&webhook)
+
+	internal.PublishEvent(internal.Event{Type: internal.EventUserCreated, Username: "alice", UserID: "alice-id"})
+
+	deliveries := waitForDeliveries(t, ctx, webhook.ID, func(d []models.WebhookDelivery) bool {
+		return len(d) == 1 && d[0].Status == internal.DeliveryFailed
+	})
+	if d := deliveries[0]; d.Attempts != 2 || d.ResponseCode != http.StatusServiceUnavailable || d.LastError == "" {
+		t.Fatalf("unexpected failed delivery: %+v", d)
+	}
+
+	mu.Lock()
+	healthy = true
+	mu.Unlock()
+
+	resp = sendJSON(t, ctx, "POST", fmt.Sprintf("/admin/webhooks/deliveries/%d/retry", deliveries[0].ID), nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202 on retry, got %d", resp.StatusCode)
+	}
+
+	deliveries = waitForDeliveries(t, ctx, webhook.ID, func(d []models.WebhookDelivery) bool {
+		return len(d) == 1 && d[0].Status == internal.DeliverySucceeded
+	})
+	if deliveries[0].Attempts != 3 {
+		t.Fatalf("expected a third attempt after the retry, got %d", deliveries[0].Attempts)
+	}
+
+	resp = sendJSON(t, ctx, "POST", "/admin/webhooks/deliveries/999/retry", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown delivery, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebhookDeliveriesAreRecordedOnPublish(t *testing.T) {
+	ctx := SetupTestContext(t)
+	internal.Error = log.New(io.Discard, "", 0)
+	ctx.DB.SetMaxOpenConns(1)
+
+	handler := NewAdminHandler(ctx.DB, ctx.Config, ctx.Settings, ctx.Log, ctx.Log)
+	ctx.App.Use(internal.JWTProtected(ctx.DB))
+	ctx.App.Post("/admin/webhooks", handler.CreateWebhook)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	stop := internal.StartWebhooks(ctx.DB, internal.WebhookConfig{Timeout: time.Second})
+	defer stop()
+
+	resp := sendJSON(t, ctx, "POST", "/admin/webhooks", map[string]any{"url": receiver.URL, "events": []string{"*"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+
+	// A burst larger than any event bus buffer, such as a bulk delete, loses nothing
+	const events = 500
+	for i := 1; i <= events; i++ {
+		internal.PublishEvent(internal.Event{Type: internal.EventFileDeleted, FileID: int64(i), IsShared: true})
+	}
+
+	var recorded int
+	if err := ctx.DB.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != events {
+		t.Fatalf("expected %d deliveries to be recorded, got %d", events, recorded)
+	}
+}
+
+// sendJSON sends an authenticated request with an optional JSON body to the test app.
+func sendJSON(t *testing.T, ctx *TestContext, method, target string, body any) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ctx.App.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, target, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// waitForDeliveries polls the delivery log of a webhook until done accepts it.
+func waitForDeliveries(t *testing.T, ctx *TestContext, webhookID int64, done func([]models.WebhookDelivery) bool) []models.WebhookDelivery {
+	t.Helper()
+
+	var deliveries []models.WebhookDelivery
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp := sendJSON(t, ctx, "GET", fmt.Sprintf("/admin/webhooks/%d/deliveries", webhookID), nil)
+		deliveries = nil
+		json.NewDecoder(resp.Body).Decode(&deliveries)
+		if done(deliveries) {
+			return deliveries
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("delivery log did not reach the expected state: %+v", deliveries)
+	return nil
+}
+
+func TestWebhookMaxAttemptsIsBounded(t *testing.T) {
+	for _, attempts := range []int{0, 51, math.MaxInt} {
+		cfg := internal.DefaultConfig()
+		cfg.WebhookMaxAttempts = attempts
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected webhook_max_attempts %d to be rejected", attempts)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BackupFormatVersion is bumped whenever the archive layout changes.
+const BackupFormatVersion = 1
+
+const (
+	backupDBName       = "data.db"
+	backupManifestName = "manifest.json"
+	backupFilesPrefix  = "files/"
+)
+
+// BackupEntry describes a single file stored in a backup archive.
+type BackupEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupManifest is written as the last entry of every backup archive.
+type BackupManifest struct {
+	FormatVersion int           `json:"format_version"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Database      BackupEntry   `json:"database"`
+	Files         []BackupEntry `json:"files"`
+}
+
+// SnapshotDB writes a consistent copy of the live database to dest using VACUUM INTO.
+func SnapshotDB(db *sql.DB, dest string) error {
+	// VACUUM INTO refuses to overwrite an existing file
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clear snapshot path: %w", err)
+	}
+
+	if _, err := db.Exec(`VACUUM INTO ?`, dest); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+
+	return nil
+}
+
+// CreateBackup snapshots the database and writes a complete archive to w.
+func CreateBackup(db *sql.DB, filesDir string, w io.Writer) error {
+	tmpDir, err := os.MkdirTemp("", "cloudboxio-backup-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshot := filepath.Join(tmpDir, backupDBName)
+	if err := SnapshotDB(db, snapshot); err != nil {
+		return err
+	}
+
+	return WriteBackup(w, snapshot, filesDir)
+}
+
+// WriteBackup writes a gzipped tar archive containing the database snapshot,
+// every file under filesDir and a manifest with their checksums.
+func WriteBackup(w io.Writer, snapshotPath, filesDir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest := BackupManifest{
+		FormatVersion: BackupFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Files:         make([]BackupEntry, 0),
+	}
+
+	entry, err := addFileToTar(tw, snapshotPath, backupDBName)
+	if err != nil {
+		return fmt.Errorf("failed to archive database: %w", err)
+	}
+	manifest.Database = entry
+
+	// Walk the storage tree, a missing directory simply means no files yet
+	err = filepath.WalkDir(filesDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Thumbnails are a cache and are regenerated on demand
+		if d.IsDir() && d.Name() == ThumbnailDir && filepath.Dir(p) == filepath.Clean(filesDir) {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(filesDir, p)
+		if err != nil {
+			return err
+		}
+
+		entry, err := addFileToTar(tw, p, backupFilesPrefix+filepath.ToSlash(rel))
+		if err != nil {
+			// The file was deleted while the backup was running
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		manifest.Files = append(manifest.Files, entry)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive files: %w", err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	hdr := &tar.Header{
+		Name:    backupManifestName,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: manifest.CreatedAt,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// addFileToTar copies the file at src into the archive under name and returns its checksum entry.
+func addFileToTar(tw *tar.Writer, src, name string) (BackupEntry, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return BackupEntry{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return BackupEntry{}, err
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return BackupEntry{}, err
+	}
+
+	hash := sha256.New()
+	if _, err := io.CopyN(tw, io.TeeReader(file, hash), info.Size()); err != nil {
+		return BackupEntry{}, fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+
+	return BackupEntry{
+		Path:   name,
+		Size:   info.Size(),
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// RestoreBackup verifies the archive read from r against its manifest and, only if every
+// checksum matches, installs the database at dbPath and the files under filesDir.
+// Everything is staged next to the live data first and then swapped in with renames, which
+// are undone if a later step fails. The files that were under filesDir before are kept in
+// the quarantine, so files missing from the backup are not left behind as orphans.
+func RestoreBackup(r io.Reader, dbPath, filesDir string) (*BackupManifest, error) {
+	tmpDir, err := os.MkdirTemp("", "cloudboxio-restore-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	manifest, err := extractBackup(r, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stage on the same file systems as the live data so the swap is only renames
+	stamp := time.Now().UTC().Format("20060102-150405")
+	staging := filepath.Join(filesDir, ".restore-"+stamp)
+	if err := os.MkdirAll(staging, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	for _, entry := range manifest.Files {
+		rel := strings.TrimPrefix(entry.Path, backupFilesPrefix)
+		dest := filepath.Join(staging, filepath.FromSlash(rel))
+		if err := copyFile(filepath.Join(tmpDir, filepath.FromSlash(entry.Path)), dest); err != nil {
+			return nil, fmt.Errorf("failed to stage %s: %w", rel, err)
+		}
+	}
+
+	stagedDB := dbPath + ".restore"
+	defer os.Remove(stagedDB)
+	if err := copyFile(filepath.Join(tmpDir, backupDBName), stagedDB); err != nil {
+		return nil, fmt.Errorf("failed to stage database: %w", err)
+	}
+
+	var swap renames
+	if err := swapRestoredFiles(&swap, filesDir, staging, filepath.Join(filesDir, QuarantineDir, "restore-"+stamp)); err != nil {
+		swap.undo()
+		return nil, fmt.Errorf("failed to install files: %w", err)
+	}
+
+	// Stale WAL files are moved aside with the old database so SQLite does not replay them
+	// over the restored one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := swap.move(dbPath+suffix, dbPath+suffix+".previous"); err != nil && !os.IsNotExist(err) {
+			swap.undo()
+			return nil, fmt.Errorf("failed to move %s aside: %w", dbPath+suffix, err)
+		}
+	}
+	if err := swap.move(dbPath, dbPath+".previous"); err != nil && !os.IsNotExist(err) {
+		swap.undo()
+		return nil, fmt.Errorf("failed to move database aside: %w", err)
+	}
+	if err := os.Rename(stagedDB, dbPath); err != nil {
+		swap.undo()
+		return nil, fmt.Errorf("failed to install database: %w", err)
+	}
+
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(dbPath + suffix + ".previous")
+	}
+	// Thumbnails are a cache of the replaced files
+	if err := os.RemoveAll(filepath.Join(filesDir, ThumbnailDir)); err != nil {
+		return nil, fmt.Errorf("failed to clear thumbnails: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// renames records renames so they can be undone in reverse order.
+type renames [][2]string
+
+func (r *renames) move(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	*r = append(*r, [2]string{from, to})
+	return nil
+}
+
+func (r renames) undo() {
+	for i := len(r) - 1; i >= 0; i-- {
+		if err := os.Rename(r[i][1], r[i][0]); err != nil && Error != nil {
+			Error.Printf("Failed to move %s back to %s: %v", r[i][1], r[i][0], err)
+		}
+	}
+}
+
+// swapRestoredFiles moves the user and shared directories of filesDir to previous and the
+// staged ones into their place. Internal directories such as the quarantine stay, the staged
+// files of internal directories are added to them.
+func swapRestoredFiles(swap *renames, filesDir, staging, previous string) error {
+	live, err := os.ReadDir(filesDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range live {
+		if !strings.HasPrefix(e.Name(), ".") {
+			if err := swap.move(filepath.Join(filesDir, e.Name()), filepath.Join(previous, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	staged, err := os.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, e := range staged {
+		if !strings.HasPrefix(e.Name(), ".") {
+			if err := swap.move(filepath.Join(staging, e.Name()), filepath.Join(filesDir, e.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := filepath.WalkDir(filepath.Join(staging, e.Name()), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(staging, p)
+			if err != nil {
+				return err
+			}
+			dest := filepath.Join(filesDir, rel)
+			if _, err := os.Stat(dest); err == nil {
+				return nil
+			}
+			return swap.move(p, dest)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyBackup checks every entry of the archive against its manifest without installing anything.
+func VerifyBackup(r io.Reader) (*BackupManifest, error) {
+	tmpDir, err := os.MkdirTemp("", "cloudboxio-verify-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	return extractBackup(r, tmpDir)
+}
+
+// extractBackup unpacks the archive into dir and validates it against the manifest.
+func extractBackup(r io.Reader, dir string) (*BackupManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("archive is not gzip compressed: %w", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	checksums := make(map[string]BackupEntry)
+	var manifest *BackupManifest
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if strings.HasPrefix(name, "../") || name == ".." || path.IsAbs(name) {
+			return nil, fmt.Errorf("archive entry %q escapes the restore directory", hdr.Name)
+		}
+
+		if name == backupManifestName {
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			continue
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+			return nil, err
+		}
+
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(out, hash), tr)
+		out.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+
+		checksums[name] = BackupEntry{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("archive has no manifest")
+	}
+	if manifest.FormatVersion > BackupFormatVersion {
+		return nil, fmt.Errorf("unsupported backup format version %d", manifest.FormatVersion)
+	}
+
+	// Every manifest entry must be present with a matching checksum
+	expected := append([]BackupEntry{manifest.Database}, manifest.Files...)
+	for _, entry := range expected {
+		got, ok := checksums[entry.Path]
+		if !ok {
+			return nil, fmt.Errorf("archive is missing %s", entry.Path)
+		}
+		if got.Size != entry.Size || got.SHA256 != entry.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s", entry.Path)
+		}
+		delete(checksums, entry.Path)
+	}
+
+	for name := range checksums {
+		return nil, fmt.Errorf("archive contains %s which is not listed in the manifest", name)
+	}
+
+	return manifest, nil
+}
+
+// copyFile copies src to dst, creating parent directories as needed.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
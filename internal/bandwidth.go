@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// throttleChunk is the most bytes a throttled download sends between two waits.
+const throttleChunk = 32 << 10
+
+// BandwidthLimiter caps the download and upload throughput of each user as the runtime
+// settings say. Its state only covers the last second or so of transfers and is kept in memory.
+type BandwidthLimiter struct {
+	settings *SettingsStore
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewBandwidthLimiter returns a limiter reading the caps from settings.
+func NewBandwidthLimiter(settings *SettingsStore) *BandwidthLimiter {
+	return &BandwidthLimiter{settings: settings, buckets: map[string]*tokenBucket{}}
+}
+
+// Reader paces reads from r to the download cap of the user. A nil limiter returns r.
+func (b *BandwidthLimiter) Reader(userID string, r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &throttledReader{r: r, wait: b.downloadWait(userID)}
+}
+
+// Writer paces writes to w to the download cap of the user. A nil limiter returns w.
+func (b *BandwidthLimiter) Writer(userID string, w io.Writer) io.Writer {
+	if b == nil {
+		return w
+	}
+	return &throttledWriter{w: w, wait: b.downloadWait(userID)}
+}
+
+// DownloadLimited reports whether downloads are capped at the moment.
+func (b *BandwidthLimiter) DownloadLimited() bool {
+	return b != nil && b.settings.Get().Bandwidth.DownloadKBps > 0
+}
+
+// downloadWait returns how long to wait before sending n bytes to the user. The cap is read
+// for every chunk, so a change applies to transfers in progress.
+func (b *BandwidthLimiter) downloadWait(userID string) func(n int) time.Duration {
+	return func(n int) time.Duration {
+		kbps := b.settings.Get().Bandwidth.DownloadKBps
+		if kbps == 0 {
+			return 0
+		}
+		return b.reserve("download:"+userID, n, kbps)
+	}
+}
+
+// Uploads keeps each user to the upload cap on a route. Request bodies are received in full
+// before handlers run, so an upload is accepted while the user is within the cap and its
+// size is then charged to them. Further uploads are refused until the cap allows that many
+// bytes again. It must run after JWTProtected.
+func (b *BandwidthLimiter) Uploads() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := c.Locals("user_id").(string)
+		kbps := b.settings.Get().Bandwidth.UploadKBps
+		if userID == "" || kbps == 0 {
+			return c.Next()
+		}
+
+		if wait := b.bucket("upload:"+userID).admit(float64(len(c.Body())), float64(kbps)*1024); wait > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+			return fiber.NewError(fiber.StatusTooManyRequests, "Upload bandwidth limit exceeded. Try again later.")
+		}
+		return c.Next()
+	}
+}
+
+// bucket returns the token bucket of key.
+func (b *BandwidthLimiter) bucket(key string) *tokenBucket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		b.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// reserve takes n bytes from the bucket of key and returns how long to wait before sending them.
+func (b *BandwidthLimiter) reserve(key string, n, kbps int) time.Duration {
+	return b.bucket(key).reserve(float64(n), float64(kbps)*1024)
+}
+
+// tokenBucket refills at a fixed rate up to one second worth of bytes. A reservation may
+// overdraw it, the caller then waits until the debt would have been refilled.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (t *tokenBucket) reserve(n, rate float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refill(rate)
+	t.tokens -= n
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / rate * float64(time.Second))
+}
+
+// admit takes n bytes unless the bucket is overdrawn. It returns how long until it is not.
+func (t *tokenBucket) admit(n, rate float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refill(rate)
+	if t.tokens < 0 {
+		return time.Duration(-t.tokens / rate * float64(time.Second))
+	}
+	t.tokens -= n
+	return 0
+}
+
+func (t *tokenBucket) refill(rate float64) {
+	now := time.Now()
+	if t.last.IsZero() {
+		t.tokens = rate
+	} else {
+		t.tokens = min(rate, t.tokens+now.Sub(t.last).Seconds()*rate)
+	}
+	t.last = now
+}
+
+// throttledReader waits before handing out each chunk of the underlying reader.
+type throttledReader struct {
+	r    io.Reader
+	wait func(n int) time.Duration
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		time.Sleep(t.wait(n))
+	}
+	return n, err
+}
+
+// Close closes the underlying reader, fasthttp closes body streams once they are sent.
+func (t *throttledReader) Close() error {
+	if closer, ok := t.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// throttledWriter waits before passing on each chunk to the underlying writer.
+type throttledWriter struct {
+	w    io.Writer
+	wait func(n int) time.Duration
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		time.Sleep(t.wait(len(chunk)))
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
@@ -0,0 +1,511 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is read at startup when it exists and no other file is named.
+const DefaultConfigFile = "config.yaml"
+
+// maxWebhookAttempts bounds webhook_max_attempts, retries are an hour apart long before this.
+const maxWebhookAttempts = 50
+
+// Config holds every server setting. It is loaded once at startup by LoadConfig and passed
+// to the parts of the server that need it.
+type Config struct {
+	Port         int    `yaml:"port"`
+	FilesDir     string `yaml:"files_dir"`
+	SharedDir    string `yaml:"shared_dir"`
+	UseDefaultUI bool   `yaml:"use_default_ui"`
+
+	// MaxUploadSizeMB limits the size of a request body
+	MaxUploadSizeMB int `yaml:"max_upload_size_mb"`
+	// BlockedMIMETypes are refused at upload, entries may end in /* to block a whole family
+	BlockedMIMETypes []string `yaml:"blocked_mime_types"`
+
+	// JWTSecret signs the session tokens. When empty a random one is kept in JWTSecretFile.
+	JWTSecret     string `yaml:"jwt_secret"`
+	JWTSecretFile string `yaml:"jwt_secret_file"`
+
+	Log LogConfig `yaml:"log"`
+	// RateLimit applies to every request per client IP, LoginRateLimit to failed logins and
+	// registrations per client IP and UserRateLimit to API requests per logged in user
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
+	LoginRateLimit RateLimitConfig      `yaml:"login_rate_limit"`
+	UserRateLimit  RateLimitConfig      `yaml:"user_rate_limit"`
+	Lockout        LockoutConfig        `yaml:"lockout"`
+	PasswordPolicy PasswordPolicyConfig `yaml:"password_policy"`
+	Bandwidth      BandwidthConfig      `yaml:"bandwidth"`
+	TLS            TLSConfig            `yaml:"tls"`
+
+	// CORS applies to browsers on other origins calling the API, PublicCORS to the paths
+	// anyone may fetch without logging in
+	CORS       CORSPolicy       `yaml:"cors"`
+	PublicCORS PublicCORSPolicy `yaml:"public_cors"`
+
+	// FsckIntervalHours schedules the storage consistency check, 0 disables it
+	FsckIntervalHours  int `yaml:"fsck_interval_hours"`
+	ThumbnailWorkers   int `yaml:"thumbnail_workers"`
+	WebhookMaxAttempts int `yaml:"webhook_max_attempts"`
+}
+
+type LogConfig struct {
+	ToConsole bool `yaml:"to_console"`
+	FileOps   bool `yaml:"file_ops"`
+}
+
+type RateLimitConfig struct {
+	Enabled           bool `yaml:"enabled" json:"enabled"`
+	Max               int  `yaml:"max" json:"max"`
+	ExpirationSeconds int  `yaml:"expiration_seconds" json:"expiration_seconds"`
+}
+
+// LockoutConfig slows down password guessing per account and per client IP. Failures are
+// forgotten LockoutSeconds after the last one.
+type LockoutConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// FreeAttempts are the failures in a row allowed without a delay
+	FreeAttempts int `yaml:"free_attempts" json:"free_attempts"`
+	// DelaySeconds blocks logins after the first failure past the free attempts, each
+	// further failure doubles it
+	DelaySeconds int `yaml:"delay_seconds" json:"delay_seconds"`
+	// MaxFailures in a row block logins for LockoutSeconds
+	MaxFailures    int `yaml:"max_failures" json:"max_failures"`
+	LockoutSeconds int `yaml:"lockout_seconds" json:"lockout_seconds"`
+}
+
+// PasswordPolicyConfig is what passwords must satisfy wherever users choose one.
+type PasswordPolicyConfig struct {
+	MinLength        int  `yaml:"min_length"`
+	RequireLowercase bool `yaml:"require_lowercase"`
+	RequireUppercase bool `yaml:"require_uppercase"`
+	RequireDigit     bool `yaml:"require_digit"`
+	RequireSymbol    bool `yaml:"require_symbol"`
+	// BreachedListFile lists passwords that are refused, one per line, either in plain
+	// text or as SHA-1 hex digests such as the Have I Been Pwned downloads
+	BreachedListFile string `yaml:"breached_list_file"`
+}
+
+// BandwidthConfig caps the transfer rate of each user in KB per second, 0 is unlimited.
+type BandwidthConfig struct {
+	DownloadKBps int `yaml:"download_kbps" json:"download_kbps"`
+	UploadKBps   int `yaml:"upload_kbps" json:"upload_kbps"`
+}
+
+type TLSConfig struct {
+	// Mode is off, files, self_signed or acme
+	Mode     string `yaml:"mode"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Hosts are added to the names a self-signed certificate is valid for
+	Hosts []string `yaml:"hosts"`
+	// RedirectPort serves redirects to HTTPS, and ACME challenges, over plain HTTP. 0 disables it.
+	RedirectPort int        `yaml:"redirect_port"`
+	ACME         ACMEConfig `yaml:"acme"`
+}
+
+type ACMEConfig struct {
+	Domains      []string `yaml:"domains"`
+	Email        string   `yaml:"email"`
+	DirectoryURL string   `yaml:"directory_url"`
+	// CACertFile is a CA trusted for the directory, such as the root of a Pebble test server
+	CACertFile string `yaml:"ca_cert_file"`
+	CacheDir   string `yaml:"cache_dir"`
+}
+
+type CORSPolicy struct {
+	// AllowedOrigins are origins such as https://dash.example.com, patterns such as
+	// https://*.example.com, "regex:" followed by a regular expression, or "*" for any.
+	// When empty, the API allows the server's own address on 127.0.0.1 and localhost.
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	// MaxAgeSeconds lets browsers cache a preflight response, 0 leaves it to the browser
+	MaxAgeSeconds int `yaml:"max_age_seconds"`
+}
+
+type PublicCORSPolicy struct {
+	CORSPolicy `yaml:",inline"`
+	// Paths the public policy applies to, including everything below them
+	Paths []string `yaml:"paths"`
+}
+
+// DefaultConfig returns the settings used for anything not configured.
+func DefaultConfig() *Config {
+	return &Config{
+		Port:             3000,
+		FilesDir:         "uploads/",
+		SharedDir:        "shared/",
+		UseDefaultUI:     true,
+		MaxUploadSizeMB:  100,
+		BlockedMIMETypes: strings.Split(DefaultBlockedMIMETypes, ","),
+		JWTSecretFile:    ".jwt_secret",
+		Log: LogConfig{
+			ToConsole: true,
+			FileOps:   true,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:           true,
+			Max:               30,
+			ExpirationSeconds: 30,
+		},
+		LoginRateLimit: RateLimitConfig{
+			Enabled:           true,
+			Max:               10,
+			ExpirationSeconds: 300,
+		},
+		UserRateLimit: RateLimitConfig{
+			Enabled:           true,
+			Max:               600,
+			ExpirationSeconds: 60,
+		},
+		Lockout: LockoutConfig{
+			Enabled:        true,
+			FreeAttempts:   3,
+			DelaySeconds:   1,
+			MaxFailures:    10,
+			LockoutSeconds: 900,
+		},
+		PasswordPolicy: PasswordPolicyConfig{
+			MinLength: 8,
+		},
+		TLS: TLSConfig{
+			Mode:     TLSOff,
+			CertFile: "certs/cert.pem",
+			KeyFile:  "certs/key.pem",
+			Hosts:    []string{},
+			ACME: ACMEConfig{
+				Domains:      []string{},
+				DirectoryURL: autocert.DefaultACMEDirectory,
+				CacheDir:     "certs/acme",
+			},
+		},
+		CORS: CORSPolicy{
+			AllowedOrigins: []string{},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID"},
+			ExposedHeaders: []string{"X-Total-Count", "X-Next-Cursor", "X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
+			MaxAgeSeconds:  600,
+		},
+		PublicCORS: PublicCORSPolicy{
+			CORSPolicy: CORSPolicy{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET", "HEAD", "OPTIONS"},
+				AllowedHeaders: []string{"Origin", "Accept", "Range", "X-Request-ID"},
+				ExposedHeaders: []string{"Content-Length", "Content-Range", "Content-Disposition", "X-Request-ID"},
+				MaxAgeSeconds:  3600,
+			},
+			Paths: []string{"/api/openapi.json", "/api/docs", "/api/v2/openapi.json", "/api/v2/docs"},
+		},
+		FsckIntervalHours:  24,
+		ThumbnailWorkers:   2,
+		WebhookMaxAttempts: 8,
+	}
+}
+
+// LoadConfig builds the configuration from, in increasing order of precedence, the defaults,
+// a YAML file, environment variables and command line flags, then validates it.
+//
+// The file is the -config flag, else $CONFIG_FILE, else config.yaml when it exists. The
+// environment variables keep the names of the original .env settings, such as PORT and
+// FILES_DIR, and a .env file in the working directory is still loaded for them.
+func LoadConfig(args []string) (*Config, error) {
+	// A first pass over the flags only to learn which file to read. Flag errors and -h
+	// are reported by the second pass.
+	var path string
+	probe := newConfigFlagSet(DefaultConfig(), &path)
+	probe.SetOutput(io.Discard)
+	_ = probe.Parse(args)
+
+	cfg := DefaultConfig()
+
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("CONFIG_FILE")
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultConfigFile
+	}
+	if err := cfg.loadFile(path); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := newConfigFlagSet(cfg, &path).Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// newConfigFlagSet returns the command line flags of the server, bound to cfg.
+func newConfigFlagSet(cfg *Config, configPath *string) *flag.FlagSet {
+	fset := flag.NewFlagSet("cloudboxio", flag.ContinueOnError)
+	fset.StringVar(configPath, "config", "", "YAML configuration file (default "+DefaultConfigFile+" when it exists)")
+	fset.IntVar(&cfg.Port, "port", cfg.Port, "port to listen on")
+	fset.StringVar(&cfg.FilesDir, "files-dir", cfg.FilesDir, "directory the files are stored in")
+	fset.StringVar(&cfg.SharedDir, "shared-dir", cfg.SharedDir, "directory of the shared space, relative to the files directory")
+	fset.BoolVar(&cfg.UseDefaultUI, "ui", cfg.UseDefaultUI, "serve the embedded web UI")
+	fset.IntVar(&cfg.MaxUploadSizeMB, "max-upload-size-mb", cfg.MaxUploadSizeMB, "largest accepted request body in MB")
+	fset.BoolVar(&cfg.RateLimit.Enabled, "rate-limit", cfg.RateLimit.Enabled, "limit the request rate per client")
+	fset.StringVar(&cfg.TLS.Mode, "tls", cfg.TLS.Mode, "TLS mode: off, files, self_signed or acme")
+	fset.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "TLS certificate file")
+	fset.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "TLS key file")
+	fset.IntVar(&cfg.TLS.RedirectPort, "http-redirect-port", cfg.TLS.RedirectPort, "port redirecting plain HTTP to HTTPS, 0 to disable")
+	return fset
+}
+
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Unknown keys are most likely typos, report them instead of ignoring the setting
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies the environment variables that are set.
+func (cfg *Config) loadEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+
+	str := func(name string, target *string) {
+		if value, ok := lookup(name); ok {
+			*target = value
+		}
+	}
+	num := func(name string, target *int) {
+		if value, ok := lookup(name); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a whole number", name, value))
+				return
+			}
+			*target = n
+		}
+	}
+	boolean := func(name string, target *bool) {
+		if value, ok := lookup(name); ok {
+			b, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not true or false", name, value))
+				return
+			}
+			*target = b
+		}
+	}
+
+	num("PORT", &cfg.Port)
+	str("FILES_DIR", &cfg.FilesDir)
+	str("SHARED_DIR", &cfg.SharedDir)
+	boolean("USE_DEFAULT_UI", &cfg.UseDefaultUI)
+	num("MAX_UPLOAD_SIZE_MB", &cfg.MaxUploadSizeMB)
+	if value, ok := lookup("BLOCKED_MIME_TYPES"); ok {
+		cfg.BlockedMIMETypes = splitList(value)
+	}
+	str("JWT_SECRET", &cfg.JWTSecret)
+	str("JWT_SECRET_FILE", &cfg.JWTSecretFile)
+	boolean("LOG_TO_CONSOLE", &cfg.Log.ToConsole)
+	boolean("LOG_FILE_OPS", &cfg.Log.FileOps)
+	boolean("ENABLE_RATE_LIMIT", &cfg.RateLimit.Enabled)
+	num("RATE_LIMIT_MAX", &cfg.RateLimit.Max)
+	num("RATE_LIMIT_EXPIRATION_SECOND", &cfg.RateLimit.ExpirationSeconds)
+	num("LOGIN_RATE_LIMIT_MAX", &cfg.LoginRateLimit.Max)
+	num("USER_RATE_LIMIT_MAX", &cfg.UserRateLimit.Max)
+	boolean("LOCKOUT_ENABLED", &cfg.Lockout.Enabled)
+	num("LOCKOUT_MAX_FAILURES", &cfg.Lockout.MaxFailures)
+	num("LOCKOUT_SECONDS", &cfg.Lockout.LockoutSeconds)
+	num("PASSWORD_MIN_LENGTH", &cfg.PasswordPolicy.MinLength)
+	str("BREACHED_PASSWORDS_FILE", &cfg.PasswordPolicy.BreachedListFile)
+	num("DOWNLOAD_KBPS", &cfg.Bandwidth.DownloadKBps)
+	num("UPLOAD_KBPS", &cfg.Bandwidth.UploadKBps)
+	str("TLS_MODE", &cfg.TLS.Mode)
+	str("TLS_CERT_FILE", &cfg.TLS.CertFile)
+	str("TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	if value, ok := lookup("TLS_HOSTS"); ok {
+		cfg.TLS.Hosts = splitList(value)
+	}
+	num("TLS_REDIRECT_PORT", &cfg.TLS.RedirectPort)
+	if value, ok := lookup("ACME_DOMAINS"); ok {
+		cfg.TLS.ACME.Domains = splitList(value)
+	}
+	str("ACME_EMAIL", &cfg.TLS.ACME.Email)
+	str("ACME_DIRECTORY_URL", &cfg.TLS.ACME.DirectoryURL)
+	if value, ok := lookup("CORS_ALLOWED_ORIGINS"); ok {
+		cfg.CORS.AllowedOrigins = splitList(value)
+	}
+	boolean("CORS_ALLOW_CREDENTIALS", &cfg.CORS.AllowCredentials)
+	num("FSCK_INTERVAL_HOURS", &cfg.FsckIntervalHours)
+	num("THUMBNAIL_WORKERS", &cfg.ThumbnailWorkers)
+	num("WEBHOOK_MAX_ATTEMPTS", &cfg.WebhookMaxAttempts)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+	invalid := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{name}, args...)...))
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		invalid("port", "must be between 1 and 65535, got %d", cfg.Port)
+	}
+	if strings.TrimSpace(cfg.FilesDir) == "" {
+		invalid("files_dir", "is required")
+	}
+	shared := filepath.Clean(cfg.SharedDir)
+	if strings.TrimSpace(cfg.SharedDir) == "" || filepath.IsAbs(shared) || shared == "." || strings.HasPrefix(shared, "..") {
+		invalid("shared_dir", "must be a directory name inside files_dir, got %q", cfg.SharedDir)
+	}
+	if cfg.MaxUploadSizeMB < 1 {
+		invalid("max_upload_size_mb", "must be at least 1, got %d", cfg.MaxUploadSizeMB)
+	}
+	for _, entry := range cfg.BlockedMIMETypes {
+		if kind, sub, ok := strings.Cut(strings.TrimSpace(entry), "/"); !ok || kind == "" || sub == "" {
+			invalid("blocked_mime_types", "%q is not a content type such as application/x-executable or video/*", entry)
+		}
+	}
+	if cfg.JWTSecret == "" && cfg.JWTSecretFile == "" {
+		invalid("jwt_secret_file", "is required when jwt_secret is not set")
+	}
+	for _, limit := range []struct {
+		name string
+		RateLimitConfig
+	}{{"rate_limit", cfg.RateLimit}, {"login_rate_limit", cfg.LoginRateLimit}, {"user_rate_limit", cfg.UserRateLimit}} {
+		if limit.Enabled {
+			if limit.Max < 1 {
+				invalid(limit.name+".max", "must be at least 1, got %d", limit.Max)
+			}
+			if limit.ExpirationSeconds < 1 {
+				invalid(limit.name+".expiration_seconds", "must be at least 1, got %d", limit.ExpirationSeconds)
+			}
+		}
+	}
+	if err := cfg.Lockout.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.PasswordPolicy.MinLength < 1 || cfg.PasswordPolicy.MinLength > maxPasswordBytes {
+		invalid("password_policy.min_length", "must be between 1 and %d, got %d", maxPasswordBytes, cfg.PasswordPolicy.MinLength)
+	}
+	if file := cfg.PasswordPolicy.BreachedListFile; file != "" {
+		if _, err := os.Stat(file); err != nil {
+			invalid("password_policy.breached_list_file", "%v", err)
+		}
+	}
+	if cfg.Bandwidth.DownloadKBps < 0 {
+		invalid("bandwidth.download_kbps", "must not be negative, got %d", cfg.Bandwidth.DownloadKBps)
+	}
+	if cfg.Bandwidth.UploadKBps < 0 {
+		invalid("bandwidth.upload_kbps", "must not be negative, got %d", cfg.Bandwidth.UploadKBps)
+	}
+	switch cfg.TLS.Mode {
+	case TLSOff:
+	case TLSFiles, TLSSelfSigned:
+		if cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "" {
+			invalid("tls", "cert_file and key_file are required in %s mode", cfg.TLS.Mode)
+		}
+	case TLSACME:
+		if len(cfg.TLS.ACME.Domains) == 0 {
+			invalid("tls.acme.domains", "at least one domain is required in acme mode")
+		}
+		if cfg.TLS.ACME.DirectoryURL == "" {
+			invalid("tls.acme.directory_url", "is required in acme mode")
+		}
+		if cfg.TLS.ACME.CacheDir == "" {
+			invalid("tls.acme.cache_dir", "is required in acme mode, certificates would be requested on every start")
+		}
+	default:
+		invalid("tls.mode", "must be %q, %q, %q or %q, got %q", TLSOff, TLSFiles, TLSSelfSigned, TLSACME, cfg.TLS.Mode)
+	}
+	if cfg.TLS.Mode != TLSOff {
+		if cfg.TLS.RedirectPort < 0 || cfg.TLS.RedirectPort > 65535 {
+			invalid("tls.redirect_port", "must be between 0 and 65535, got %d", cfg.TLS.RedirectPort)
+		} else if cfg.TLS.RedirectPort == cfg.Port {
+			invalid("tls.redirect_port", "must differ from port %d", cfg.Port)
+		}
+	}
+	for _, policy := range []struct {
+		name string
+		CORSPolicy
+	}{{"cors", cfg.CORS}, {"public_cors", cfg.PublicCORS.CORSPolicy}} {
+		matcher, err := NewOriginMatcher(policy.AllowedOrigins)
+		if err != nil {
+			invalid(policy.name+".allowed_origins", "%v", err)
+		} else if matcher.AllowsAny() && policy.AllowCredentials {
+			invalid(policy.name+".allow_credentials", "cannot be combined with the origin *, list the origins instead")
+		}
+		if len(policy.AllowedMethods) == 0 {
+			invalid(policy.name+".allowed_methods", "must not be empty")
+		}
+		for _, method := range policy.AllowedMethods {
+			if method == "" || strings.ToUpper(method) != method || strings.ContainsAny(method, ", ") {
+				invalid(policy.name+".allowed_methods", "%q is not an upper case method such as GET", method)
+			}
+		}
+		if policy.MaxAgeSeconds < 0 {
+			invalid(policy.name+".max_age_seconds", "must not be negative, got %d", policy.MaxAgeSeconds)
+		}
+	}
+	for _, path := range cfg.PublicCORS.Paths {
+		if !strings.HasPrefix(path, "/") {
+			invalid("public_cors.paths", "%q must start with /", path)
+		}
+	}
+	if cfg.FsckIntervalHours < 0 {
+		invalid("fsck_interval_hours", "must not be negative, got %d", cfg.FsckIntervalHours)
+	}
+	if cfg.ThumbnailWorkers < 1 {
+		invalid("thumbnail_workers", "must be at least 1, got %d", cfg.ThumbnailWorkers)
+	}
+	if cfg.WebhookMaxAttempts < 1 || cfg.WebhookMaxAttempts > maxWebhookAttempts {
+		invalid("webhook_max_attempts", "must be between 1 and %d, got %d", maxWebhookAttempts, cfg.WebhookMaxAttempts)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(value string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// regexOriginPrefix marks an allowed origin that is a regular expression rather than a pattern.
+const regexOriginPrefix = "regex:"
+
+// OriginMatcher decides whether a browser origin may call the API.
+type OriginMatcher struct {
+	any      bool
+	patterns []*regexp.Regexp
+}
+
+// NewOriginMatcher compiles a list of allowed origins. An entry is either
+//   - "*" for any origin,
+//   - an origin such as "https://dash.example.com", where * stands for one DNS label
+//     or a port, e.g. "https://*.example.com" or "http://localhost:*",
+//   - or "regex:" followed by a regular expression the whole origin must match.
+func NewOriginMatcher(origins []string) (*OriginMatcher, error) {
+	m := &OriginMatcher{}
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "*":
+			m.any = true
+
+		case strings.HasPrefix(origin, regexOriginPrefix):
+			expr := strings.TrimPrefix(origin, regexOriginPrefix)
+			re, err := regexp.Compile(`^(?:` + expr + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid regular expression: %w", expr, err)
+			}
+			m.patterns = append(m.patterns, re)
+
+		default:
+			scheme, host, ok := strings.Cut(strings.ToLower(origin), "://")
+			if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+				return nil, fmt.Errorf("%q is not an origin such as https://example.com", origin)
+			}
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(scheme+"://"+host), `\*`, `[a-z0-9-]+`)
+			m.patterns = append(m.patterns, regexp.MustCompile(`^`+pattern+`$`))
+		}
+	}
+	return m, nil
+}
+
+// AllowsAny reports whether every origin is allowed.
+func (m *OriginMatcher) AllowsAny() bool {
+	return m.any
+}
+
+// Match reports whether origin is allowed.
+func (m *OriginMatcher) Match(origin string) bool {
+	if m.any {
+		return true
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// newCORSHandler returns the CORS middleware for one policy. origins replaces the policy's
+// allowed origins when those are empty.
+func newCORSHandler(policy CORSPolicy, origins []string) (fiber.Handler, error) {
+	if len(policy.AllowedOrigins) > 0 {
+		origins = policy.AllowedOrigins
+	}
+	matcher, err := NewOriginMatcher(origins)
+	if err != nil {
+		return nil, err
+	}
+
+	config := cors.Config{
+		AllowMethods:     strings.Join(policy.AllowedMethods, ","),
+		AllowHeaders:     strings.Join(policy.AllowedHeaders, ","),
+		ExposeHeaders:    strings.Join(policy.ExposedHeaders, ","),
+		AllowCredentials: policy.AllowCredentials,
+		MaxAge:           policy.MaxAgeSeconds,
+	}
+	if matcher.AllowsAny() {
+		config.AllowOrigins = "*"
+	} else {
+		config.AllowOriginsFunc = matcher.Match
+	}
+	return cors.New(config), nil
+}
+
+// isPublicPath reports whether path is one of paths or below one of them.
+func isPublicPath(path string, paths []string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
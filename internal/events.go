@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus.
+const (
+	EventFileCreated  = "file.created"
+	EventFileDeleted  = "file.deleted"
+	EventFileRenamed  = "file.renamed"
+	EventFileShared   = "file.shared"
+	EventFileUnshared = "file.unshared"
+	EventFileUpdated  = "file.updated"
+	EventUserCreated  = "user.created"
+	EventUserUpdated  = "user.updated"
+	EventUserDeleted  = "user.deleted"
+)
+
+// Event is a change notification. OwnerID, WasShared and UserID are only used to decide
+// who may receive the event and are not sent to subscribers.
+type Event struct {
+	Type     string    `json:"type"`
+	FileID   int64     `json:"file_id,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+	OldName  string    `json:"old_name,omitempty"`
+	IsShared bool      `json:"is_shared,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Time     time.Time `json:"time"`
+
+	OwnerID   string `json:"-"`
+	WasShared bool   `json:"-"`
+	UserID    string `json:"-"`
+}
+
+// VisibleTo reports whether a subscriber may receive the event.
+// File events reach the owner and, while the file is or was shared, everyone.
+// User events reach admins and the user concerned.
+func (e Event) VisibleTo(userID string, isAdmin bool) bool {
+	if e.FileID != 0 {
+		return e.IsShared || e.WasShared || e.OwnerID == userID
+	}
+	return isAdmin || e.UserID == userID
+}
+
+// eventSubscriberBuffer is how many events a slow subscriber may fall behind before events are dropped.
+const eventSubscriberBuffer = 64
+
+var (
+	eventsMu    sync.Mutex
+	subscribers = make(map[chan Event]struct{})
+)
+
+// PublishEvent records the webhook deliveries of an event and sends it to every subscriber
+// without blocking. Subscribers that are not keeping up miss the event rather than slowing
+// down the publisher.
+func PublishEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	recordWebhookDeliveries(e)
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// SubscribeEvents registers a subscriber. The returned function unsubscribes and closes the channel.
+func SubscribeEvents() (<-chan Event, func()) {
+	ch := make(chan Event, eventSubscriberBuffer)
+
+	eventsMu.Lock()
+	subscribers[ch] = struct{}{}
+	eventsMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			eventsMu.Lock()
+			if _, ok := subscribers[ch]; ok {
+				delete(subscribers, ch)
+				close(ch)
+			}
+			eventsMu.Unlock()
+		})
+	}
+}
+
+// CloseEvents closes every subscription so open streams end, used on shutdown.
+func CloseEvents() {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	for ch := range subscribers {
+		delete(subscribers, ch)
+		close(ch)
+	}
+}
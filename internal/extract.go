@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxExtractBytes caps how much of a file is read for text extraction.
+const maxExtractBytes = 32 << 20
+
+// maxIndexedText caps the amount of extracted text stored per file.
+const maxIndexedText = 1 << 20
+
+// textExtensions are indexed as plain text even when sniffing is inconclusive.
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".csv": true, ".tsv": true, ".log": true,
+	".json": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".xml": true,
+	".html": true, ".htm": true, ".css": true, ".js": true, ".ts": true, ".go": true,
+	".py": true, ".rb": true, ".java": true, ".c": true, ".h": true, ".cpp": true,
+	".hpp": true, ".rs": true, ".sh": true, ".sql": true, ".php": true, ".kt": true,
+	".swift": true, ".cs": true, ".tex": true, ".rst": true,
+}
+
+// ExtractText returns the searchable text of the file at path.
+// Unsupported formats return an empty string without an error.
+func ExtractText(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pdf":
+		return extractPDFText(path)
+	case ".docx":
+		return extractDOCXText(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxIndexedText))
+	if err != nil {
+		return "", err
+	}
+
+	if !isText(path, data) {
+		return "", nil
+	}
+
+	return strings.ToValidUTF8(string(data), " "), nil
+}
+
+// isText reports whether a file is text, judging by its extension or its first bytes.
+func isText(path string, head []byte) bool {
+	return textExtensions[strings.ToLower(filepath.Ext(path))] || strings.HasPrefix(http.DetectContentType(head), "text/")
+}
+
+// extractDOCXText reads the paragraphs of word/document.xml.
+func extractDOCXText(path string) (string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		var sb strings.Builder
+		dec := xml.NewDecoder(io.LimitReader(rc, maxExtractBytes))
+		inText := false
+
+		for sb.Len() < maxIndexedText {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return sb.String(), nil
+			}
+
+			switch t := tok.(type) {
+			case xml.StartElement:
+				inText = t.Name.Local == "t"
+				if t.Name.Local == "tab" {
+					sb.WriteByte(' ')
+				}
+			case xml.EndElement:
+				inText = false
+				if t.Name.Local == "p" {
+					sb.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					sb.Write(t)
+				}
+			}
+		}
+
+		return sb.String(), nil
+	}
+
+	return "", nil
+}
+
+var (
+	pdfStreamRe = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfTextRe   = regexp.MustCompile(`(?s)BT(.*?)ET`)
+)
+
+// extractPDFText is a best effort extractor for the text shown by PDF content streams.
+// It understands uncompressed and FlateDecode streams with literal or simple hex strings,
+// which covers most text based PDFs without pulling in a full PDF library.
+func extractPDFText(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxExtractBytes))
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+
+	for _, loc := range pdfStreamRe.FindAllSubmatchIndex(data, -1) {
+		if sb.Len() >= maxIndexedText {
+			break
+		}
+
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := data[start : start+end]
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			zr, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			stream, _ = io.ReadAll(io.LimitReader(zr, maxExtractBytes))
+			zr.Close()
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			// Images and other encodings carry no text we can read
+			continue
+		}
+
+		for _, block := range pdfTextRe.FindAllSubmatch(stream, -1) {
+			writePDFStrings(&sb, block[1])
+			sb.WriteByte('\n')
+		}
+	}
+
+	return strings.ToValidUTF8(sb.String(), " "), nil
+}
+
+// writePDFStrings appends the string operands of a BT/ET block.
+func writePDFStrings(sb *strings.Builder, block []byte) {
+	for i := 0; i < len(block); i++ {
+		switch block[i] {
+		case '(':
+			// Literal string with nesting and escapes
+			depth := 1
+			for i++; i < len(block) && depth > 0; i++ {
+				c := block[i]
+				switch {
+				case c == '\\' && i+1 < len(block):
+					i++
+					switch block[i] {
+					case 'n', 'r':
+						sb.WriteByte(' ')
+					case 't':
+						sb.WriteByte(' ')
+					case '(', ')', '\\':
+						sb.WriteByte(block[i])
+					}
+				case c == '(':
+					depth++
+					sb.WriteByte(c)
+				case c == ')':
+					depth--
+					if depth > 0 {
+						sb.WriteByte(c)
+					}
+				default:
+					sb.WriteByte(c)
+				}
+			}
+			i--
+		case '<':
+			end := bytes.IndexByte(block[i:], '>')
+			if end < 0 {
+				return
+			}
+			writePDFHex(sb, block[i+1:i+end])
+			i += end
+		case 'T':
+			// Text positioning operators start a new word
+			if i+1 < len(block) && (block[i+1] == 'd' || block[i+1] == 'D' || block[i+1] == '*') {
+				sb.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// writePDFHex decodes a hex string when it holds printable single byte text.
+func writePDFHex(sb *strings.Builder, hex []byte) {
+	var decoded []byte
+	var hi byte
+	half := false
+
+	for _, c := range hex {
+		var v byte
+		switch {
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		if half {
+			decoded = append(decoded, hi<<4|v)
+		} else {
+			hi = v
+		}
+		half = !half
+	}
+
+	for _, b := range decoded {
+		if b < 0x20 || b >= utf8.RuneSelf {
+			return
+		}
+	}
+	sb.Write(decoded)
+}
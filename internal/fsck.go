@@ -0,0 +1,312 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kinds of inconsistencies reported by RunFsck.
+const (
+	FsckOrphanFile   = "orphan_file"
+	FsckMissingBlob  = "missing_blob"
+	FsckSizeMismatch = "size_mismatch"
+)
+
+// QuarantineDir is the directory under FILES_DIR that receives quarantined files.
+const QuarantineDir = ".quarantine"
+
+// FsckOptions selects which repairs RunFsck performs. Empty fields only report.
+type FsckOptions struct {
+	// Orphans is "import" to register orphan files or "quarantine" to move them aside.
+	Orphans string `json:"orphans"`
+	// Dangling is "remove" to delete rows whose file is missing.
+	Dangling string `json:"dangling"`
+	// Mismatch is "update" to trust the file on disk or "quarantine" to move it aside and drop the row.
+	Mismatch string `json:"mismatch"`
+}
+
+// FsckIssue is a single inconsistency between the metadata table and the storage directory.
+type FsckIssue struct {
+	Kind         string `json:"kind"`
+	Path         string `json:"path"`
+	FileID       int64  `json:"file_id,omitempty"`
+	ExpectedSize int64  `json:"expected_size,omitempty"`
+	ActualSize   int64  `json:"actual_size,omitempty"`
+	Action       string `json:"action,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// FsckReport is the JSON report produced by every consistency check.
+type FsckReport struct {
+	StartedAt    time.Time   `json:"started_at"`
+	FinishedAt   time.Time   `json:"finished_at"`
+	RowsScanned  int         `json:"rows_scanned"`
+	FilesScanned int         `json:"files_scanned"`
+	Issues       []FsckIssue `json:"issues"`
+}
+
+// Validate rejects unknown repair modes.
+func (o FsckOptions) Validate() error {
+	if o.Orphans != "" && o.Orphans != "import" && o.Orphans != "quarantine" {
+		return fmt.Errorf("invalid orphans mode %q", o.Orphans)
+	}
+	if o.Dangling != "" && o.Dangling != "remove" {
+		return fmt.Errorf("invalid dangling mode %q", o.Dangling)
+	}
+	if o.Mismatch != "" && o.Mismatch != "update" && o.Mismatch != "quarantine" {
+		return fmt.Errorf("invalid mismatch mode %q", o.Mismatch)
+	}
+	return nil
+}
+
+type fsckRow struct {
+	id   int64
+	path string
+	size int64
+}
+
+// RunFsck compares the metadata table with the files under filesDir and applies the requested repairs.
+func RunFsck(db *sql.DB, filesDir, sharedDir string, opts FsckOptions) (*FsckReport, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	report := &FsckReport{StartedAt: time.Now().UTC(), Issues: make([]FsckIssue, 0)}
+
+	rows, err := db.Query(`SELECT id, path, size FROM metadata`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metadata: %w", err)
+	}
+
+	known := make(map[string]fsckRow)
+	for rows.Next() {
+		var r fsckRow
+		if err := rows.Scan(&r.id, &r.path, &r.size); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan metadata: %w", err)
+		}
+		known[filepath.Clean(r.path)] = r
+	}
+	rows.Close()
+	report.RowsScanned = len(known)
+
+	// Check that every row points to a blob of the recorded size
+	for p, r := range known {
+		info, err := os.Stat(p)
+		switch {
+		case os.IsNotExist(err):
+			report.Issues = append(report.Issues, FsckIssue{Kind: FsckMissingBlob, Path: r.path, FileID: r.id, ExpectedSize: r.size})
+		case err != nil:
+			report.Issues = append(report.Issues, FsckIssue{Kind: FsckMissingBlob, Path: r.path, FileID: r.id, ExpectedSize: r.size, Error: err.Error()})
+		case info.Size() != r.size:
+			report.Issues = append(report.Issues, FsckIssue{Kind: FsckSizeMismatch, Path: r.path, FileID: r.id, ExpectedSize: r.size, ActualSize: info.Size()})
+		}
+	}
+
+	// Look for files on disk that no row refers to
+	err = filepath.WalkDir(filesDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Skip internal directories such as the quarantine
+		if d.IsDir() && p != filesDir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		report.FilesScanned++
+		if _, ok := known[filepath.Clean(p)]; ok {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		report.Issues = append(report.Issues, FsckIssue{Kind: FsckOrphanFile, Path: p, ActualSize: info.Size()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", filesDir, err)
+	}
+
+	for i := range report.Issues {
+		repairIssue(db, filesDir, sharedDir, opts, &report.Issues[i])
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// repairIssue applies the repair selected in opts and records the outcome on the issue.
+func repairIssue(db *sql.DB, filesDir, sharedDir string, opts FsckOptions, issue *FsckIssue) {
+	var action string
+	var err error
+
+	switch issue.Kind {
+	case FsckOrphanFile:
+		switch opts.Orphans {
+		case "import":
+			action = "imported"
+			err = importOrphan(db, filesDir, sharedDir, issue)
+		case "quarantine":
+			action = "quarantined"
+			err = quarantineFile(filesDir, issue.Path)
+		}
+	case FsckMissingBlob:
+		if opts.Dangling == "remove" {
+			action = "row_removed"
+			_, err = db.Exec(`DELETE FROM metadata WHERE id = ?`, issue.FileID)
+		}
+	case FsckSizeMismatch:
+		switch opts.Mismatch {
+		case "update":
+			action = "size_updated"
+			var mimeType, sum string
+			if mimeType, err = DetectMIMETypeFile(issue.Path); err != nil {
+				break
+			}
+			if sum, err = HashFile(issue.Path); err != nil {
+				break
+			}
+			if _, err = db.Exec(`UPDATE metadata SET size = ?, mime_type = ?, sha256 = ?, modified_at = ? WHERE id = ?`,
+				issue.ActualSize, mimeType, sum, time.Now().UTC().Format("2006-01-02 15:04:05"), issue.FileID); err == nil {
+				// The contents changed on disk, refresh the search index
+				QueueIndex(issue.FileID)
+			}
+		case "quarantine":
+			action = "quarantined"
+			if err = quarantineFile(filesDir, issue.Path); err == nil {
+				_, err = db.Exec(`DELETE FROM metadata WHERE id = ?`, issue.FileID)
+			}
+		}
+	}
+
+	if action == "" {
+		return
+	}
+	if err != nil {
+		issue.Error = err.Error()
+		return
+	}
+	issue.Action = action
+}
+
+// importOrphan registers an orphan file under the owner implied by its location.
+func importOrphan(db *sql.DB, filesDir, sharedDir string, issue *FsckIssue) error {
+	rel, err := filepath.Rel(filesDir, issue.Path)
+	if err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("file is not inside a user or shared directory")
+	}
+
+	owner := parts[0]
+	isShared := owner == filepath.Clean(sharedDir)
+
+	if isShared {
+		// Shared files keep an uploader, attribute orphans to an admin
+		if err := db.QueryRow(`SELECT id FROM users WHERE is_admin = 1 LIMIT 1`).Scan(&owner); err != nil {
+			return fmt.Errorf("no admin to own shared file: %w", err)
+		}
+	} else if _, err := GetUsernameByID(owner, db); err != nil {
+		return fmt.Errorf("unknown owner %s: %w", owner, err)
+	}
+
+	// Name the file like an upload would, so it cannot collide with an existing file
+	filename, err := ResolveFileNameConflict(owner, filepath.Base(issue.Path), isShared, db)
+	if err != nil {
+		return fmt.Errorf("could not resolve filename: %w", err)
+	}
+
+	mimeType, err := DetectMIMETypeFile(issue.Path)
+	if err != nil {
+		return err
+	}
+
+	sum, err := HashFile(issue.Path)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec(`INSERT INTO metadata (user_id, filename, size, path, is_shared, mime_type, sha256) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		owner, filename, issue.ActualSize, issue.Path, isShared, mimeType, sum)
+	if err != nil {
+		return err
+	}
+
+	if fileID, err := res.LastInsertId(); err == nil {
+		QueueIndex(fileID)
+		PublishEvent(Event{Type: EventFileCreated, FileID: fileID, Filename: filename, IsShared: isShared, OwnerID: owner})
+	}
+	return nil
+}
+
+// quarantineFile moves p into the quarantine directory, keeping its relative location.
+func quarantineFile(filesDir, p string) error {
+	rel, err := filepath.Rel(filesDir, p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(p)
+	}
+
+	dest := filepath.Join(filesDir, QuarantineDir, time.Now().UTC().Format("20060102-150405"), rel)
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(p, dest)
+}
+
+// StartFsckScheduler runs a report-only consistency check every interval and writes the
+// latest report to reportPath. It returns a function that stops the scheduler.
+func StartFsckScheduler(db *sql.DB, filesDir, sharedDir, reportPath string, interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report, err := RunFsck(db, filesDir, sharedDir, FsckOptions{})
+				if err != nil {
+					Error.Println("Scheduled fsck failed:", err)
+					continue
+				}
+
+				if len(report.Issues) > 0 {
+					Error.Printf("Scheduled fsck found %d issue(s), see %s", len(report.Issues), reportPath)
+				}
+
+				data, err := json.MarshalIndent(report, "", "  ")
+				if err == nil {
+					err = os.WriteFile(reportPath, data, 0644)
+				}
+				if err != nil {
+					Error.Println("Failed to write fsck report:", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
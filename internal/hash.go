@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// HashFile returns the hex SHA-256 digest of the file at path.
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// BackfillContentHashes hashes files stored before content hashes were recorded.
+// Each stored hash shows up as an update in the change journal.
+func BackfillContentHashes(db *sql.DB) {
+	rows, err := db.Query(`SELECT id, path FROM metadata WHERE sha256 = ''`)
+	if err != nil {
+		Error.Println("Failed to find files without a content hash:", err)
+		return
+	}
+
+	type pending struct {
+		id   int64
+		path string
+	}
+	var files []pending
+	for rows.Next() {
+		var f pending
+		if err := rows.Scan(&f.id, &f.path); err == nil {
+			files = append(files, f)
+		}
+	}
+	rows.Close()
+
+	for _, f := range files {
+		sum, err := HashFile(f.path)
+		if err != nil {
+			// Missing files are reported by fsck
+			continue
+		}
+		if _, err := db.Exec(`UPDATE metadata SET sha256 = ? WHERE id = ?`, sum, f.id); err != nil {
+			Error.Printf("Failed to store content hash of file %d: %v", f.id, err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImportOptions describes a directory tree to register into CloudBoxIO.
+type ImportOptions struct {
+	Source   string `json:"source"`
+	Username string `json:"username"`
+	Shared   bool   `json:"shared"`
+	Move     bool   `json:"move"`
+}
+
+// ImportError records a file that could not be imported.
+type ImportError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ImportResult summarizes an import run.
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// ImportTree walks opts.Source and registers every regular file into the user's space or the shared space.
+// Folder structure is kept in the stored filename and modification times are preserved.
+// Files recorded in import_log by an earlier run are skipped so an interrupted import can be resumed.
+func ImportTree(db *sql.DB, filesDir, sharedDir string, opts ImportOptions) (*ImportResult, error) {
+	if opts.Source == "" || opts.Username == "" {
+		return nil, fmt.Errorf("source and username are required")
+	}
+
+	source, err := filepath.Abs(opts.Source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %w", err)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("source %s is not a directory", source)
+	}
+
+	var userID string
+	if err := db.QueryRow(`SELECT id FROM users WHERE username = ?`, opts.Username).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user %q not found", opts.Username)
+		}
+		return nil, err
+	}
+
+	dirPath := filepath.Join(filesDir, userID)
+	if opts.Shared {
+		dirPath = filepath.Join(filesDir, sharedDir)
+	}
+
+	// Never import the storage directory into itself, nor files it already holds:
+	// moving them would break the rows that still point at them
+	if absFiles, err := filepath.Abs(filesDir); err == nil {
+		if isWithin(source, absFiles) {
+			return nil, fmt.Errorf("source must not contain the storage directory")
+		}
+		if isWithin(absFiles, source) {
+			return nil, fmt.Errorf("source must not be inside the storage directory")
+		}
+	}
+
+	result := &ImportResult{Errors: make([]ImportError, 0)}
+
+	err = filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, ImportError{Path: p, Error: err.Error()})
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		imported, err := importFile(db, source, p, rel, userID, dirPath, opts)
+		switch {
+		case err != nil:
+			result.Failed++
+			result.Errors = append(result.Errors, ImportError{Path: rel, Error: err.Error()})
+		case imported:
+			result.Imported++
+		default:
+			result.Skipped++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	FileOps.Printf("User [%s] imported %d file(s) from %s", userID, result.Imported, source)
+
+	return result, nil
+}
+
+// importFile registers a single file. It returns false when the file was already imported.
+func importFile(db *sql.DB, source, src, rel, userID, dirPath string, opts ImportOptions) (bool, error) {
+	var done bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM import_log WHERE source = ? AND rel_path = ? AND user_id = ? AND is_shared = ?)`,
+		source, rel, userID, opts.Shared).Scan(&done)
+	if err != nil {
+		return false, err
+	}
+
+	if done {
+		// A move interrupted after the commit still has its source to clean up
+		if opts.Move {
+			if err := os.Remove(src); err != nil && !os.IsNotExist(err) {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+
+	filename, err := ResolveFileNameConflict(userID, rel, opts.Shared, db)
+	if err != nil {
+		return false, fmt.Errorf("could not resolve filename: %w", err)
+	}
+
+	savePath := filepath.Join(dirPath, filepath.FromSlash(filename))
+
+	// Copy first, even when moving, so the source survives until the metadata is committed
+	place := copyFile
+	if opts.Move {
+		place = linkOrCopy
+	}
+	if err := place(src, savePath); err != nil {
+		return false, err
+	}
+
+	if err := os.Chtimes(savePath, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(savePath)
+		return false, err
+	}
+
+	if err := recordImport(db, source, rel, userID, filename, savePath, info, opts.Shared); err != nil {
+		os.Remove(savePath)
+		return false, err
+	}
+
+	if opts.Move {
+		if err := os.Remove(src); err != nil {
+			return true, fmt.Errorf("imported but failed to remove source: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// recordImport inserts the metadata row and the import_log entry in one transaction.
+func recordImport(db *sql.DB, source, rel, userID, filename, savePath string, info os.FileInfo, isShared bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	mimeType, err := DetectMIMETypeFile(savePath)
+	if err != nil {
+		return err
+	}
+
+	sum, err := HashFile(savePath)
+	if err != nil {
+		return err
+	}
+
+	uploadedAt := info.ModTime().UTC().Format("2006-01-02 15:04:05")
+	res, err := tx.Exec(`INSERT INTO metadata (user_id, filename, size, path, is_shared, uploaded_at, mime_type, sha256) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, filename, info.Size(), savePath, isShared, uploadedAt, mimeType, sum)
+	if err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	fileID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO import_log (source, rel_path, user_id, is_shared, file_id) VALUES (?, ?, ?, ?, ?)`,
+		source, rel, userID, isShared, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to record import: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	QueueIndex(fileID)
+	PublishEvent(Event{Type: EventFileCreated, FileID: fileID, Filename: filename, IsShared: isShared, OwnerID: userID})
+	return nil
+}
+
+// linkOrCopy hard links src to dst when both are on the same filesystem and copies otherwise.
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	return copyFile(src, dst)
+}
+
+// isWithin reports whether target is parent itself or lies below it.
+func isWithin(parent, target string) bool {
+	rel, err := filepath.Rel(parent, target)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
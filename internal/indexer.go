@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// indexQueue feeds file IDs to the background indexer. It is nil until StartIndexer runs.
+var (
+	indexQueue   chan int64
+	indexQueueMu sync.RWMutex
+)
+
+// StartIndexer starts the background content indexer and queues every file missing from the index.
+// It returns a function that stops the indexer after the queue drains.
+func StartIndexer(db *sql.DB) func() {
+	queue := make(chan int64, 1024)
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	var catchUp sync.WaitGroup
+
+	indexQueueMu.Lock()
+	indexQueue = queue
+	indexQueueMu.Unlock()
+
+	go func() {
+		defer close(done)
+		for fileID := range queue {
+			if err := IndexFile(db, fileID); err != nil {
+				Error.Printf("Failed to index file %d: %v", fileID, err)
+			}
+		}
+	}()
+
+	// Catch up on files uploaded before indexing existed or dropped from a full queue.
+	// Unlike QueueIndex this waits for room in the queue, so large stores are indexed in one go.
+	catchUp.Add(1)
+	go func() {
+		defer catchUp.Done()
+
+		rows, err := db.Query(`SELECT id FROM metadata WHERE id NOT IN (SELECT rowid FROM file_index)`)
+		if err != nil {
+			Error.Println("Failed to find unindexed files:", err)
+			return
+		}
+
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+
+		for _, id := range ids {
+			select {
+			case queue <- id:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		indexQueueMu.Lock()
+		indexQueue = nil
+		indexQueueMu.Unlock()
+
+		close(stop)
+		catchUp.Wait()
+		close(queue)
+		<-done
+	}
+}
+
+// QueueIndex schedules a file for (re)indexing. It never blocks uploads:
+// when the indexer is not running or the queue is full the file is picked up on the next start.
+func QueueIndex(fileID int64) {
+	indexQueueMu.RLock()
+	defer indexQueueMu.RUnlock()
+
+	if indexQueue == nil {
+		return
+	}
+
+	select {
+	case indexQueue <- fileID:
+	default:
+		Error.Printf("Index queue full, file %d will be indexed on next start", fileID)
+	}
+}
+
+// IndexFile extracts the text of a file and replaces its entry in the search index.
+func IndexFile(db *sql.DB, fileID int64) error {
+	var filename, path string
+	err := db.QueryRow(`SELECT filename, path FROM metadata WHERE id = ?`, fileID).Scan(&filename, &path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Deleted before the indexer got to it
+			return nil
+		}
+		return err
+	}
+
+	content, err := ExtractText(path)
+	if err != nil {
+		return fmt.Errorf("failed to extract text: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM file_index WHERE rowid = ?`, fileID); err != nil {
+		return err
+	}
+
+	// Files without extractable text are still indexed by name so they are not retried on every start
+	if _, err := tx.Exec(`INSERT INTO file_index (rowid, filename, content) VALUES (?, ?, ?)`, fileID, filename, content); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// FTSQuery turns free text into an FTS5 query matching every word as a prefix.
+func FTSQuery(input string) string {
+	var terms []string
+	for _, word := range strings.Fields(input) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
@@ -3,8 +3,10 @@ package internal
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,8 +14,13 @@ import (
 
 var SecretKey []byte
 
-func InitJWT() {
-	SecretKey = []byte(ensureJWTSecret())
+func InitJWT(cfg *Config) error {
+	secret, err := ensureJWTSecret(cfg)
+	if err != nil {
+		return err
+	}
+	SecretKey = []byte(secret)
+	return nil
 }
 
 func GenerateToken(userID string, isAdmin bool, expTime int) (string, error) {
@@ -29,26 +36,45 @@ func GenerateToken(userID string, isAdmin bool, expTime int) (string, error) {
 	return token.SignedString(SecretKey)
 }
 
-func ensureJWTSecret() string {
-	// Try to get secret from .env
-	secret := os.Getenv("JWT_SECRET")
-	if secret != "" {
-		return secret
+// GeneratePasswordChangeToken returns a token that only lets the user change the password,
+// for users an admin told to choose a new one.
+func GeneratePasswordChangeToken(userID string, isAdmin bool, expTime int) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":         userID,
+		"is_admin":        isAdmin,
+		"password_change": true,
+		"exp":             time.Now().Add(time.Hour * time.Duration(expTime)).Unix(),
 	}
 
-	// Generate 32 random bytes
-	randomBytes := make([]byte, 32)
-	_, err := rand.Read(randomBytes)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(SecretKey)
+}
 
-	if err != nil {
-		Error.Printf("failed to generate JWT secret: %v", err)
+// ensureJWTSecret returns the configured secret, or the one kept in cfg.JWTSecretFile,
+// creating that file with a random secret on first start.
+func ensureJWTSecret(cfg *Config) (string, error) {
+	if cfg.JWTSecret != "" {
+		return cfg.JWTSecret, nil
+	}
+
+	data, err := os.ReadFile(cfg.JWTSecretFile)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read JWT secret: %w", err)
 	}
 
-	secret = hex.EncodeToString(randomBytes)
+	// Generate 32 random bytes
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate JWT secret: %w", err)
+	}
+	secret := hex.EncodeToString(randomBytes)
 
-	// Write jwt secret to .env
-	jwtSecret := fmt.Sprintf("JWT_SECRET=%s\n", secret)
-	AddToENV(jwtSecret)
+	if err := os.WriteFile(cfg.JWTSecretFile, []byte(secret+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to store JWT secret: %w", err)
+	}
 
-	return secret
+	return secret, nil
 }
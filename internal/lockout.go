@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Results of a login attempt as kept in the login history.
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "failure"
+	// LoginLocked is an attempt refused without checking the password
+	LoginLocked = "locked"
+)
+
+// loginHistoryRetention is how long login attempts are kept.
+const loginHistoryRetention = 90 * 24 * time.Hour
+
+// loginSweepInterval is the least time between two deletions of expired login records.
+const loginSweepInterval = time.Hour
+
+// LoginGuard slows down password guessing. Failed logins are counted both per account and
+// per client IP, so neither a distributed attack on one account nor one client trying many
+// accounts goes unnoticed. After the free attempts each failure blocks further logins for a
+// delay that doubles every time, until the lockout is reached.
+//
+// Accounts are counted by user ID so renaming a user keeps its lockout. Usernames without an
+// account are counted by name, the answers must not tell them apart.
+type LoginGuard struct {
+	db       *sql.DB
+	settings *SettingsStore
+
+	lastSweep atomic.Int64
+}
+
+// NewLoginGuard returns a guard keeping its counters and the login history in db.
+func NewLoginGuard(db *sql.DB, settings *SettingsStore) *LoginGuard {
+	return &LoginGuard{db: db, settings: settings}
+}
+
+// Blocked returns how long logins to username from ip are refused, 0 when they are not.
+func (g *LoginGuard) Blocked(username, ip string) (time.Duration, error) {
+	if !g.settings.Get().Lockout.Enabled {
+		return 0, nil
+	}
+
+	account, err := g.accountKey(username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check lockout: %w", err)
+	}
+
+	now := time.Now().Unix()
+	var until sql.NullInt64
+	err = g.db.QueryRow(`SELECT MAX(blocked_until) FROM login_failures WHERE key IN (?, ?)`,
+		account, ipKey(ip)).Scan(&until)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check lockout: %w", err)
+	}
+	if !until.Valid || until.Int64 <= now {
+		return 0, nil
+	}
+	return time.Duration(until.Int64-now) * time.Second, nil
+}
+
+// Fail counts a failed login to username from ip.
+func (g *LoginGuard) Fail(username, ip string) error {
+	cfg := g.settings.Get().Lockout
+	if !cfg.Enabled {
+		return nil
+	}
+
+	account, err := g.accountKey(username)
+	if err != nil {
+		return fmt.Errorf("failed to count login failure: %w", err)
+	}
+
+	now := time.Now().Unix()
+	for _, key := range []string{account, ipKey(ip)} {
+		// Failures are forgotten once a lockout would have ended since the last one
+		var failures int
+		err := g.db.QueryRow(`INSERT INTO login_failures (key, failures, last_failure, blocked_until) VALUES (?, 1, ?, 0)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN last_failure <= ? THEN 1 ELSE failures + 1 END,
+				last_failure = excluded.last_failure
+			RETURNING failures`,
+			key, now, now-int64(cfg.LockoutSeconds)).Scan(&failures)
+		if err != nil {
+			return fmt.Errorf("failed to count login failure: %w", err)
+		}
+
+		if delay := cfg.Delay(failures); delay > 0 {
+			if _, err := g.db.Exec(`UPDATE login_failures SET blocked_until = ? WHERE key = ?`, now+int64(delay.Seconds()), key); err != nil {
+				return fmt.Errorf("failed to block logins: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Succeed forgets the failures of the user. Those of the client IP are kept, a valid
+// account must not let a client keep guessing the passwords of others.
+func (g *LoginGuard) Succeed(userID string) error {
+	return g.Unlock(userID)
+}
+
+// Unlock forgets the failures of the user and ends its lockout.
+func (g *LoginGuard) Unlock(userID string) error {
+	if _, err := g.db.Exec(`DELETE FROM login_failures WHERE key = ?`, AccountFailureKey(userID)); err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+	return nil
+}
+
+// UnlockIP forgets the failures of ip and ends its lockout.
+func (g *LoginGuard) UnlockIP(ip string) error {
+	if _, err := g.db.Exec(`DELETE FROM login_failures WHERE key = ?`, ipKey(ip)); err != nil {
+		return fmt.Errorf("failed to unlock IP: %w", err)
+	}
+	return nil
+}
+
+// Record adds a login attempt to the history of username, if such a user exists.
+func (g *LoginGuard) Record(username, ip, result string) error {
+	g.sweep(time.Now())
+
+	_, err := g.db.Exec(`INSERT INTO login_history (user_id, ip, result)
+		SELECT id, ?, ? FROM users WHERE username = ?`, ip, result, username)
+	if err != nil {
+		return fmt.Errorf("failed to record login: %w", err)
+	}
+	return nil
+}
+
+// sweep deletes old login history and forgotten failures, at most once per loginSweepInterval.
+func (g *LoginGuard) sweep(now time.Time) {
+	last := g.lastSweep.Load()
+	if now.Unix()-last < int64(loginSweepInterval.Seconds()) || !g.lastSweep.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+
+	if _, err := g.db.Exec(`DELETE FROM login_history WHERE created_at < ?`,
+		now.Add(-loginHistoryRetention).UTC().Format(time.DateTime)); err != nil && Error != nil {
+		Error.Println("Failed to delete old login history:", err)
+	}
+	forgotten := now.Unix() - int64(g.settings.Get().Lockout.LockoutSeconds)
+	if _, err := g.db.Exec(`DELETE FROM login_failures WHERE last_failure <= ? AND blocked_until <= ?`, forgotten, now.Unix()); err != nil && Error != nil {
+		Error.Println("Failed to delete expired login failures:", err)
+	}
+}
+
+// Validate reports every invalid lockout setting at once. Disabled settings are not checked.
+func (l LockoutConfig) Validate() error {
+	if !l.Enabled {
+		return nil
+	}
+
+	var errs []error
+	invalid := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("lockout.%s: "+format, append([]any{name}, args...)...))
+	}
+	if l.FreeAttempts < 0 {
+		invalid("free_attempts", "must not be negative, got %d", l.FreeAttempts)
+	}
+	if l.MaxFailures <= l.FreeAttempts {
+		invalid("max_failures", "must be more than the %d free attempts, got %d", l.FreeAttempts, l.MaxFailures)
+	}
+	if l.DelaySeconds < 1 {
+		invalid("delay_seconds", "must be at least 1, got %d", l.DelaySeconds)
+	}
+	if l.LockoutSeconds < l.DelaySeconds {
+		invalid("lockout_seconds", "must be at least delay_seconds, got %d", l.LockoutSeconds)
+	}
+	return errors.Join(errs...)
+}
+
+// Delay returns how long logins are blocked after the given number of failures in a row.
+func (l LockoutConfig) Delay(failures int) time.Duration {
+	lockout := time.Duration(l.LockoutSeconds) * time.Second
+	switch {
+	case failures >= l.MaxFailures:
+		return lockout
+	case failures <= l.FreeAttempts:
+		return 0
+	}
+	// Any delay reaches the lockout long before 2^20 doublings, stop there so it cannot overflow
+	delay := int64(l.DelaySeconds) << min(failures-l.FreeAttempts-1, 20)
+	return min(time.Duration(delay)*time.Second, lockout)
+}
+
+// accountKey returns the login_failures key counting the failed logins to username.
+func (g *LoginGuard) accountKey(username string) (string, error) {
+	var userID string
+	err := g.db.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "unknown:" + username, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return AccountFailureKey(userID), nil
+}
+
+// AccountFailureKey is the login_failures key counting the failed logins of a user.
+func AccountFailureKey(userID string) string {
+	return "account:" + userID
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -12,9 +12,9 @@ var (
 	FileOps *log.Logger
 )
 
-func InitLogger() {
-	logFileOps := os.Getenv("LOG_FILE_OPS") == "true"
-	logToConsole := os.Getenv("LOG_TO_CONSOLE") == "true"
+func InitLogger(cfg LogConfig) {
+	logFileOps := cfg.FileOps
+	logToConsole := cfg.ToConsole
 
 	_ = os.MkdirAll("logs", os.ModePerm)
 	logfile, err := os.OpenFile("logs/server.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
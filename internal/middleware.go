@@ -1,30 +1,34 @@
 package internal
 
 import (
-	"os"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func JWTProtected() fiber.Handler {
+// JWTProtected accepts requests with a valid token. The admin role, the disabled state and
+// whether the password must be changed are read from db rather than the token, so demoting,
+// disabling or forcing a password change takes effect at once.
+func JWTProtected(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get JWT
 		auth := c.Get("Authorization")
 
 		if auth == "" {
-			return c.Status(498).JSON(fiber.Map{"error": "Missing token"})
+			return fiber.NewError(fiber.StatusUnauthorized, "Missing token")
 		}
 
 		// Split and get the token
 		parts := strings.Split(auth, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			return c.Status(498).JSON(fiber.Map{"error": "Invalid token format"})
+			return fiber.NewError(fiber.StatusUnauthorized, "Invalid token format")
 		}
 		tokenString := parts[1]
 
@@ -34,48 +38,106 @@ func JWTProtected() fiber.Handler {
 		})
 
 		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+			return fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
 		}
 
 		// Extract payload from the token
 		claims := token.Claims.(jwt.MapClaims)
 
+		var isAdmin, disabled, mustChange bool
+		err = db.QueryRow(`SELECT is_admin, disabled, must_change_password FROM users WHERE id = ?`, claims["user_id"]).Scan(&isAdmin, &disabled, &mustChange)
+		if err != nil {
+			// Tokens of deleted users stop working with the account
+			if errors.Is(err, sql.ErrNoRows) {
+				return fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, "Could not verify user")
+		}
+		if disabled {
+			return fiber.NewError(fiber.StatusForbidden, "Account is disabled")
+		}
+
 		// Storeing data in request context
 		c.Locals("user_id", claims["user_id"])
-		c.Locals("is_admin", claims["is_admin"])
+		c.Locals("is_admin", isAdmin)
+		// Tokens issued for a password change stay limited to it
+		c.Locals("password_change", mustChange || claims["password_change"] == true)
 
 		return c.Next()
 	}
 }
 
-func CORSMiddleware() fiber.Handler {
-	// Allowed address
-	var allowedOrigins string = "http://127.0.0.1:" + os.Getenv("PORT")
+// PasswordChangeGuard refuses requests made with a token that only allows changing the
+// password. It must run after JWTProtected, routes registered before it stay reachable.
+func PasswordChangeGuard() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if restricted, _ := c.Locals("password_change").(bool); restricted {
+			return fiber.NewError(fiber.StatusForbidden, "Change your password before continuing")
+		}
+		return c.Next()
+	}
+}
 
-	return cors.New(cors.Config{
-		AllowOrigins: allowedOrigins,
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-		AllowMethods: "GET, POST, DELETE, OPTIONS, PUT",
-	})
+// TokenFromQuery lets clients that cannot set headers, such as the browser EventSource,
+// pass the JWT as ?token=. It must run before JWTProtected.
+func TokenFromQuery() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token := c.Query("token"); token != "" && c.Get("Authorization") == "" {
+			c.Request().Header.Set("Authorization", "Bearer "+token)
+		}
+		return c.Next()
+	}
 }
 
-func RateLimiterMiddleware() fiber.Handler {
-	// Rate limiter configs
-	max_limit, err := strconv.Atoi(os.Getenv("RATE_LIMIT_MAX"))
-	if err != nil {
-		max_limit = 20
+// validRequestID limits the request IDs accepted from clients to what is safe to log and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gives every request an ID, taken from the X-Request-ID header when the client or a
+// proxy sent a valid one. It is echoed in the response header and stored in c.Locals("request_id").
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Already assigned by an outer router
+		if _, ok := c.Locals("request_id").(string); ok {
+			return c.Next()
+		}
+
+		id := c.Get(fiber.HeaderXRequestID)
+		if !validRequestID.MatchString(id) {
+			id = utils.UUIDv4()
+		}
+
+		c.Locals("request_id", id)
+		c.Set(fiber.HeaderXRequestID, id)
+		return c.Next()
 	}
+}
 
-	rate_limit_exp, err := strconv.Atoi(os.Getenv("RATE_LIMIT_EXPIRATION_SECOND"))
+// CORSMiddleware applies the public CORS policy to the public paths and the API policy to
+// every other request.
+func CORSMiddleware(cfg *Config) (fiber.Handler, error) {
+	api, err := newCORSHandler(cfg.CORS, defaultOrigins(cfg))
 	if err != nil {
-		rate_limit_exp = 30
+		return nil, fmt.Errorf("cors: %w", err)
+	}
+	public, err := newCORSHandler(cfg.PublicCORS.CORSPolicy, nil)
+	if err != nil {
+		return nil, fmt.Errorf("public_cors: %w", err)
 	}
 
-	return limiter.New(limiter.Config{
-		Max:        max_limit,
-		Expiration: time.Duration(rate_limit_exp) * time.Second,
-		LimitReached: func(c *fiber.Ctx) error {
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Rate limit exceeded. Try again later."})
-		},
-	})
+	return func(c *fiber.Ctx) error {
+		if isPublicPath(c.Path(), cfg.PublicCORS.Paths) {
+			return public(c)
+		}
+		return api(c)
+	}, nil
+}
+
+// defaultOrigins are allowed when no origins are configured: the server's own address.
+func defaultOrigins(cfg *Config) []string {
+	scheme := "http"
+	if cfg.TLS.Mode != TLSOff {
+		scheme = "https"
+	}
+	port := strconv.Itoa(cfg.Port)
+	return []string{scheme + "://127.0.0.1:" + port, scheme + "://localhost:" + port}
 }
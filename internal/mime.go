@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBlockedMIMETypes are rejected at upload unless configured otherwise.
+const DefaultBlockedMIMETypes = "application/vnd.microsoft.portable-executable,application/x-executable,application/x-mach-binary"
+
+// sniffLen is the number of leading bytes inspected to detect a content type.
+const sniffLen = 512
+
+// magicTypes cover formats net/http does not sniff, executables in particular.
+var magicTypes = []struct {
+	prefix   []byte
+	mimeType string
+}{
+	{[]byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{[]byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("#!"), "text/x-shellscript"},
+}
+
+// inlineSafe lists the types that browsers can display without running scripts in our origin.
+var inlineSafe = map[string]bool{
+	"application/pdf": true,
+	"text/plain":      true,
+	"text/csv":        true,
+	"text/markdown":   true,
+}
+
+// DetectMIMEType returns the content type of a file from its first bytes, using the
+// filename only to refine generic results such as text/plain or application/zip.
+// The result never carries parameters, e.g. "text/plain" rather than "text/plain; charset=utf-8".
+func DetectMIMEType(filename string, head []byte) string {
+	sniffed := ""
+	for _, m := range magicTypes {
+		if bytes.HasPrefix(head, m.prefix) {
+			sniffed = m.mimeType
+			break
+		}
+	}
+	if sniffed == "" {
+		sniffed = baseMIMEType(http.DetectContentType(head))
+	}
+
+	byExt := baseMIMEType(mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))))
+	if byExt == "" {
+		return sniffed
+	}
+
+	switch {
+	case sniffed == "application/octet-stream":
+		// Nothing recognisable in the content, fall back to the extension
+		return byExt
+	case sniffed == "text/plain" && strings.HasPrefix(byExt, "text/"),
+		sniffed == "text/plain" && (strings.HasSuffix(byExt, "json") || strings.HasSuffix(byExt, "xml") || byExt == "application/javascript"):
+		// Source and data formats are plain text with a more precise name
+		return byExt
+	case sniffed == "application/zip" && (strings.Contains(byExt, "openxmlformats") || strings.Contains(byExt, "opendocument") || strings.HasSuffix(byExt, "+zip") || byExt == "application/epub+zip" || byExt == "application/java-archive"):
+		// Office documents, e-books and jars are zip containers
+		return byExt
+	}
+
+	return sniffed
+}
+
+// DetectMIMETypeFile opens path and detects its content type.
+func DetectMIMETypeFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return DetectMIMEType(path, head[:n]), nil
+}
+
+// IsBlockedMIMEType reports whether uploads of mimeType are refused by the blocked list.
+func IsBlockedMIMEType(mimeType string, blocked []string) bool {
+	return MatchesMIMEType(mimeType, blocked)
+}
+
+// MatchesMIMEType reports whether mimeType is in list. Entries may end in /* to match a
+// whole family, e.g. "video/*".
+func MatchesMIMEType(mimeType string, list []string) bool {
+	for _, entry := range list {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.HasSuffix(entry, "/*"):
+			if strings.HasPrefix(mimeType, strings.TrimSuffix(entry, "*")) {
+				return true
+			}
+		case entry == mimeType:
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsInlineSafe reports whether a file of mimeType may be displayed in the browser instead of downloaded.
+// HTML, SVG and other types that can carry scripts are always served as attachments.
+func IsInlineSafe(mimeType string) bool {
+	if inlineSafe[mimeType] {
+		return true
+	}
+	if mimeType == "image/svg+xml" {
+		return false
+	}
+	return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/")
+}
+
+// BackfillMIMETypes detects the content type of files stored before types were recorded.
+func BackfillMIMETypes(db *sql.DB) {
+	rows, err := db.Query(`SELECT id, path FROM metadata WHERE mime_type = ''`)
+	if err != nil {
+		Error.Println("Failed to find files without a content type:", err)
+		return
+	}
+
+	type pending struct {
+		id   int64
+		path string
+	}
+	var files []pending
+	for rows.Next() {
+		var f pending
+		if err := rows.Scan(&f.id, &f.path); err == nil {
+			files = append(files, f)
+		}
+	}
+	rows.Close()
+
+	for _, f := range files {
+		mimeType, err := DetectMIMETypeFile(f.path)
+		if err != nil {
+			// Missing files are reported by fsck
+			continue
+		}
+		if _, err := db.Exec(`UPDATE metadata SET mime_type = ? WHERE id = ?`, mimeType, f.id); err != nil {
+			Error.Printf("Failed to store content type of file %d: %v", f.id, err)
+		}
+	}
+}
+
+// baseMIMEType strips parameters such as charset from a content type.
+func baseMIMEType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType
+}
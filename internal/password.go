@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// maxPasswordBytes is the most bcrypt hashes, longer passwords are refused rather than cut.
+const maxPasswordBytes = 72
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	PasswordPolicyConfig
+	// breached holds the SHA-1 digests of the passwords in the breached list
+	breached map[[sha1.Size]byte]struct{}
+}
+
+// NewPasswordPolicy returns the policy of cfg, reading its breached password list.
+func NewPasswordPolicy(cfg PasswordPolicyConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{PasswordPolicyConfig: cfg, breached: map[[sha1.Size]byte]struct{}{}}
+	if cfg.BreachedListFile == "" {
+		return policy, nil
+	}
+
+	f, err := os.Open(cfg.BreachedListFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		policy.breached[breachedDigest(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return policy, nil
+}
+
+// breachedDigest returns the SHA-1 digest of a line of the breached list. Lines of 40 hex
+// digits, optionally followed by a colon and a count as in the Have I Been Pwned downloads,
+// are digests already. Any other line is a password.
+func breachedDigest(line string) [sha1.Size]byte {
+	hash, _, _ := strings.Cut(line, ":")
+	var digest [sha1.Size]byte
+	if len(hash) == hex.EncodedLen(sha1.Size) {
+		if _, err := hex.Decode(digest[:], []byte(hash)); err == nil {
+			return digest
+		}
+	}
+	return sha1.Sum([]byte(line))
+}
+
+// Check returns an error describing everything password lacks, or nil when it is allowed.
+func (p *PasswordPolicy) Check(password string) error {
+	var problems []string
+	if n := len([]rune(password)); n < p.MinLength {
+		problems = append(problems, fmt.Sprintf("be at least %d characters", p.MinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("be at most %d bytes", maxPasswordBytes))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	for _, class := range []struct {
+		required, present bool
+		name              string
+	}{
+		{p.RequireLowercase, lower, "a lowercase letter"},
+		{p.RequireUppercase, upper, "an uppercase letter"},
+		{p.RequireDigit, digit, "a digit"},
+		{p.RequireSymbol, symbol, "a symbol"},
+	} {
+		if class.required && !class.present {
+			problems = append(problems, "contain "+class.name)
+		}
+	}
+
+	if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+		problems = append(problems, "not be a password known from data breaches")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &PasswordError{Problems: problems}
+}
+
+// PasswordError is returned by Check for a password the policy does not allow.
+type PasswordError struct {
+	// Problems complete "password must", such as "contain a digit"
+	Problems []string
+}
+
+func (e *PasswordError) Error() string {
+	list := e.Problems[0]
+	if n := len(e.Problems); n > 1 {
+		list = strings.Join(e.Problems[:n-1], ", ") + " and " + e.Problems[n-1]
+	}
+	return "password must " + list
+}
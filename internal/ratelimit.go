@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Rate limit response headers. When several limits apply, the headers describe the one
+// with the fewest requests left.
+const (
+	HeaderRateLimitLimit     = "X-RateLimit-Limit"
+	HeaderRateLimitRemaining = "X-RateLimit-Remaining"
+	HeaderRateLimitReset     = "X-RateLimit-Reset"
+)
+
+// rateLimitSweepInterval is the least time between two deletions of expired counters.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter counts requests in fixed windows. The counters live in the rate_limits table,
+// so they are shared by every limiter on the same database and survive a restart. The
+// limits themselves come from the runtime settings, a change applies to the next request.
+type RateLimiter struct {
+	db       *sql.DB
+	settings *SettingsStore
+
+	lastSweep atomic.Int64
+}
+
+// NewRateLimiter returns a limiter keeping its counters in db.
+func NewRateLimiter(db *sql.DB, settings *SettingsStore) *RateLimiter {
+	return &RateLimiter{db: db, settings: settings}
+}
+
+// PerIP limits every request per client IP.
+func (l *RateLimiter) PerIP() fiber.Handler {
+	return l.limit("ip", func(s Settings) RateLimitConfig { return s.RateLimit }, clientIP, false)
+}
+
+// Login limits failed logins and registrations per client IP. Successful requests do not
+// count, but once the limit is reached even correct credentials wait for the window to end.
+func (l *RateLimiter) Login() fiber.Handler {
+	return l.limit("login", func(s Settings) RateLimitConfig { return s.LoginRateLimit }, clientIP, true)
+}
+
+// PerUser limits requests per logged in user. It must run after JWTProtected.
+func (l *RateLimiter) PerUser() fiber.Handler {
+	return l.limit("user", func(s Settings) RateLimitConfig { return s.UserRateLimit }, func(c *fiber.Ctx) string {
+		userID, _ := c.Locals("user_id").(string)
+		return userID
+	}, false)
+}
+
+func clientIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// limit returns a middleware counting requests under scope and the key of each request.
+// With failuresOnly, requests answered below status 400 are not counted.
+func (l *RateLimiter) limit(scope string, pick func(Settings) RateLimitConfig, key func(*fiber.Ctx) string, failuresOnly bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := pick(l.settings.Get())
+		id := key(c)
+		if !cfg.Enabled || id == "" {
+			return c.Next()
+		}
+		id = scope + ":" + id
+
+		hits, resetIn, err := l.hit(id, cfg)
+		if err != nil {
+			// A limiter that cannot count must not take the server down with it
+			if Error != nil {
+				Error.Println("Rate limiter failed:", err)
+			}
+			return c.Next()
+		}
+
+		remaining := cfg.Max - hits
+		setRateLimitHeaders(c, cfg.Max, max(remaining, 0), resetIn)
+		if remaining < 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(resetIn))
+			// Returned rather than written, so the server's ErrorHandler picks the body format
+			// even when the limiter runs before the API routes
+			return fiber.NewError(fiber.StatusTooManyRequests, "Rate limit exceeded. Try again later.")
+		}
+
+		err = c.Next()
+		if failuresOnly && err == nil && c.Response().StatusCode() < fiber.StatusBadRequest {
+			if _, undoErr := l.db.Exec(`UPDATE rate_limits SET hits = hits - 1 WHERE key = ? AND hits > 0`, id); undoErr != nil && Error != nil {
+				Error.Println("Rate limiter failed:", undoErr)
+			}
+		}
+		return err
+	}
+}
+
+// hit counts a request for key and returns the hits in the current window and the
+// seconds until it ends.
+func (l *RateLimiter) hit(key string, cfg RateLimitConfig) (int, int, error) {
+	now := time.Now().Unix()
+	l.sweep(now)
+
+	var hits int
+	var resetAt int64
+	err := l.db.QueryRow(`INSERT INTO rate_limits (key, hits, reset_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			hits = CASE WHEN reset_at <= ? THEN 1 ELSE hits + 1 END,
+			reset_at = CASE WHEN reset_at <= ? THEN excluded.reset_at ELSE reset_at END
+		RETURNING hits, reset_at`,
+		key, now+int64(cfg.ExpirationSeconds), now, now).Scan(&hits, &resetAt)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count request: %w", err)
+	}
+	return hits, int(resetAt - now), nil
+}
+
+// sweep deletes counters whose window has ended, at most once per rateLimitSweepInterval.
+func (l *RateLimiter) sweep(now int64) {
+	last := l.lastSweep.Load()
+	if now-last < int64(rateLimitSweepInterval.Seconds()) || !l.lastSweep.CompareAndSwap(last, now) {
+		return
+	}
+	if _, err := l.db.Exec(`DELETE FROM rate_limits WHERE reset_at <= ?`, now); err != nil && Error != nil {
+		Error.Println("Failed to delete expired rate limits:", err)
+	}
+}
+
+// setRateLimitHeaders describes a limit in the response unless another limit of the same
+// request has fewer requests left.
+func setRateLimitHeaders(c *fiber.Ctx, limit, remaining, resetIn int) {
+	if current, err := strconv.Atoi(string(c.Response().Header.Peek(HeaderRateLimitRemaining))); err == nil && current <= remaining {
+		return
+	}
+	c.Set(HeaderRateLimitLimit, strconv.Itoa(limit))
+	c.Set(HeaderRateLimitRemaining, strconv.Itoa(remaining))
+	c.Set(HeaderRateLimitReset, strconv.Itoa(resetIn))
+}
@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Registration policies.
+const (
+	// RegistrationClosed lets only admins create users
+	RegistrationClosed = "closed"
+	// RegistrationOpen also lets anyone create a regular account through /register
+	RegistrationOpen = "open"
+)
+
+// Settings are the runtime settings admins can change through the API without a restart.
+// A field an admin changed is stored as a JSON value in the settings table under its JSON
+// name, the other fields follow the configuration.
+type Settings struct {
+	// MaxUploadSizeMB limits one upload request. It cannot exceed the configured
+	// max_upload_size_mb, which is the request body limit of the server.
+	MaxUploadSizeMB int `json:"max_upload_size_mb"`
+	// AllowedMIMETypes, when not empty, are the only content types accepted at upload
+	AllowedMIMETypes []string `json:"allowed_mime_types"`
+	// BlockedMIMETypes are refused at upload
+	BlockedMIMETypes []string        `json:"blocked_mime_types"`
+	RateLimit        RateLimitConfig `json:"rate_limit"`
+	LoginRateLimit   RateLimitConfig `json:"login_rate_limit"`
+	UserRateLimit    RateLimitConfig `json:"user_rate_limit"`
+	Lockout          LockoutConfig   `json:"lockout"`
+	Bandwidth        BandwidthConfig `json:"bandwidth"`
+	Registration     string          `json:"registration"`
+	// DefaultQuotaMB limits the storage used by the files each user owns, 0 is unlimited
+	DefaultQuotaMB int64 `json:"default_quota_mb"`
+}
+
+// SettingsStore holds the current runtime settings and persists changes to them.
+type SettingsStore struct {
+	db *sql.DB
+	// maxUploadSizeMB is the configured request body limit, the ceiling for the runtime limit
+	maxUploadSizeMB int
+	// defaults are the settings taken from the configuration
+	defaults Settings
+
+	mu      sync.RWMutex
+	current Settings
+}
+
+// DefaultSettings returns the settings in effect before an admin changes them.
+func DefaultSettings(cfg *Config) Settings {
+	return Settings{
+		MaxUploadSizeMB:  cfg.MaxUploadSizeMB,
+		AllowedMIMETypes: []string{},
+		BlockedMIMETypes: append([]string{}, cfg.BlockedMIMETypes...),
+		RateLimit:        cfg.RateLimit,
+		LoginRateLimit:   cfg.LoginRateLimit,
+		UserRateLimit:    cfg.UserRateLimit,
+		Lockout:          cfg.Lockout,
+		Bandwidth:        cfg.Bandwidth,
+		Registration:     RegistrationClosed,
+		DefaultQuotaMB:   0,
+	}
+}
+
+// LoadSettings reads the stored runtime settings over the defaults from cfg.
+func LoadSettings(db *sql.DB, cfg *Config) (*SettingsStore, error) {
+	store := &SettingsStore{db: db, maxUploadSizeMB: cfg.MaxUploadSizeMB, defaults: DefaultSettings(cfg)}
+	settings := DefaultSettings(cfg)
+
+	defaults, err := settingValues(store.defaults)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]any, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+
+	stored := map[string]json.RawMessage{}
+	rows, err := db.Query(`SELECT key, value FROM settings WHERE key IN (`+strings.TrimSuffix(strings.Repeat("?,", len(keys)), ",")+`)`, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read settings: %w", err)
+		}
+		stored[key] = json.RawMessage(value)
+	}
+	rows.Close()
+
+	if len(stored) > 0 {
+		data, _ := json.Marshal(stored)
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return nil, fmt.Errorf("stored settings are not valid: %w", err)
+		}
+	}
+
+	// The configuration may have lowered the ceiling since the limit was stored
+	if settings.MaxUploadSizeMB > store.maxUploadSizeMB {
+		settings.MaxUploadSizeMB = store.maxUploadSizeMB
+	}
+
+	store.current = settings
+	return store, nil
+}
+
+// Get returns the current settings. The slices are shared and must not be modified.
+func (s *SettingsStore) Get() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Update applies a partial JSON update to the current settings. Fields that are not in
+// patch keep their value and a null field goes back to its configured value. The result is
+// validated, and only the fields in patch are stored, before it takes effect.
+func (s *SettingsStore) Update(patch []byte) (Settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return Settings{}, &SettingsError{Err: fmt.Errorf("invalid settings: %w", err)}
+	}
+	defaults, err := settingValues(s.defaults)
+	if err != nil {
+		return Settings{}, err
+	}
+	cleared := map[string]bool{}
+	for key, value := range fields {
+		if _, ok := defaults[key]; !ok {
+			return Settings{}, &SettingsError{Err: fmt.Errorf("invalid settings: unknown setting %q", key)}
+		}
+		if string(bytes.TrimSpace(value)) == "null" {
+			fields[key] = defaults[key]
+			cleared[key] = true
+		}
+	}
+	if patch, err = json.Marshal(fields); err != nil {
+		return Settings{}, err
+	}
+
+	// Decode over a deep copy so a failed update leaves the current settings untouched
+	data, err := json.Marshal(s.current)
+	if err != nil {
+		return Settings{}, err
+	}
+	var next Settings
+	if err := json.Unmarshal(data, &next); err != nil {
+		return Settings{}, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(patch))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&next); err != nil {
+		return Settings{}, &SettingsError{Err: fmt.Errorf("invalid settings: %w", err)}
+	}
+
+	next.AllowedMIMETypes = normalizeMIMEList(next.AllowedMIMETypes)
+	next.BlockedMIMETypes = normalizeMIMEList(next.BlockedMIMETypes)
+	if err := next.Validate(s.maxUploadSizeMB); err != nil {
+		return Settings{}, &SettingsError{Err: err}
+	}
+
+	values, err := settingValues(next)
+	if err != nil {
+		return Settings{}, err
+	}
+	changed := make(map[string]json.RawMessage, len(fields))
+	for key := range fields {
+		if cleared[key] {
+			changed[key] = nil
+		} else {
+			changed[key] = values[key]
+		}
+	}
+	if err := s.save(changed); err != nil {
+		return Settings{}, err
+	}
+
+	s.current = next
+	return next, nil
+}
+
+// save writes the changed settings in one transaction. A nil value removes the stored one.
+func (s *SettingsStore) save(values map[string]json.RawMessage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+	defer tx.Rollback()
+
+	for key, value := range values {
+		if value == nil {
+			_, err = tx.Exec(`DELETE FROM settings WHERE key = ?`, key)
+		} else {
+			_, err = tx.Exec(`INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)`, key, string(value))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save settings: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+	return nil
+}
+
+// SettingsError is returned by Update when the new settings are rejected.
+type SettingsError struct {
+	Err error
+}
+
+func (e *SettingsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SettingsError) Unwrap() error {
+	return e.Err
+}
+
+// Validate reports every invalid setting at once. maxUploadSizeMB is the configured ceiling.
+func (s Settings) Validate(maxUploadSizeMB int) error {
+	var errs []error
+	invalid := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{name}, args...)...))
+	}
+
+	if s.MaxUploadSizeMB < 1 || s.MaxUploadSizeMB > maxUploadSizeMB {
+		invalid("max_upload_size_mb", "must be between 1 and %d, the configured limit, got %d", maxUploadSizeMB, s.MaxUploadSizeMB)
+	}
+	for _, list := range []struct {
+		name    string
+		entries []string
+	}{{"allowed_mime_types", s.AllowedMIMETypes}, {"blocked_mime_types", s.BlockedMIMETypes}} {
+		for _, entry := range list.entries {
+			if kind, sub, ok := strings.Cut(entry, "/"); !ok || kind == "" || sub == "" || strings.ContainsAny(entry, ", ") {
+				invalid(list.name, "%q is not a content type such as image/png or video/*", entry)
+			}
+		}
+	}
+	for _, limit := range []struct {
+		name string
+		RateLimitConfig
+	}{{"rate_limit", s.RateLimit}, {"login_rate_limit", s.LoginRateLimit}, {"user_rate_limit", s.UserRateLimit}} {
+		if !limit.Enabled {
+			continue
+		}
+		if limit.Max < 1 {
+			invalid(limit.name+".max", "must be at least 1, got %d", limit.Max)
+		}
+		if limit.ExpirationSeconds < 1 {
+			invalid(limit.name+".expiration_seconds", "must be at least 1, got %d", limit.ExpirationSeconds)
+		}
+	}
+	if err := s.Lockout.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Bandwidth.DownloadKBps < 0 {
+		invalid("bandwidth.download_kbps", "must not be negative, got %d", s.Bandwidth.DownloadKBps)
+	}
+	if s.Bandwidth.UploadKBps < 0 {
+		invalid("bandwidth.upload_kbps", "must not be negative, got %d", s.Bandwidth.UploadKBps)
+	}
+	if s.Registration != RegistrationClosed && s.Registration != RegistrationOpen {
+		invalid("registration", "must be %q or %q, got %q", RegistrationClosed, RegistrationOpen, s.Registration)
+	}
+	if s.DefaultQuotaMB < 0 {
+		invalid("default_quota_mb", "must not be negative, got %d", s.DefaultQuotaMB)
+	}
+
+	return errors.Join(errs...)
+}
+
+// IsAllowedMIMEType reports whether uploads of mimeType are accepted by the settings.
+func (s Settings) IsAllowedMIMEType(mimeType string) bool {
+	if len(s.AllowedMIMETypes) > 0 && !MatchesMIMEType(mimeType, s.AllowedMIMETypes) {
+		return false
+	}
+	return !IsBlockedMIMEType(mimeType, s.BlockedMIMETypes)
+}
+
+// settingValues returns the JSON value of each setting by the key it is stored under.
+func settingValues(settings Settings) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// normalizeMIMEList lowercases and trims entries and drops empty ones.
+func normalizeMIMEList(list []string) []string {
+	normalized := []string{}
+	for _, entry := range list {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			normalized = append(normalized, entry)
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,348 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	// Register the decoders used by image.Decode
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailDir is the directory under FILES_DIR that caches generated thumbnails.
+const ThumbnailDir = ".thumbnails"
+
+// DefaultThumbnailSize is generated right after upload and used when no size is requested.
+const DefaultThumbnailSize = 256
+
+// ThumbnailSizes are the bounding boxes thumbnails can be rendered at.
+// Requests are rounded up to one of them so the cache stays small.
+var ThumbnailSizes = []int{64, 128, 256, 512}
+
+// maxThumbnailPixels rejects images that would take too much memory to decode.
+const maxThumbnailPixels = 50_000_000
+
+// Files that cannot be decoded are remembered for thumbnailFailureTTL, and at most
+// maxThumbnailFailures of them, so broken images are not retried on every request.
+const (
+	thumbnailFailureTTL  = time.Hour
+	maxThumbnailFailures = 4096
+)
+
+// ErrNoPreview is returned for files that are not a supported image or text file.
+var ErrNoPreview = errors.New("no preview available for this file")
+
+// thumbnailExtensions are the formats the thumbnailer can decode.
+var thumbnailExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+}
+
+type thumbnailJob struct {
+	src  string
+	dest string
+	size int
+}
+
+// ErrThumbnailerBusy is returned when a thumbnail cannot be queued right now.
+var ErrThumbnailerBusy = errors.New("thumbnail generation unavailable")
+
+// thumbnailQueue feeds the worker pool. It is nil until StartThumbnailer runs.
+// thumbnailFailed maps thumbnails of undecodable files to when they failed.
+var (
+	thumbnailQueue    chan thumbnailJob
+	thumbnailMu       sync.Mutex
+	thumbnailInFlight = make(map[string]bool)
+	thumbnailFailed   = make(map[string]time.Time)
+)
+
+// StartThumbnailer starts workers goroutines that render queued thumbnails.
+// It returns a function that stops the pool after the queue drains.
+func StartThumbnailer(workers int) func() {
+	if workers <= 0 {
+		workers = 2
+	}
+
+	queue := make(chan thumbnailJob, 256)
+	var wg sync.WaitGroup
+
+	thumbnailMu.Lock()
+	thumbnailQueue = queue
+	thumbnailMu.Unlock()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				err := GenerateThumbnail(job.src, job.dest, job.size)
+				if err != nil {
+					Error.Printf("Failed to generate thumbnail for %s: %v", job.src, err)
+				}
+
+				thumbnailMu.Lock()
+				delete(thumbnailInFlight, job.dest)
+				// Only unsupported files are remembered, I/O errors may go away on their own
+				if errors.Is(err, ErrNoPreview) {
+					rememberThumbnailFailure(job.dest)
+				}
+				thumbnailMu.Unlock()
+			}
+		}()
+	}
+
+	return func() {
+		thumbnailMu.Lock()
+		thumbnailQueue = nil
+		thumbnailMu.Unlock()
+
+		close(queue)
+		wg.Wait()
+	}
+}
+
+// HasThumbnail reports whether a thumbnail can be generated for filename.
+func HasThumbnail(filename string) bool {
+	return thumbnailExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// ThumbnailSize rounds a requested size up to a supported one. Zero selects the default.
+func ThumbnailSize(requested int) int {
+	if requested <= 0 {
+		return DefaultThumbnailSize
+	}
+	for _, size := range ThumbnailSizes {
+		if requested <= size {
+			return size
+		}
+	}
+	return ThumbnailSizes[len(ThumbnailSizes)-1]
+}
+
+// ThumbnailPath returns where the thumbnail of the current version of a file is cached.
+// The version is derived from the modification time and size, so replacing the file invalidates the cache.
+func ThumbnailPath(filesDir string, fileID int64, src string, size int) (string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	version := strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36)
+	name := fmt.Sprintf("%s-%d.jpg", version, size)
+
+	return filepath.Join(filesDir, ThumbnailDir, strconv.FormatInt(fileID, 10), name), nil
+}
+
+// QueueThumbnail schedules a thumbnail for generation. It never blocks: it returns
+// ErrThumbnailerBusy when the thumbnailer is not running or its queue is full,
+// and ErrNoPreview when an earlier attempt for the same version could not decode the file.
+func QueueThumbnail(src, dest string, size int) error {
+	thumbnailMu.Lock()
+	defer thumbnailMu.Unlock()
+
+	if failed, ok := thumbnailFailed[dest]; ok {
+		if time.Since(failed) < thumbnailFailureTTL {
+			return ErrNoPreview
+		}
+		delete(thumbnailFailed, dest)
+	}
+	if thumbnailQueue == nil {
+		return ErrThumbnailerBusy
+	}
+	if thumbnailInFlight[dest] {
+		return nil
+	}
+
+	select {
+	case thumbnailQueue <- thumbnailJob{src: src, dest: dest, size: size}:
+		thumbnailInFlight[dest] = true
+		return nil
+	default:
+		return ErrThumbnailerBusy
+	}
+}
+
+// rememberThumbnailFailure records that dest cannot be rendered. When the cache is full
+// expired entries are dropped first, then the oldest one. thumbnailMu must be held.
+func rememberThumbnailFailure(dest string) {
+	if len(thumbnailFailed) >= maxThumbnailFailures {
+		var oldest string
+		for d, failed := range thumbnailFailed {
+			if time.Since(failed) >= thumbnailFailureTTL {
+				delete(thumbnailFailed, d)
+			} else if oldest == "" || failed.Before(thumbnailFailed[oldest]) {
+				oldest = d
+			}
+		}
+		if len(thumbnailFailed) >= maxThumbnailFailures {
+			delete(thumbnailFailed, oldest)
+		}
+	}
+	thumbnailFailed[dest] = time.Now()
+}
+
+// QueueDefaultThumbnail schedules the default thumbnail of a freshly stored file when it is an image.
+func QueueDefaultThumbnail(filesDir string, fileID int64, src string) {
+	if !HasThumbnail(src) {
+		return
+	}
+
+	dest, err := ThumbnailPath(filesDir, fileID, src, DefaultThumbnailSize)
+	if err != nil {
+		return
+	}
+	QueueThumbnail(src, dest, DefaultThumbnailSize)
+}
+
+// GenerateThumbnail renders src to fit within a size x size box and writes it to dest as JPEG.
+// Thumbnails of older versions of the same file are removed.
+func GenerateThumbnail(src, dest string, size int) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return ErrNoPreview
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailPixels {
+		return fmt.Errorf("image dimensions %dx%d not supported: %w", cfg.Width, cfg.Height, ErrNoPreview)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return ErrNoPreview
+	}
+
+	// Keep the aspect ratio and never upscale
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > size || h > size {
+		if w >= h {
+			h = max(1, h*size/w)
+			w = size
+		} else {
+			w = max(1, w*size/h)
+			h = size
+		}
+	}
+
+	// JPEG has no alpha channel, so transparent images are flattened onto white
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial thumbnail
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := jpeg.Encode(tmp, dst, &jpeg.Options{Quality: 85}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return err
+	}
+
+	removeStaleThumbnails(dest)
+	return nil
+}
+
+// removeStaleThumbnails deletes cached thumbnails of other versions of the same file.
+func removeStaleThumbnails(dest string) {
+	dir, name := filepath.Split(dest)
+	version := name[:strings.LastIndex(name, "-")+1]
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), version) && !strings.HasPrefix(e.Name(), ".tmp-") {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// RemoveThumbnails deletes every cached thumbnail of a file.
+func RemoveThumbnails(filesDir string, fileID int64) error {
+	return os.RemoveAll(filepath.Join(filesDir, ThumbnailDir, strconv.FormatInt(fileID, 10)))
+}
+
+// maxPreviewBytes caps how much of a file is read for a text preview.
+const maxPreviewBytes = 64 << 10
+
+// TextPreview returns up to n lines from the start of a text file and whether the file continues.
+// Files that are not text return ErrNoPreview.
+func TextPreview(path string, n int) ([]string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	read, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, false, err
+	}
+	if !isText(path, head[:read]) {
+		return nil, false, ErrNoPreview
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(file, maxPreviewBytes))
+	scanner.Buffer(make([]byte, 0, 4096), maxPreviewBytes)
+
+	lines := make([]string, 0, n)
+	for scanner.Scan() {
+		if len(lines) == n {
+			return lines, true, nil
+		}
+		lines = append(lines, strings.ToValidUTF8(scanner.Text(), "�"))
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return lines, true, nil
+		}
+		return nil, false, err
+	}
+
+	// Hitting the byte limit also means the preview is cut short
+	info, err := file.Stat()
+	truncated := err == nil && info.Size() > maxPreviewBytes
+
+	return lines, truncated, nil
+}
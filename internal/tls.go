@@ -0,0 +1,326 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS modes.
+const (
+	// TLSOff serves plain HTTP
+	TLSOff = "off"
+	// TLSFiles serves the certificate and key in cert_file and key_file, reloading them when they change
+	TLSFiles = "files"
+	// TLSSelfSigned generates a certificate for LAN use into cert_file and key_file when there is none
+	TLSSelfSigned = "self_signed"
+	// TLSACME obtains certificates for the configured domains from an ACME CA such as Let's Encrypt
+	TLSACME = "acme"
+)
+
+// selfSignedValidity is how long a generated certificate is valid. It is regenerated at
+// startup once less than selfSignedRenewBefore is left.
+const (
+	selfSignedValidity    = 365 * 24 * time.Hour
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// CertReloader serves a certificate from files and loads it again after the files change,
+// so renewed certificates are picked up without a restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	// Interval is the least time between two checks of the files
+	Interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCertReloader loads the certificate in certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, Interval: 10 * time.Second}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It is meant for tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.Interval {
+		r.checked = time.Now()
+		if modTime, err := r.latestModTime(); err == nil && !modTime.Equal(r.modTime) {
+			// A half written pair fails to load, keep serving the old certificate until it is complete
+			if err := r.loadLocked(); err != nil {
+				Error.Println("Failed to reload TLS certificate, keeping the previous one:", err)
+			} else {
+				Info.Println("Reloaded TLS certificate from", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *CertReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked()
+}
+
+func (r *CertReloader) loadLocked() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+	return nil
+}
+
+// latestModTime returns the modification time of the newer of the two files.
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// EnsureSelfSignedCert generates a self-signed certificate into certFile and keyFile unless
+// they already hold one that is valid for a while longer. The certificate is valid for
+// localhost, the addresses of this machine, its hostname and hosts.
+func EnsureSelfSignedCert(certFile, keyFile string, hosts []string) error {
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Until(leaf.NotAfter) > selfSignedRenewBefore {
+			return nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate TLS certificate: %w", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"CloudBoxIO"}, CommonName: "CloudBoxIO self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range selfSignedHosts(hosts) {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to generate TLS certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode TLS key: %w", err)
+	}
+
+	// The key is written first so a reloader never sees the new certificate with the old key
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	Info.Printf("Generated a self-signed TLS certificate in %s for %v", certFile, append(template.DNSNames, ipStrings(template.IPAddresses)...))
+	return nil
+}
+
+// selfSignedHosts returns the names a self-signed certificate is valid for, without duplicates.
+func selfSignedHosts(extra []string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+	hosts = append(hosts, extra...)
+
+	seen := map[string]bool{}
+	unique := hosts[:0]
+	for _, host := range hosts {
+		if !seen[host] {
+			seen[host] = true
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+func ipStrings(ips []net.IP) []string {
+	out := make([]string, len(ips))
+	for i, ip := range ips {
+		out[i] = ip.String()
+	}
+	return out
+}
+
+// writePEM replaces path with a single PEM block, creating its directory.
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// NewTLSConfig returns the TLS settings of the server for cfg, or nil when TLS is off.
+// The handler serves the plain HTTP port: it redirects to HTTPS and, in ACME mode, also
+// answers the CA's http-01 challenges.
+func NewTLSConfig(cfg *Config) (*tls.Config, http.Handler, error) {
+	redirect := RedirectToHTTPS(cfg.Port)
+
+	switch cfg.TLS.Mode {
+	case TLSOff, "":
+		return nil, nil, nil
+
+	case TLSSelfSigned:
+		if err := EnsureSelfSignedCert(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.Hosts); err != nil {
+			return nil, nil, err
+		}
+		fallthrough
+
+	case TLSFiles:
+		reloader, err := NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+			NextProtos:     []string{"http/1.1"},
+		}, redirect, nil
+
+	case TLSACME:
+		manager, err := newACMEManager(cfg.TLS.ACME)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		// fasthttp does not speak HTTP/2, keep only the tls-alpn-01 challenge protocol
+		tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+		return tlsConfig, manager.HTTPHandler(redirect), nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown TLS mode %q", cfg.TLS.Mode)
+}
+
+func newACMEManager(cfg ACMEConfig) (*autocert.Manager, error) {
+	if err := os.MkdirAll(cfg.CacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache directory: %w", err)
+	}
+
+	client := &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	if cfg.CACertFile != "" {
+		// A private CA, such as a Pebble test server, is not in the system roots
+		pemData, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA certificate: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("ACME CA certificate file holds no PEM certificate")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Email:      cfg.Email,
+		Client:     client,
+	}, nil
+}
+
+// RedirectToHTTPS answers every request with a permanent redirect to the same URL on the
+// HTTPS port.
+func RedirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+
+		// 308 makes clients repeat other methods with their body instead of switching to GET
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery states recorded in the webhook delivery log.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookAllEvents subscribes a webhook to every event type.
+const WebhookAllEvents = "*"
+
+// WebhookEventTypes are the events a webhook can subscribe to.
+var WebhookEventTypes = []string{
+	EventFileCreated, EventFileDeleted, EventFileRenamed, EventFileShared, EventFileUnshared, EventFileUpdated,
+	EventUserCreated, EventUserUpdated, EventUserDeleted,
+}
+
+// WebhookEventAliases are other names accepted for event types when subscribing.
+// They are stored and delivered under the event type they stand for.
+var WebhookEventAliases = map[string]string{
+	"file.uploaded": EventFileCreated,
+}
+
+// WebhookConfig controls delivery of webhooks. Zero fields take the defaults.
+type WebhookConfig struct {
+	// MaxAttempts is how many times a delivery is tried before it is marked failed
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, doubled after every further failure up to maxRetryDelay
+	BaseDelay time.Duration
+	// Timeout bounds a single delivery request
+	Timeout time.Duration
+	// PollInterval is how often due retries are looked for
+	PollInterval time.Duration
+}
+
+func (cfg *WebhookConfig) setDefaults() {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+}
+
+// webhookWake nudges the dispatcher when a delivery becomes due and webhookDB receives the
+// deliveries of published events. Both are nil until StartWebhooks runs.
+var (
+	webhookWake   chan struct{}
+	webhookDB     *sql.DB
+	webhookWakeMu sync.RWMutex
+)
+
+// StartWebhooks records a delivery for every published event matching a registered webhook
+// and sends due deliveries in the background, retrying failures with exponential backoff.
+// It returns a function that stops delivery; pending deliveries resume on the next start.
+func StartWebhooks(db *sql.DB, cfg WebhookConfig) func() {
+	cfg.setDefaults()
+
+	wake := make(chan struct{}, 1)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	webhookWakeMu.Lock()
+	webhookWake = wake
+	webhookDB = db
+	webhookWakeMu.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		client := &http.Client{Timeout: cfg.Timeout}
+		ticker := time.NewTicker(cfg.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			dispatchWebhooks(db, client, cfg, done)
+
+			select {
+			case <-done:
+				return
+			case <-wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		webhookWakeMu.Lock()
+		webhookWake = nil
+		webhookDB = nil
+		webhookWakeMu.Unlock()
+
+		close(done)
+		wg.Wait()
+	}
+}
+
+// WakeWebhooks makes the dispatcher look for due deliveries now.
+func WakeWebhooks() {
+	webhookWakeMu.RLock()
+	defer webhookWakeMu.RUnlock()
+
+	if webhookWake == nil {
+		return
+	}
+
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// IsWebhookEvent reports whether name can be subscribed to.
+func IsWebhookEvent(name string) bool {
+	if _, ok := WebhookEventAliases[name]; ok || name == WebhookAllEvents {
+		return true
+	}
+	for _, t := range WebhookEventTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateWebhookSecret returns a random signing secret.
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SignWebhook returns the X-CloudBoxIO-Signature value for a payload: the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the webhook secret. Including the timestamp lets
+// receivers reject replayed deliveries.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// RetryWebhookDelivery makes a delivery due immediately. Failed deliveries get one more attempt.
+func RetryWebhookDelivery(db *sql.DB, deliveryID int64) (bool, error) {
+	res, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, next_attempt_at = ? WHERE id = ?`,
+		DeliveryPending, time.Now().UnixMilli(), deliveryID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	WakeWebhooks()
+	return true, nil
+}
+
+// recordWebhookDeliveries is called by PublishEvent. Deliveries are written before the
+// publisher carries on, unlike the event bus, which drops events for slow subscribers.
+func recordWebhookDeliveries(e Event) {
+	webhookWakeMu.RLock()
+	db := webhookDB
+	webhookWakeMu.RUnlock()
+
+	if db == nil {
+		return
+	}
+	if err := enqueueWebhooks(db, e); err != nil {
+		Error.Printf("Failed to queue webhooks for %s: %v", e.Type, err)
+	}
+}
+
+// enqueueWebhooks records a pending delivery of e for every webhook subscribed to it.
+// Events about personal files only go to webhooks that asked for them.
+func enqueueWebhooks(db *sql.DB, e Event) error {
+	rows, err := db.Query(`SELECT id, events, include_personal FROM webhooks`)
+	if err != nil {
+		return err
+	}
+
+	personal := e.FileID != 0 && !e.IsShared && !e.WasShared
+
+	var targets []int64
+	for rows.Next() {
+		var id int64
+		var events string
+		var includePersonal bool
+		if err := rows.Scan(&id, &events, &includePersonal); err != nil {
+			rows.Close()
+			return err
+		}
+		if personal && !includePersonal {
+			continue
+		}
+		if webhookSubscribed(events, e.Type) {
+			targets = append(targets, id)
+		}
+	}
+	rows.Close()
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	for _, id := range targets {
+		if _, err := db.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_type, payload, status, next_attempt_at) VALUES (?, ?, ?, ?, ?)`,
+			id, e.Type, string(payload), DeliveryPending, now); err != nil {
+			return err
+		}
+	}
+
+	WakeWebhooks()
+	return nil
+}
+
+// webhookSubscribed reports whether a comma separated subscription list covers eventType.
+func webhookSubscribed(events, eventType string) bool {
+	for _, name := range strings.Split(events, ",") {
+		if name == WebhookAllEvents || name == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type webhookDelivery struct {
+	id        int64
+	eventType string
+	payload   string
+	attempts  int
+	url       string
+	secret    string
+}
+
+// dispatchWebhooks sends every due delivery, stopping early on shutdown.
+func dispatchWebhooks(db *sql.DB, client *http.Client, cfg WebhookConfig, done <-chan struct{}) {
+	rows, err := db.Query(`SELECT d.id, d.event_type, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries AS d
+		JOIN webhooks AS w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at, d.id
+		LIMIT 100`, DeliveryPending, time.Now().UnixMilli())
+	if err != nil {
+		Error.Println("Failed to find due webhook deliveries:", err)
+		return
+	}
+
+	var due []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.id, &d.eventType, &d.payload, &d.attempts, &d.url, &d.secret); err == nil {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range due {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		code, err := sendWebhook(client, d)
+		attempts := d.attempts + 1
+
+		switch {
+		case err == nil:
+			_, err = db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, response_code = ?, last_error = '', delivered_at = CURRENT_TIMESTAMP WHERE id = ?`,
+				DeliverySucceeded, attempts, code, d.id)
+		case attempts >= cfg.MaxAttempts:
+			Error.Printf("Webhook delivery %d to %s failed after %d attempt(s): %v", d.id, d.url, attempts, err)
+			_, err = db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, response_code = ?, last_error = ? WHERE id = ?`,
+				DeliveryFailed, attempts, code, err.Error(), d.id)
+		default:
+			next := time.Now().Add(retryDelay(cfg.BaseDelay, attempts)).UnixMilli()
+			_, err = db.Exec(`UPDATE webhook_deliveries SET attempts = ?, response_code = ?, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+				attempts, code, err.Error(), next, d.id)
+		}
+		if err != nil {
+			Error.Printf("Failed to record webhook delivery %d: %v", d.id, err)
+		}
+	}
+}
+
+// maxRetryDelay caps the wait between two attempts of a delivery.
+const maxRetryDelay = time.Hour
+
+// retryDelay is the wait after the given number of failed attempts. It doubles step by step
+// so a large attempt count cannot overflow the duration.
+func retryDelay(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// sendWebhook posts a signed delivery and returns the response status. Anything but 2xx is an error.
+func sendWebhook(client *http.Client, d webhookDelivery) (int, error) {
+	body := []byte(d.payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CloudBoxIO-Webhook")
+	req.Header.Set("X-CloudBoxIO-Event", d.eventType)
+	req.Header.Set("X-CloudBoxIO-Delivery", strconv.FormatInt(d.id, 10))
+	req.Header.Set("X-CloudBoxIO-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-CloudBoxIO-Signature", SignWebhook(d.secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
 	"embed"
+	"errors"
+	"flag"
+	"fmt"
 	"io/fs"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -25,27 +30,37 @@ const Version = "1.3.0"
 var embeddedFiles embed.FS
 
 func main() {
-	// Ensure .env exists and is loaded
-	internal.CheckOrInitEnv()
+	// Run maintenance commands instead of the server when one is given
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Load the configuration from the defaults, config file, environment and flags
+	internal.LoadDotEnv()
+	cfg, err := internal.LoadConfig(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// Initiate logger
-	internal.InitLogger()
+	internal.InitLogger(cfg.Log)
 	internal.Info.Println("Starting server...")
 
 	// Initiate JWT
-	internal.InitJWT()
-
-	// Setup max upload size
-	maxUploadSize, err := strconv.Atoi(os.Getenv("MAX_UPLOAD_SIZE_MB"))
-	if err != nil || maxUploadSize <= 0 {
-		maxUploadSize = 100
+	if err := internal.InitJWT(cfg); err != nil {
+		internal.Error.Fatalln(err)
 	}
 
 	// Initiate server
 	app := fiber.New(fiber.Config{
 		AppName:          "CloudBoxIO",
 		DisableKeepalive: true,
-		BodyLimit:        maxUploadSize << 20,
+		BodyLimit:        cfg.MaxUploadSizeMB << 20,
+		ErrorHandler:     handlers.ErrorHandler,
 	})
 
 	// Initiate database
@@ -56,16 +71,53 @@ func main() {
 
 	defer db.CloseDB(database)
 
-	// Apply CORS globally
-	app.Use(internal.CORSMiddleware())
+	// Runtime settings admins can change without a restart
+	settings, err := internal.LoadSettings(database, cfg)
+	if err != nil {
+		internal.Error.Fatalln(err)
+	}
 
-	// Rate limiter
-	if os.Getenv("ENABLE_RATE_LIMIT") == "true" {
-		app.Use(internal.RateLimiterMiddleware())
+	// Periodic storage consistency check
+	if cfg.FsckIntervalHours > 0 {
+		stopFsck := internal.StartFsckScheduler(database, cfg.FilesDir, cfg.SharedDir, "logs/fsck-report.json", time.Duration(cfg.FsckIntervalHours)*time.Hour)
+		defer stopFsck()
+		internal.Info.Printf("Consistency check scheduled every %d hour(s)", cfg.FsckIntervalHours)
 	}
 
+	// Background content indexer for full-text search
+	stopIndexer := internal.StartIndexer(database)
+	defer stopIndexer()
+
+	// Record content types of files stored before they were detected at upload
+	go internal.BackfillMIMETypes(database)
+
+	// Hash files stored before content hashes were recorded, for sync clients
+	go internal.BackfillContentHashes(database)
+
+	// Bounded worker pool for thumbnail generation
+	stopThumbnailer := internal.StartThumbnailer(cfg.ThumbnailWorkers)
+	defer stopThumbnailer()
+
+	// Deliver events to registered webhooks, retrying failures with backoff
+	stopWebhooks := internal.StartWebhooks(database, internal.WebhookConfig{MaxAttempts: cfg.WebhookMaxAttempts})
+	defer stopWebhooks()
+
+	// Tag every request with an ID for logs and error responses
+	app.Use(internal.RequestID())
+
+	// Apply CORS globally
+	corsHandler, err := internal.CORSMiddleware(cfg)
+	if err != nil {
+		internal.Error.Fatalln(err)
+	}
+	app.Use(corsHandler)
+
+	// Rate limit per client IP, enabled and tuned by the runtime settings. The API adds
+	// stricter limits for logins and limits per user.
+	app.Use(internal.NewRateLimiter(database, settings).PerIP())
+
 	// Use default UI for the app
-	if os.Getenv("USE_DEFAULT_UI") == "true" {
+	if cfg.UseDefaultUI {
 		// Create a virtual filesystem to server frontend
 		subFS, err := fs.Sub(embeddedFiles, "frontend")
 		if err != nil {
@@ -78,39 +130,48 @@ func main() {
 		}))
 		internal.Info.Println("Serving embedded UI at /")
 	} else {
-		internal.Info.Printf("USE_DEFAULT_UI=false — UI not served")
+		internal.Info.Printf("use_default_ui is false — UI not served")
 	}
 
-	authHandler := handlers.NewAuthHandler(database, internal.Info, internal.Error)
-	fileHandler := handlers.NewFileHandler(database)
-
-	api := app.Group("/api")
-	//Public routes
-	api.Post("/login", authHandler.Login)
-
-	//Protected routes
-	api.Use(internal.JWTProtected())
-
-	// Files endpoint
-	api.Post("/upload:shared?", fileHandler.UploadFile)
-	api.Get("/files:keyword?:shared?", fileHandler.ListFiles)
-	api.Get("/file/:fileid", fileHandler.DownloadFile)
-	api.Delete("/file/:fileid", fileHandler.DeleteFile)
+	// Mount the API under /api/v2 and /api
+	if err := handlers.RegisterAPI(app, database, cfg, settings, internal.Info, internal.Error); err != nil {
+		internal.Error.Fatalln(err)
+	}
 
-	// User endpoints
-	api.Post("/signup", authHandler.SignUp)
-	api.Put("/reset-password", authHandler.ResetPassword)
-	api.Get("/user-info", authHandler.GetUserInfo)
-	api.Get("/users", authHandler.GetUsers)
-	api.Delete("/users/:id", authHandler.DeleteUser)
+	// Certificates for HTTPS, when enabled
+	tlsConfig, redirectHandler, err := internal.NewTLSConfig(cfg)
+	if err != nil {
+		internal.Error.Fatalln(err)
+	}
 
 	// Create and hold own TCP listener (not using fiber's listener)
-	addr := ":" + os.Getenv("PORT")
+	addr := ":" + strconv.Itoa(cfg.Port)
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		internal.Error.Fatalf("Failed to listen on %s: %v", addr, err)
 	}
-	internal.Info.Printf("Listening on %s", addr)
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		internal.Info.Printf("Listening on %s with TLS (%s)", addr, cfg.TLS.Mode)
+	} else {
+		internal.Info.Printf("Listening on %s", addr)
+	}
+
+	// Plain HTTP port redirecting to HTTPS
+	var redirectServer *http.Server
+	if tlsConfig != nil && cfg.TLS.RedirectPort != 0 {
+		redirectServer = &http.Server{
+			Addr:              ":" + strconv.Itoa(cfg.TLS.RedirectPort),
+			Handler:           redirectHandler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				internal.Error.Fatalf("HTTP redirect server failed: %v", err)
+			}
+		}()
+		internal.Info.Printf("Redirecting HTTP on %s to HTTPS", redirectServer.Addr)
+	}
 
 	go func() {
 		if err := app.Listener(ln); err != nil {
@@ -125,6 +186,13 @@ func main() {
 
 	internal.Info.Println("Shutting down server...")
 
+	// End open event streams so they do not hold up the shutdown
+	internal.CloseEvents()
+
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+
 	// Gracefully shutdown the server
 	if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
 		internal.Error.Printf("Shutdown error: %v", err)
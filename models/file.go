@@ -1,9 +1,88 @@
 package models
 
 type File struct {
+	FileID      string   `json:"file_id"`
+	Filename    string   `json:"filename"`
+	Size        int64    `json:"size"`
+	UploadedAt  string   `json:"uploaded_at"`
+	UploadedBy  string   `json:"uploaded_by"`
+	MimeType    string   `json:"mime_type"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Starred     bool     `json:"starred,omitempty"`
+	SHA256      string   `json:"sha256,omitempty"`
+}
+
+type BatchRequest struct {
+	FileIDs    []string `json:"file_ids"`
+	Folder     string   `json:"folder"`
+	Shared     bool     `json:"shared"`
+	Tags       []string `json:"tags"`
+	RemoveTags []string `json:"remove_tags"`
+}
+
+type BatchResult struct {
+	FileID string `json:"file_id"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+type SearchHit struct {
+	File
+	IsShared bool    `json:"is_shared"`
+	Snippet  string  `json:"snippet"`
+	Score    float64 `json:"score"`
+}
+
+type FilePreview struct {
+	FileID    string   `json:"file_id"`
+	Lines     []string `json:"lines"`
+	Truncated bool     `json:"truncated"`
+}
+
+type FileMeta struct {
+	FileID      string            `json:"file_id"`
+	Description string            `json:"description"`
+	Tags        []string          `json:"tags"`
+	Properties  map[string]string `json:"properties"`
+}
+
+// MetaUpdate changes only the fields that are present. Tags replace the current set,
+// properties are merged and a null value removes the key.
+type MetaUpdate struct {
+	Description *string            `json:"description"`
+	Tags        *[]string          `json:"tags"`
+	Properties  map[string]*string `json:"properties"`
+}
+
+type FavoriteFile struct {
+	File
+	IsShared  bool   `json:"is_shared"`
+	StarredAt string `json:"starred_at"`
+}
+
+type RecentFile struct {
+	File
+	IsShared   bool   `json:"is_shared"`
+	Action     string `json:"action"`
+	AccessedAt string `json:"accessed_at"`
+}
+
+type Change struct {
+	Seq        int64  `json:"seq"`
+	Action     string `json:"action"`
 	FileID     string `json:"file_id"`
 	Filename   string `json:"filename"`
+	IsShared   bool   `json:"is_shared"`
 	Size       int64  `json:"size"`
-	UploadedAt string `json:"uploaded_at"`
-	UploadedBy string `json:"uploaded_by"`
+	SHA256     string `json:"sha256,omitempty"`
+	ModifiedAt string `json:"modified_at,omitempty"`
+	ChangedAt  string `json:"changed_at"`
+}
+
+type ChangeList struct {
+	Changes []Change `json:"changes"`
+	// Cursor is passed back as ?since= to fetch the changes after this page
+	Cursor  string `json:"cursor"`
+	HasMore bool   `json:"has_more"`
 }
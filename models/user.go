@@ -7,17 +7,45 @@ type User struct {
 }
 
 type UserInfo struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	IsAdmin  bool   `json:"is_admin"`
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email"`
+	IsAdmin     bool   `json:"is_admin"`
+	// Disabled users can neither log in nor use the tokens they hold
+	Disabled bool `json:"disabled"`
+	// MustChangePassword is set until the user replaces a password chosen by an admin
+	MustChangePassword bool `json:"must_change_password"`
 }
 
 type SignUp struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	IsAdmin  bool   `json:"is_admin"`
+	// MustChangePassword makes the user choose a new password after the first login
+	MustChangePassword bool `json:"must_change_password"`
+}
+
+// UserUpdate changes only the fields that are present. Users may change their own display
+// name and email, the other fields only admins.
+type UserUpdate struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+	IsAdmin     *bool   `json:"is_admin"`
+	Disabled    *bool   `json:"disabled"`
 }
 
+// What happens to the personal files of a deleted user. Shared files stay shared in any case.
+const (
+	// UserFilesArchive moves the files to the archive directory of deleted users
+	UserFilesArchive = "archive"
+	// UserFilesTransfer gives the files to another user, in a folder named after the deleted one
+	UserFilesTransfer = "transfer"
+	// UserFilesPurge deletes the files
+	UserFilesPurge = "purge"
+)
+
 type Login struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -27,3 +55,23 @@ type ResetPassword struct {
 	CurrentPassword string `json:"current_password"`
 	NewPassword     string `json:"new_password"`
 }
+
+// LoginAttempt is one entry of the login history of a user.
+type LoginAttempt struct {
+	ID int64  `json:"id"`
+	IP string `json:"ip"`
+	// Result is success, failure or locked, an attempt refused without checking the password
+	Result    string `json:"result"`
+	CreatedAt string `json:"created_at"`
+}
+
+// PasswordResetToken lets a user choose a new password once, without the current one.
+type PasswordResetToken struct {
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type TokenPasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
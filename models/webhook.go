@@ -0,0 +1,31 @@
+package models
+
+type Webhook struct {
+	ID              int64    `json:"id"`
+	URL             string   `json:"url"`
+	Events          []string `json:"events"`
+	IncludePersonal bool     `json:"include_personal"`
+	CreatedAt       string   `json:"created_at"`
+	// Secret is only returned when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+type CreateWebhook struct {
+	URL             string   `json:"url"`
+	Events          []string `json:"events"`
+	Secret          string   `json:"secret"`
+	IncludePersonal bool     `json:"include_personal"`
+}
+
+type WebhookDelivery struct {
+	ID           int64  `json:"id"`
+	WebhookID    int64  `json:"webhook_id"`
+	EventType    string `json:"event_type"`
+	Payload      string `json:"payload"`
+	Status       string `json:"status"`
+	Attempts     int    `json:"attempts"`
+	ResponseCode int    `json:"response_code"`
+	LastError    string `json:"last_error,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	DeliveredAt  string `json:"delivered_at,omitempty"`
+}
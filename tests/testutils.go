@@ -5,6 +5,7 @@ import (
 	"log"
 	"testing"
 
+	cbdb "github.com/AumSahayata/cloudboxio/db"
 	_ "modernc.org/sqlite"
 )
 
@@ -27,7 +28,7 @@ func SetupTestDB(t *testing.T) *sql.DB {
 	_, err = db.Exec(`
 	CREATE TABLE IF NOT EXISTS users (
 		id TEXT PRIMARY KEY,
-		username TEXT,
+		username TEXT UNIQUE NOT NULL,
 		password TEXT,
 		is_admin BOOL
 	);
@@ -51,6 +52,10 @@ func SetupTestDB(t *testing.T) *sql.DB {
 		t.Fatalf("failed to create metadata table: %v", err)
 	}
 
+	if err := cbdb.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+
 	return db
 }
 